@@ -0,0 +1,139 @@
+package parsers
+
+import (
+	"strings"
+)
+
+// AuthenticationResult는 Authentication-Results 헤더(RFC 8601)의 method 결과 하나입니다
+type AuthenticationResult struct {
+	AuthServID string `json:"authserv_id"`
+	Method     string `json:"method"`
+	Result     string `json:"result"`
+	// reason= 값과 header.i, smtp.mailfrom 같은 속성들
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// RFC 8601 2.7절 및 RFC 7001에서 쓰이는 결과 값
+var authResultValues = map[string]bool{
+	"none": true, "pass": true, "fail": true, "softfail": true, "neutral": true,
+	"temperror": true, "permerror": true, "policy": true, "hardfail": true,
+	"discard": true,
+}
+
+// ParseAuthenticationResults는 Authentication-Results 헤더 값 하나를 파싱합니다.
+// SES처럼 "spf=pass ...; envelope-from=...; helo=...;" 형태로 속성을 ';'로 나눠 쓰는
+// 구현도 있어서, 결과 값이 아닌 method=value 항목은 직전 method의 속성으로 붙입니다.
+func ParseAuthenticationResults(value string) []AuthenticationResult {
+	sections := splitQuoted(stripComments(value), func(c byte) bool { return c == ';' })
+	authServID := strings.TrimSpace(sections[0])
+	if fields := strings.Fields(authServID); len(fields) > 0 {
+		// authserv-id 뒤에 버전 번호가 올 수 있음
+		authServID = fields[0]
+	}
+
+	var results []AuthenticationResult
+	for _, section := range sections[1:] {
+		tokens := fieldsQuoted(section)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		method, result, ok := strings.Cut(tokens[0], "=")
+		if !ok {
+			continue
+		}
+		method = strings.ToLower(method)
+		// "dkim/1=pass"처럼 method 버전이 붙은 경우
+		method, _, _ = strings.Cut(method, "/")
+
+		if !authResultValues[strings.ToLower(result)] {
+			if len(results) > 0 {
+				addProperties(&results[len(results)-1], tokens)
+			}
+			continue
+		}
+
+		results = append(results, AuthenticationResult{
+			AuthServID: authServID,
+			Method:     method,
+			Result:     strings.ToLower(result),
+		})
+		addProperties(&results[len(results)-1], tokens[1:])
+	}
+
+	return results
+}
+
+func addProperties(result *AuthenticationResult, tokens []string) {
+	for _, token := range tokens {
+		key, value, ok := strings.Cut(token, "=")
+		if !ok {
+			continue
+		}
+		if result.Properties == nil {
+			result.Properties = map[string]string{}
+		}
+		result.Properties[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+}
+
+// stripComments는 RFC 5322 comment("(...)")를 제거합니다
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(value[i+1])
+			}
+			i++
+		case c == '"' && depth == 0:
+			quoted = !quoted
+			b.WriteByte(c)
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+			b.WriteByte(' ')
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitQuoted는 따옴표 안을 제외하고 sep 기준으로 나눕니다
+func splitQuoted(value string, sep func(byte) bool) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' {
+			quoted = !quoted
+		}
+		if !quoted && sep(value[i]) {
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+// fieldsQuoted는 따옴표 안을 제외하고 공백 기준으로 나누며, 빈 조각은 버립니다
+func fieldsQuoted(value string) []string {
+	var fields []string
+	for _, field := range splitQuoted(value, isSpace) {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
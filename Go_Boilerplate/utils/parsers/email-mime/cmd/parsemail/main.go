@@ -0,0 +1,115 @@
+// parsemail은 .eml 파일(또는 stdin)을 파싱해서 parsers.Document JSON으로 출력합니다.
+//
+//	parsemail [-pretty] [-extract DIR] [file.eml ...]
+//	parsemail -schema
+//
+// 파일을 여러 개 주면 한 줄에 Document 하나씩(JSON Lines) 출력합니다 (-pretty는 파일 하나일 때만).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run은 main의 본체입니다 (종료 코드를 반환, 테스트에서 직접 호출)
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("parsemail", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	pretty := flags.Bool("pretty", false, "들여쓰기된 JSON으로 출력 (파일 하나일 때만)")
+	extractDir := flags.String("extract", "", "첨부파일을 저장할 디렉토리")
+	printSchema := flags.Bool("schema", false, "JSON Schema를 출력하고 종료")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *printSchema {
+		stdout.Write(parsers.JSONSchema)
+		return 0
+	}
+
+	sources := flags.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+	// 여러 줄로 나뉜 JSON은 JSON Lines가 아니므로
+	if *pretty && len(sources) > 1 {
+		fmt.Fprintln(stderr, "parsemail: -pretty cannot be used with more than one file (output is JSON Lines)")
+		return 2
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetEscapeHTML(false)
+	if *pretty {
+		encoder.SetIndent("", "  ")
+	}
+
+	exitCode := 0
+	for _, source := range sources {
+		raw, err := readSource(source, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "parsemail: %v\n", err)
+			exitCode = 1
+			continue
+		}
+
+		doc := parsers.NewDocument(source, string(raw))
+		if doc.Error != "" {
+			fmt.Fprintf(stderr, "parsemail: %s: %s\n", source, doc.Error)
+			exitCode = 1
+		}
+
+		if doc.Email != nil && *extractDir != "" {
+			if err := extractAttachments(*extractDir, doc.Email.Attachments); err != nil {
+				fmt.Fprintf(stderr, "parsemail: %s: %v\n", source, err)
+				exitCode = 1
+			}
+		}
+
+		if err := encoder.Encode(doc); err != nil {
+			fmt.Fprintf(stderr, "parsemail: %v\n", err)
+			return 1
+		}
+	}
+
+	return exitCode
+}
+
+func readSource(source string, stdin io.Reader) ([]byte, error) {
+	if source == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(source)
+}
+
+// extractAttachments는 첨부파일을 "<sha256 앞 12자>-<파일명>"으로 저장합니다.
+// 파일명은 메일에서 온 값이므로 경로 부분은 버립니다.
+func extractAttachments(dir string, attachments []parsers.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		name := filepath.Base(filepath.Clean("/" + attachment.Filename))
+		if name == "/" || name == "." {
+			name = "attachment"
+		}
+		path := filepath.Join(dir, attachment.SHA256[:12]+"-"+name)
+		if err := os.WriteFile(path, attachment.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const plainMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"Message-ID: <1@example.com>\r\n" +
+	"Date: Sat, 17 Oct 2026 09:00:00 +0000\r\n" +
+	"\r\n" +
+	"hello\r\n"
+
+const attachmentMessage = "From: alice@example.com\r\n" +
+	"To: bob@example.com, carol@example.com\r\n" +
+	"Subject: Report\r\n" +
+	"Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=example.com\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"see attached\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/csv; name=report.csv\r\n" +
+	"Content-Disposition: attachment; filename=report.csv\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxiCjEsMgo=\r\n" +
+	"--b1--\r\n"
+
+// compileSchema는 parsers.JSONSchema를 검증기로 컴파일합니다
+func compileSchema(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	require.NoError(t, compiler.AddResource("parsed_email.schema.json", bytes.NewReader(parsers.JSONSchema)))
+	schema, err := compiler.Compile("parsed_email.schema.json")
+	require.NoError(t, err)
+	return schema
+}
+
+// writeMessages는 messages를 .eml 파일로 만들고 경로를 반환합니다
+func writeMessages(t *testing.T, messages ...string) []string {
+	t.Helper()
+
+	dir := t.TempDir()
+	var paths []string
+	for i, message := range messages {
+		path := filepath.Join(dir, string(rune('a'+i))+".eml")
+		require.NoError(t, os.WriteFile(path, []byte(message), 0o600))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestRunOutputMatchesSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := compileSchema(t)
+	paths := writeMessages(t, plainMessage, attachmentMessage)
+	missing := filepath.Join(t.TempDir(), "missing.eml")
+
+	var stdout, stderr bytes.Buffer
+	code := run(append(paths, missing), nil, &stdout, &stderr)
+	// 읽지 못한 파일이 있으면 1이지만 나머지는 출력
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "missing.eml")
+
+	// 한 줄에 Document 하나 (JSON Lines)
+	var lines int
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		lines++
+		var doc any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &doc))
+		assert.NoError(t, schema.Validate(doc), scanner.Text())
+	}
+	assert.Equal(t, len(paths), lines)
+
+	// 파싱에 실패한 Document도 스키마를 따름
+	stdout.Reset()
+	assert.Equal(t, 1, run([]string{"-"}, strings.NewReader("not a message"), &stdout, &stderr))
+	var doc any
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &doc))
+	assert.NoError(t, schema.Validate(doc))
+
+	// -schema는 내장 스키마를 그대로 출력
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"-schema"}, nil, &stdout, &stderr))
+	assert.Equal(t, parsers.JSONSchema, stdout.Bytes())
+}
+
+func TestRunPretty(t *testing.T) {
+	t.Parallel()
+
+	schema := compileSchema(t)
+	paths := writeMessages(t, attachmentMessage, plainMessage)
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"-pretty", paths[0]}, nil, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "\n  \"")
+	var doc any
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &doc))
+	assert.NoError(t, schema.Validate(doc))
+
+	// 여러 파일을 들여쓰면 JSON Lines가 깨지므로 거부
+	stdout.Reset()
+	assert.Equal(t, 2, run([]string{"-pretty", paths[0], paths[1]}, nil, &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "-pretty")
+}
+
+func TestRunExtract(t *testing.T) {
+	t.Parallel()
+
+	paths := writeMessages(t, attachmentMessage)
+	dir := filepath.Join(t.TempDir(), "out")
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"-extract", dir, paths[0]}, nil, &stdout, &stderr))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, strings.HasSuffix(entries[0].Name(), "-report.csv"))
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, "a,b\n1,2\n", string(data))
+}
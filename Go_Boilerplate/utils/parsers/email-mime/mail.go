@@ -1,27 +1,60 @@
 package parsers
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/mail"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
 )
 
+// ParsedEmail의 JSON 표현은 parsed_email.schema.json(SchemaVersion)을 따릅니다.
+// 필드를 추가/변경할 때는 스키마와 SchemaVersion도 함께 수정해야 합니다.
 type ParsedEmail struct {
-	From        string
-	To          []string
-	Subject     string
-	TextBody    string
-	HTMLBody    string
-	Attachments []Attachment
+	// 디코딩하지 않은 원본 헤더 (키는 MIME canonical 형태)
+	Headers  map[string][]string `json:"headers,omitempty"`
+	From     string              `json:"from"`
+	To       []string            `json:"to"`
+	Cc       []string            `json:"cc,omitempty"`
+	Subject  string              `json:"subject"`
+	TextBody string              `json:"text_body"`
+	HTMLBody string              `json:"html_body"`
+	// 메일에 등장한 순서대로의 본문 파트 목록
+	BodyParts   []BodyPart   `json:"body_parts"`
+	Attachments []Attachment `json:"attachments"`
+	// Authentication-Results 헤더 (RFC 8601) 파싱 결과
+	AuthenticationResults []AuthenticationResult `json:"authentication_results,omitempty"`
+	// 파싱은 성공했지만 fallback 처리된 부분에 대한 경고
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// BodyPart는 text/plain 또는 text/html 본문 조각 하나를 나타냅니다
+type BodyPart struct {
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
 }
 
 type Attachment struct {
-	Filename    string
-	ContentType string
-	Data        []byte
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	// Data의 SHA-256 (hex)
+	SHA256 string `json:"sha256"`
+	Data   []byte `json:"-"`
+}
+
+// bodyRendering은 하나의 MIME 파트(하위 파트 포함)를 텍스트/HTML로 렌더링한 결과입니다
+type bodyRendering struct {
+	text string
+	html string
 }
 
 func ParseEmail(rawEmail string) (ParsedEmail, error) {
@@ -30,37 +63,34 @@ func ParseEmail(rawEmail string) (ParsedEmail, error) {
 		return ParsedEmail{}, err
 	}
 
+	email := ParsedEmail{
+		Headers: msg.Header,
+	}
+
 	// MIME encoded-word 디코딩
-	dec := &mime.WordDecoder{}
-	from, _ := dec.DecodeHeader(msg.Header.Get("From"))
-	subject, _ := dec.DecodeHeader(msg.Header.Get("Subject"))
-
-	// To 헤더 파싱 (RFC 5322 호환)
-	toList := []string{}
-	toHeader := msg.Header.Get("To")
-	if toHeader != "" {
-		if addrs, err := mail.ParseAddressList(toHeader); err == nil {
-			for _, addr := range addrs {
-				toList = append(toList, addr.String())
-			}
-		} else {
-			// ParseAddressList 실패시 fallback (전체 헤더를 하나의 수신자로)
-			toList = append(toList, strings.TrimSpace(toHeader))
-		}
+	email.From = email.decodeHeader(msg.Header, "From")
+	email.Subject = email.decodeHeader(msg.Header, "Subject")
+
+	email.To = email.parseAddressList(msg.Header, "To")
+	if email.To == nil {
+		email.To = []string{}
 	}
+	email.Cc = email.parseAddressList(msg.Header, "Cc")
 
-	email := ParsedEmail{
-		From:    from,
-		To:      toList,
-		Subject: subject,
+	for _, value := range msg.Header["Authentication-Results"] {
+		email.AuthenticationResults = append(email.AuthenticationResults, ParseAuthenticationResults(value)...)
 	}
 
 	// Content-Type 파싱
 	contentType := msg.Header.Get("Content-Type")
 	mediaType, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
+		if contentType != "" {
+			email.warn("invalid Content-Type %q, treated as text/plain", contentType)
+		}
 		// Content-Type이 없으면 단순 텍스트로 처리
 		body, _ := io.ReadAll(msg.Body)
+		email.addBody("text/plain", string(body))
 		email.TextBody = string(body)
 		return email, nil
 	}
@@ -69,53 +99,19 @@ func ParseEmail(rawEmail string) (ParsedEmail, error) {
 		boundary := params["boundary"]
 		if boundary == "" {
 			// boundary가 없으면 전체를 TextBody로
+			email.warn("%s without boundary, treated as text/plain", mediaType)
 			body, _ := io.ReadAll(msg.Body)
+			email.addBody("text/plain", string(body))
 			email.TextBody = string(body)
 			return email, nil
 		}
-		mr := multipart.NewReader(msg.Body, boundary)
-
-		for {
-			part, err := mr.NextPart()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return ParsedEmail{}, err
-			}
-
-			contentType := part.Header.Get("Content-Type")
-			contentTransferEncoding := part.Header.Get("Content-Transfer-Encoding")
-
-			body, err := io.ReadAll(part)
-			if err != nil {
-				return ParsedEmail{}, err
-			}
-
-			// Base64 디코딩
-			if strings.EqualFold(contentTransferEncoding, "base64") {
-				decoded, err := base64.StdEncoding.DecodeString(string(body))
-				if err == nil {
-					body = decoded
-				}
-			}
 
-			if strings.Contains(contentType, "text/plain") {
-				email.TextBody = string(body)
-			} else if strings.Contains(contentType, "text/html") {
-				email.HTMLBody = string(body)
-			} else {
-				// 첨부파일
-				filename := part.FileName()
-				if filename != "" {
-					email.Attachments = append(email.Attachments, Attachment{
-						Filename:    filename,
-						ContentType: contentType,
-						Data:        body,
-					})
-				}
-			}
+		rendering, err := email.parseMultipart(msg.Body, mediaType, boundary)
+		if err != nil {
+			return ParsedEmail{}, err
 		}
+		email.TextBody = rendering.text
+		email.HTMLBody = rendering.html
 	} else {
 		// single part
 		body, err := io.ReadAll(msg.Body)
@@ -123,12 +119,182 @@ func ParseEmail(rawEmail string) (ParsedEmail, error) {
 			return ParsedEmail{}, err
 		}
 
+		text := email.decodeText(body, params["charset"], contentType)
 		if strings.Contains(mediaType, "text/html") {
-			email.HTMLBody = string(body)
+			email.addBody("text/html", text)
+			email.HTMLBody = text
 		} else {
-			email.TextBody = string(body)
+			email.addBody("text/plain", text)
+			email.TextBody = text
 		}
 	}
 
 	return email, nil
 }
+
+// parseMultipart는 multipart 본문을 재귀적으로 순회하며 본문 파트와 첨부파일을 수집합니다.
+// multipart/alternative는 같은 내용의 다른 표현이므로 종류별로 마지막(가장 충실한) 파트를 고르고,
+// 그 외 multipart(mixed, related 등)는 등장 순서대로 이어 붙입니다.
+func (email *ParsedEmail) parseMultipart(r io.Reader, mediaType, boundary string) (bodyRendering, error) {
+	var rendering bodyRendering
+	alternative := mediaType == "multipart/alternative"
+
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bodyRendering{}, err
+		}
+
+		child, err := email.parsePart(part)
+		if err != nil {
+			return bodyRendering{}, err
+		}
+
+		if alternative {
+			if child.text != "" {
+				rendering.text = child.text
+			}
+			if child.html != "" {
+				rendering.html = child.html
+			}
+		} else {
+			rendering.text += child.text
+			rendering.html += child.html
+		}
+	}
+
+	return rendering, nil
+}
+
+// parsePart는 multipart의 파트 하나를 처리합니다
+func (email *ParsedEmail) parsePart(part *multipart.Part) (bodyRendering, error) {
+	contentType := part.Header.Get("Content-Type")
+	contentTransferEncoding := part.Header.Get("Content-Transfer-Encoding")
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		return email.parseMultipart(part, mediaType, params["boundary"])
+	}
+
+	body, err := io.ReadAll(part)
+	if err != nil {
+		return bodyRendering{}, err
+	}
+
+	// Base64 디코딩
+	if strings.EqualFold(contentTransferEncoding, "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err == nil {
+			body = decoded
+		} else {
+			email.warn("invalid base64 in %q part, kept undecoded: %v", contentType, err)
+		}
+	}
+
+	// Content-Disposition: attachment인 텍스트 파트는 본문이 아니라 첨부파일
+	disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	isAttachment := strings.EqualFold(disposition, "attachment")
+
+	if !isAttachment && strings.Contains(contentType, "text/plain") {
+		text := email.decodeText(body, params["charset"], contentType)
+		email.addBody("text/plain", text)
+		return bodyRendering{text: text}, nil
+	}
+	if !isAttachment && strings.Contains(contentType, "text/html") {
+		text := email.decodeText(body, params["charset"], contentType)
+		email.addBody("text/html", text)
+		return bodyRendering{html: text}, nil
+	}
+
+	// 첨부파일
+	filename := part.FileName()
+	if filename != "" || isAttachment {
+		sum := sha256.Sum256(body)
+		email.Attachments = append(email.Attachments, Attachment{
+			Filename:    filename,
+			ContentType: contentType,
+			Size:        len(body),
+			SHA256:      hex.EncodeToString(sum[:]),
+			Data:        body,
+		})
+	}
+
+	return bodyRendering{}, nil
+}
+
+func (email *ParsedEmail) addBody(contentType, content string) {
+	email.BodyParts = append(email.BodyParts, BodyPart{
+		ContentType: contentType,
+		Content:     content,
+	})
+}
+
+// decodeHeader는 MIME encoded-word를 디코딩합니다. 실패하면 원본 값을 그대로 씁니다.
+func (email *ParsedEmail) decodeHeader(header mail.Header, key string) string {
+	value := header.Get(key)
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
+	decoded, err := dec.DecodeHeader(value)
+	if err != nil {
+		email.warn("cannot decode %s header: %v", key, err)
+		return value
+	}
+	return decoded
+}
+
+// charsetReader는 charset(WHATWG 이름이나 별칭, 예: EUC-KR, ISO-2022-JP, Shift_JIS)의 입력을 UTF-8로 바꿉니다
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// decodeText는 본문 파트를 charset에서 UTF-8로 바꿉니다 (charset이 없거나 UTF-8, US-ASCII면 그대로).
+// 모르는 charset이거나 변환에 실패하면 바이트를 그대로 두고, UTF-8이 아닌 본문은 경고로 남깁니다.
+func (email *ParsedEmail) decodeText(body []byte, charset, contentType string) string {
+	if charset != "" && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "us-ascii") {
+		reader, err := charsetReader(charset, bytes.NewReader(body))
+		if err == nil {
+			var decoded []byte
+			decoded, err = io.ReadAll(reader)
+			if err == nil {
+				return string(decoded)
+			}
+		}
+		email.warn("cannot decode %q part: %v", contentType, err)
+	}
+	if !utf8.Valid(body) {
+		email.warn("%q part is not valid UTF-8, kept undecoded", contentType)
+	}
+	return string(body)
+}
+
+// parseAddressList는 주소 목록 헤더를 파싱합니다 (RFC 5322 호환)
+func (email *ParsedEmail) parseAddressList(header mail.Header, key string) []string {
+	value := header.Get(key)
+	if value == "" {
+		return nil
+	}
+
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil {
+		// ParseAddressList 실패시 fallback (전체 헤더를 하나의 주소로)
+		email.warn("cannot parse %s header, kept as a single address: %v", key, err)
+		return []string{strings.TrimSpace(value)}
+	}
+
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, addr.String())
+	}
+	return list
+}
+
+func (email *ParsedEmail) warn(format string, args ...any) {
+	email.Warnings = append(email.Warnings, fmt.Sprintf(format, args...))
+}
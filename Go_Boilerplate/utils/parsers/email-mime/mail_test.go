@@ -27,6 +27,129 @@ func TestParseEmail(t *testing.T) {
 				Subject:  "테스트제목",
 				TextBody: "테스트내용\n",
 				HTMLBody: `<html><head><style>p{margin-top:0px;margin-bottom:0px;}</style></head><body><div style="font-size:14px; font-family:Gulim,굴림,sans-serif;">테스트내용</div></body></html><table style='display:none'><tr><td><img src="https://mail.naver.com/readReceipt/notify/?img=heeNW4JqbXKlFxMYaqblax%2BoMxM%2FMqF4pogdMxbdFq00FzFvFxMqFqC4MqCgMX%2B0MogmFVl5Wx%2Fs%2Bzkq%2BuICpztRpzkr74JoWzeqpBt5W4kd.gif" border="0"/></td></tr></table>`,
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "테스트내용\n"},
+					{ContentType: "text/html", Content: `<html><head><style>p{margin-top:0px;margin-bottom:0px;}</style></head><body><div style="font-size:14px; font-family:Gulim,굴림,sans-serif;">테스트내용</div></body></html><table style='display:none'><tr><td><img src="https://mail.naver.com/readReceipt/notify/?img=heeNW4JqbXKlFxMYaqblax%2BoMxM%2FMqF4pogdMxbdFq00FzFvFxMqFqC4MqCgMX%2B0MogmFVl5Wx%2Fs%2Bzkq%2BuICpztRpzkr74JoWzeqpBt5W4kd.gif" border="0"/></td></tr></table>`},
+				},
+				AuthenticationResults: []parsers.AuthenticationResult{
+					{
+						AuthServID: "amazonses.com",
+						Method:     "spf",
+						Result:     "pass",
+						Properties: map[string]string{
+							"client-ip":     "114.111.35.30",
+							"envelope-from": "sssang97@naver.com",
+							"helo":          "cvsmtppost13.nm.naver.com",
+						},
+					},
+					{AuthServID: "amazonses.com", Method: "dkim", Result: "pass", Properties: map[string]string{"header.i": "@naver.com"}},
+					{AuthServID: "amazonses.com", Method: "dmarc", Result: "pass", Properties: map[string]string{"header.from": "naver.com"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Split body parts with inline image (Apple Mail)",
+			rawEmail: "From: a@example.com\r\nTo: b@example.com\r\nSubject: split\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=\"alt\"\r\n\r\n--alt\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nfirst\r\nsecond\r\n--alt\r\nContent-Type: multipart/mixed; boundary=\"mix\"\r\n\r\n--mix\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>first</p>\r\n--mix\r\nContent-Type: image/png; name=\"a.png\"\r\nContent-Disposition: inline; filename=\"a.png\"\r\nContent-Transfer-Encoding: base64\r\n\r\naW1n\r\n--mix\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>second</p>\r\n--mix--\r\n--alt--\r\n",
+			want: parsers.ParsedEmail{
+				From:     "a@example.com",
+				To:       []string{"<b@example.com>"},
+				Subject:  "split",
+				TextBody: "first\r\nsecond",
+				HTMLBody: "<p>first</p><p>second</p>",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "first\r\nsecond"},
+					{ContentType: "text/html", Content: "<p>first</p>"},
+					{ContentType: "text/html", Content: "<p>second</p>"},
+				},
+				Attachments: []parsers.Attachment{
+					{Filename: "a.png", ContentType: `image/png; name="a.png"`, Size: 3, SHA256: "b29814cf5792e684cd75d6a7fce7a67a11887e312f87ca2ac2496d81f365ff72", Data: []byte("img")},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Unparsable headers produce warnings",
+			rawEmail: "From: a@example.com\r\nTo: not an address\r\nCc: c@example.com, d@example.com\r\nSubject: warn\r\nContent-Type: multipart/mixed\r\n\r\nbody",
+			want: parsers.ParsedEmail{
+				From:     "a@example.com",
+				To:       []string{"not an address"},
+				Cc:       []string{"<c@example.com>", "<d@example.com>"},
+				Subject:  "warn",
+				TextBody: "body",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "body"},
+				},
+				Warnings: []string{
+					"cannot parse To header, kept as a single address: mail: no angle-addr",
+					"multipart/mixed without boundary, treated as text/plain",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Text part with attachment disposition",
+			rawEmail: "From: a@example.com\r\nTo: b@example.com\r\nSubject: log\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"mix\"\r\n\r\n--mix\r\nContent-Type: text/plain\r\n\r\nbody\r\n--mix\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"app.log\"\r\n\r\nlog line\r\n--mix--\r\n",
+			want: parsers.ParsedEmail{
+				From:     "a@example.com",
+				To:       []string{"<b@example.com>"},
+				Subject:  "log",
+				TextBody: "body",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "body"},
+				},
+				Attachments: []parsers.Attachment{
+					{Filename: "app.log", ContentType: "text/plain", Size: 8, SHA256: "af29b3b5914f09b2704db141ec877ac140ee17fe7e0f969c297ef71a89757de4", Data: []byte("log line")},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Non-UTF-8 charsets are decoded",
+			rawEmail: "From: =?euc-kr?B?waa48Q==?= <a@example.com>\r\nTo: b@example.com\r\nSubject: =?euc-kr?B?waa48Q==?=\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=\"alt\"\r\n\r\n--alt\r\nContent-Type: text/plain; charset=EUC-KR\r\nContent-Transfer-Encoding: base64\r\n\r\nxde9usaus7u/6w==\r\n--alt\r\nContent-Type: text/html; charset=\"iso-2022-jp\"\r\nContent-Transfer-Encoding: 7bit\r\n\r\n<p>\x1b$B$3$s$K$A$O\x1b(B</p>\r\n--alt--\r\n",
+			want: parsers.ParsedEmail{
+				From:     "제목 <a@example.com>",
+				To:       []string{"<b@example.com>"},
+				Subject:  "제목",
+				TextBody: "테스트내용",
+				HTMLBody: "<p>こんにちは</p>",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "테스트내용"},
+					{ContentType: "text/html", Content: "<p>こんにちは</p>"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Single part Shift_JIS body",
+			rawEmail: "From: a@example.com\r\nTo: b@example.com\r\nSubject: sjis\r\nContent-Type: text/plain; charset=Shift_JIS\r\n\r\n\x83e\x83X\x83g",
+			want: parsers.ParsedEmail{
+				From:     "a@example.com",
+				To:       []string{"<b@example.com>"},
+				Subject:  "sjis",
+				TextBody: "テスト",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "テスト"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "Unknown charset and undeclared 8-bit body are kept undecoded",
+			rawEmail: "From: a@example.com\r\nTo: b@example.com\r\nSubject: raw\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"mix\"\r\n\r\n--mix\r\nContent-Type: text/plain; charset=x-unknown\r\n\r\nabc\r\n--mix\r\nContent-Type: text/plain\r\n\r\n\xc5\xd7\r\n--mix--\r\n",
+			want: parsers.ParsedEmail{
+				From:     "a@example.com",
+				To:       []string{"<b@example.com>"},
+				Subject:  "raw",
+				TextBody: "abc\xc5\xd7",
+				BodyParts: []parsers.BodyPart{
+					{ContentType: "text/plain", Content: "abc"},
+					{ContentType: "text/plain", Content: "\xc5\xd7"},
+				},
+				Warnings: []string{
+					`cannot decode "text/plain; charset=x-unknown" part: htmlindex: invalid encoding name`,
+					`"text/plain" part is not valid UTF-8, kept undecoded`,
+				},
 			},
 			wantErr: false,
 		},
@@ -41,7 +164,33 @@ func TestParseEmail(t *testing.T) {
 			}
 
 			assert.Equalf(t, tt.wantErr, err != nil, fmt.Sprintf("%v", err))
+			// 원본 헤더는 TestParseEmailHeaders에서 따로 검증
+			assert.Equal(t, tt.rawEmail != "" && err == nil, got.Headers != nil)
+			got.Headers = nil
 			assert.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func TestParseEmailHeaders(t *testing.T) {
+	t.Parallel()
+
+	got, err := parsers.ParseEmail("From: a@example.com\r\nX-Custom: 1\r\nX-Custom: 2\r\nSubject: =?utf-8?B?7YWM7Iqk7Yq4?=\r\n\r\nbody")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"From":     {"a@example.com"},
+		"X-Custom": {"1", "2"},
+		"Subject":  {"=?utf-8?B?7YWM7Iqk7Yq4?="},
+	}, got.Headers)
+	assert.Equal(t, "테스트", got.Subject)
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	t.Parallel()
+
+	got := parsers.ParseAuthenticationResults(`mx.example.com 1; dkim=fail reason="bad sig" (comment; with semicolon) header.d=example.com; spf=none smtp.mailfrom=a@example.com`)
+	assert.Equal(t, []parsers.AuthenticationResult{
+		{AuthServID: "mx.example.com", Method: "dkim", Result: "fail", Properties: map[string]string{"reason": "bad sig", "header.d": "example.com"}},
+		{AuthServID: "mx.example.com", Method: "spf", Result: "none", Properties: map[string]string{"smtp.mailfrom": "a@example.com"}},
+	}, got)
+}
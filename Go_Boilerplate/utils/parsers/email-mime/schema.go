@@ -0,0 +1,39 @@
+package parsers
+
+import (
+	_ "embed"
+)
+
+// SchemaVersion은 parsed_email.schema.json의 버전입니다.
+// 필드 추가처럼 하위 호환되는 변경은 minor, 필드 삭제/의미 변경은 major를 올립니다.
+const SchemaVersion = "1.0.0"
+
+// JSONSchema는 Document의 JSON Schema(draft 2020-12)입니다
+//
+//go:embed parsed_email.schema.json
+var JSONSchema []byte
+
+// Document는 파싱 결과를 다른 언어의 서비스에 넘길 때 쓰는 JSON 문서입니다
+type Document struct {
+	SchemaVersion string       `json:"schema_version"`
+	Source        string       `json:"source,omitempty"`
+	Email         *ParsedEmail `json:"email,omitempty"`
+	// 파싱 자체가 실패한 경우의 에러 메시지 (Email은 비어 있음)
+	Error string `json:"error,omitempty"`
+}
+
+// NewDocument는 rawEmail을 파싱해서 Document로 감쌉니다
+func NewDocument(source, rawEmail string) Document {
+	doc := Document{
+		SchemaVersion: SchemaVersion,
+		Source:        source,
+	}
+
+	email, err := ParseEmail(rawEmail)
+	if err != nil {
+		doc.Error = err.Error()
+		return doc
+	}
+	doc.Email = &email
+	return doc
+}
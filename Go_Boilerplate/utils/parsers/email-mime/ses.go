@@ -0,0 +1,277 @@
+package parsers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrSNSCertificateRequired = errors.New("ses: SNS-wrapped notification requires a signing certificate")
+	ErrInvalidSNSSignature    = errors.New("ses: invalid SNS message signature")
+	ErrNoEmailContent         = errors.New("ses: notification has no email content")
+	ErrUnsignedNotification   = errors.New("ses: unsigned notification refused, SNS signature required")
+)
+
+// S3Fetcher는 SES S3 action으로 저장된 원본 메일을 가져옵니다.
+// 운영에서는 AWS SDK로, 테스트에서는 LocalFetcher로 구현합니다.
+type S3Fetcher interface {
+	FetchObject(ctx context.Context, bucket, key string) ([]byte, error)
+}
+
+// LocalFetcher는 Root/<bucket>/<key> 경로의 로컬 파일을 S3 객체 대신 읽습니다
+type LocalFetcher struct {
+	Root string
+}
+
+func (f LocalFetcher) FetchObject(_ context.Context, bucket, key string) ([]byte, error) {
+	path := filepath.Join(f.Root, bucket, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(f.Root, path); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("ses: object key escapes root: %s/%s", bucket, key)
+	}
+	return os.ReadFile(path)
+}
+
+// SESReceipt는 SES 수신 알림의 receipt 부분(판정 결과와 action)입니다
+type SESReceipt struct {
+	Timestamp    string   `json:"timestamp"`
+	Recipients   []string `json:"recipients"`
+	SpamVerdict  string   `json:"spam_verdict"`
+	VirusVerdict string   `json:"virus_verdict"`
+	SPFVerdict   string   `json:"spf_verdict"`
+	DKIMVerdict  string   `json:"dkim_verdict"`
+	DMARCVerdict string   `json:"dmarc_verdict"`
+	DMARCPolicy  string   `json:"dmarc_policy,omitempty"`
+	ActionType   string   `json:"action_type"`
+}
+
+// Passed는 스팸/바이러스/SPF/DKIM/DMARC 판정이 모두 PASS인지 여부입니다
+func (r SESReceipt) Passed() bool {
+	for _, verdict := range []string{r.SpamVerdict, r.VirusVerdict, r.SPFVerdict, r.DKIMVerdict, r.DMARCVerdict} {
+		if verdict != "PASS" {
+			return false
+		}
+	}
+	return true
+}
+
+// SESInboundEmail은 SES 수신 알림을 파싱한 결과입니다
+type SESInboundEmail struct {
+	MessageID   string      `json:"message_id"`
+	Source      string      `json:"source"`
+	Destination []string    `json:"destination"`
+	Receipt     SESReceipt  `json:"receipt"`
+	Email       ParsedEmail `json:"email"`
+}
+
+// SESAdapter는 SES 수신 알림(SNS로 감싼 것 또는 원본 JSON)을 ParsedEmail로 변환합니다
+type SESAdapter struct {
+	// SNS 메시지 서명 검증에 쓸 인증서 (SigningCertURL에서 받아 검증한 것).
+	// 설정하면 SNS로 감싸서 서명한 알림만 받습니다 (누구나 POST할 수 있는 엔드포인트에서 판정을 위조하지 못하도록).
+	Certificate *x509.Certificate
+	// Certificate가 있어도 SNS로 감싸지 않은 원본 JSON 알림을 받음 (Lambda 등 믿을 수 있는 경로도 같이 쓸 때만)
+	AllowUnsigned bool
+	// S3 action일 때 원본 메일을 가져올 fetcher
+	Fetcher S3Fetcher
+}
+
+// snsMessage는 SNS HTTP(S)/SQS 구독으로 전달되는 메시지입니다
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string
+	Token            string
+}
+
+type sesVerdict struct {
+	Status string `json:"status"`
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Mail             struct {
+		MessageID   string   `json:"messageId"`
+		Source      string   `json:"source"`
+		Destination []string `json:"destination"`
+	} `json:"mail"`
+	Receipt struct {
+		Timestamp    string     `json:"timestamp"`
+		Recipients   []string   `json:"recipients"`
+		SpamVerdict  sesVerdict `json:"spamVerdict"`
+		VirusVerdict sesVerdict `json:"virusVerdict"`
+		SPFVerdict   sesVerdict `json:"spfVerdict"`
+		DKIMVerdict  sesVerdict `json:"dkimVerdict"`
+		DMARCVerdict sesVerdict `json:"dmarcVerdict"`
+		DMARCPolicy  string     `json:"dmarcPolicy"`
+		Action       struct {
+			Type       string `json:"type"`
+			Encoding   string `json:"encoding"`
+			BucketName string `json:"bucketName"`
+			ObjectKey  string `json:"objectKey"`
+		} `json:"action"`
+	} `json:"receipt"`
+	// SNS action일 때만 원본 메일이 들어 있음
+	Content string `json:"content"`
+}
+
+// Parse는 SES 수신 알림 payload를 파싱합니다
+func (a *SESAdapter) Parse(ctx context.Context, payload []byte) (SESInboundEmail, error) {
+	var envelope snsMessage
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return SESInboundEmail{}, fmt.Errorf("ses: invalid notification JSON: %w", err)
+	}
+
+	// SNS로 감싼 경우 서명을 검증하고 Message를 꺼냄 (Type이나 Message 중 하나만 있어도 SNS로 보고 검증)
+	if envelope.Type != "" || envelope.Message != "" {
+		if err := a.verifySNS(envelope); err != nil {
+			return SESInboundEmail{}, err
+		}
+		if envelope.Type != "Notification" {
+			return SESInboundEmail{}, fmt.Errorf("ses: unexpected SNS message type %q", envelope.Type)
+		}
+		payload = []byte(envelope.Message)
+	} else if a.Certificate != nil && !a.AllowUnsigned {
+		return SESInboundEmail{}, ErrUnsignedNotification
+	}
+
+	var notification sesNotification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return SESInboundEmail{}, fmt.Errorf("ses: invalid notification JSON: %w", err)
+	}
+	if notification.NotificationType != "Received" {
+		return SESInboundEmail{}, fmt.Errorf("ses: unexpected notification type %q", notification.NotificationType)
+	}
+
+	raw, err := a.content(ctx, notification)
+	if err != nil {
+		return SESInboundEmail{}, err
+	}
+
+	email, err := ParseEmail(string(raw))
+	if err != nil {
+		return SESInboundEmail{}, err
+	}
+
+	receipt := notification.Receipt
+	return SESInboundEmail{
+		MessageID:   notification.Mail.MessageID,
+		Source:      notification.Mail.Source,
+		Destination: notification.Mail.Destination,
+		Receipt: SESReceipt{
+			Timestamp:    receipt.Timestamp,
+			Recipients:   receipt.Recipients,
+			SpamVerdict:  receipt.SpamVerdict.Status,
+			VirusVerdict: receipt.VirusVerdict.Status,
+			SPFVerdict:   receipt.SPFVerdict.Status,
+			DKIMVerdict:  receipt.DKIMVerdict.Status,
+			DMARCVerdict: receipt.DMARCVerdict.Status,
+			DMARCPolicy:  receipt.DMARCPolicy,
+			ActionType:   receipt.Action.Type,
+		},
+		Email: email,
+	}, nil
+}
+
+// content는 알림에 포함된 원본 메일, 또는 S3에 저장된 원본 메일을 반환합니다
+func (a *SESAdapter) content(ctx context.Context, notification sesNotification) ([]byte, error) {
+	action := notification.Receipt.Action
+
+	if notification.Content != "" {
+		if strings.EqualFold(action.Encoding, "BASE64") {
+			return base64.StdEncoding.DecodeString(notification.Content)
+		}
+		return []byte(notification.Content), nil
+	}
+
+	if action.Type == "S3" {
+		if a.Fetcher == nil {
+			return nil, errors.New("ses: S3 action requires a fetcher")
+		}
+		raw, err := a.Fetcher.FetchObject(ctx, action.BucketName, action.ObjectKey)
+		if err != nil {
+			return nil, fmt.Errorf("ses: fetch s3://%s/%s: %w", action.BucketName, action.ObjectKey, err)
+		}
+		return raw, nil
+	}
+
+	return nil, ErrNoEmailContent
+}
+
+// verifySNS는 SNS 메시지 서명을 검증합니다.
+// https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func (a *SESAdapter) verifySNS(message snsMessage) error {
+	if a.Certificate == nil {
+		return ErrSNSCertificateRequired
+	}
+	publicKey, ok := a.Certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("ses: unsupported SNS certificate key type %T", a.Certificate.PublicKey)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return ErrInvalidSNSSignature
+	}
+
+	signed := snsStringToSign(message)
+	var hash crypto.Hash
+	var digest []byte
+	switch message.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(signed))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(signed))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("ses: unsupported SNS signature version %q", message.SignatureVersion)
+	}
+
+	if err := rsa.VerifyPKCS1v15(publicKey, hash, digest, signature); err != nil {
+		return ErrInvalidSNSSignature
+	}
+	return nil
+}
+
+// snsStringToSign은 SNS가 서명한 "키\n값\n" 문자열을 만듭니다
+func snsStringToSign(message snsMessage) string {
+	fields := [][2]string{{"Message", message.Message}, {"MessageId", message.MessageId}}
+	if message.Type == "Notification" {
+		if message.Subject != "" {
+			fields = append(fields, [2]string{"Subject", message.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", message.SubscribeURL})
+	}
+	fields = append(fields, [2]string{"Timestamp", message.Timestamp})
+	if message.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", message.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", message.TopicArn}, [2]string{"Type", message.Type})
+
+	var b strings.Builder
+	for _, field := range fields {
+		b.WriteString(field[0])
+		b.WriteByte('\n')
+		b.WriteString(field[1])
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,193 @@
+package parsers_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sesRawEmail = "From: a@example.com\r\nTo: test@in.example.com\r\nSubject: hello\r\nContent-Type: text/plain\r\n\r\nbody\r\n"
+
+func sesNotification(t *testing.T, action map[string]string, content string) string {
+	t.Helper()
+
+	notification := map[string]any{
+		"notificationType": "Received",
+		"mail": map[string]any{
+			"messageId":   "o3vrnil0e2ic28trm7dfhrc2v0clambfc8hm1r01",
+			"source":      "a@example.com",
+			"destination": []string{"test@in.example.com"},
+		},
+		"receipt": map[string]any{
+			"timestamp":    "2026-02-02T06:39:28.000Z",
+			"recipients":   []string{"test@in.example.com"},
+			"spamVerdict":  map[string]string{"status": "PASS"},
+			"virusVerdict": map[string]string{"status": "PASS"},
+			"spfVerdict":   map[string]string{"status": "PASS"},
+			"dkimVerdict":  map[string]string{"status": "PASS"},
+			"dmarcVerdict": map[string]string{"status": "FAIL"},
+			"action":       action,
+		},
+	}
+	if content != "" {
+		notification["content"] = content
+	}
+
+	payload, err := json.Marshal(notification)
+	require.NoError(t, err)
+	return string(payload)
+}
+
+// signingCertificate는 SNS 서명 테스트용 self-signed 인증서를 만듭니다
+func signingCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.us-west-1.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return key, cert
+}
+
+func snsWrap(t *testing.T, key *rsa.PrivateKey, message string) []byte {
+	t.Helper()
+
+	envelope := map[string]string{
+		"Type":             "Notification",
+		"MessageId":        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		"TopicArn":         "arn:aws:sns:us-west-1:123456789012:inbound",
+		"Subject":          "Amazon SES Email Receipt Notification",
+		"Message":          message,
+		"Timestamp":        "2026-02-02T06:39:28.812Z",
+		"SignatureVersion": "2",
+		"SigningCertURL":   "https://sns.us-west-1.amazonaws.com/SimpleNotificationService.pem",
+	}
+
+	stringToSign := "Message\n" + envelope["Message"] + "\nMessageId\n" + envelope["MessageId"] +
+		"\nSubject\n" + envelope["Subject"] + "\nTimestamp\n" + envelope["Timestamp"] +
+		"\nTopicArn\n" + envelope["TopicArn"] + "\nType\n" + envelope["Type"] + "\n"
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	envelope["Signature"] = base64.StdEncoding.EncodeToString(signature)
+
+	payload, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	return payload
+}
+
+func TestSESAdapterSNSInlineContent(t *testing.T) {
+	t.Parallel()
+
+	key, cert := signingCertificate(t)
+	message := sesNotification(t, map[string]string{"type": "SNS", "encoding": "BASE64"}, base64.StdEncoding.EncodeToString([]byte(sesRawEmail)))
+
+	adapter := &parsers.SESAdapter{Certificate: cert}
+	got, err := adapter.Parse(context.Background(), snsWrap(t, key, message))
+	require.NoError(t, err)
+
+	assert.Equal(t, "a@example.com", got.Source)
+	assert.Equal(t, []string{"test@in.example.com"}, got.Destination)
+	assert.Equal(t, "hello", got.Email.Subject)
+	assert.Equal(t, "body\r\n", got.Email.TextBody)
+	assert.Equal(t, "FAIL", got.Receipt.DMARCVerdict)
+	// SPF와 DKIM이 PASS여도 DMARC가 FAIL이면 통과하지 않음
+	assert.False(t, got.Receipt.Passed())
+	got.Receipt.DMARCVerdict = "PASS"
+	assert.True(t, got.Receipt.Passed())
+}
+
+func TestSESAdapterRejectsForgedSNS(t *testing.T) {
+	t.Parallel()
+
+	key, _ := signingCertificate(t)
+	_, otherCert := signingCertificate(t)
+	message := sesNotification(t, map[string]string{"type": "SNS", "encoding": "UTF8"}, sesRawEmail)
+
+	_, err := (&parsers.SESAdapter{Certificate: otherCert}).Parse(context.Background(), snsWrap(t, key, message))
+	assert.ErrorIs(t, err, parsers.ErrInvalidSNSSignature)
+
+	_, err = (&parsers.SESAdapter{}).Parse(context.Background(), snsWrap(t, key, message))
+	assert.ErrorIs(t, err, parsers.ErrSNSCertificateRequired)
+}
+
+func TestSESAdapterRejectsUnsigned(t *testing.T) {
+	t.Parallel()
+
+	key, cert := signingCertificate(t)
+	message := sesNotification(t, map[string]string{"type": "SNS", "encoding": "UTF8"}, sesRawEmail)
+
+	// 인증서가 있으면 감싸지 않은 알림은 받지 않음
+	adapter := &parsers.SESAdapter{Certificate: cert}
+	_, err := adapter.Parse(context.Background(), []byte(message))
+	assert.ErrorIs(t, err, parsers.ErrUnsignedNotification)
+
+	// Type만 있고 Message가 없으면 SNS로 보고 서명을 검증함
+	var envelope map[string]string
+	require.NoError(t, json.Unmarshal(snsWrap(t, key, message), &envelope))
+	delete(envelope, "Message")
+	stripped, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = adapter.Parse(context.Background(), stripped)
+	assert.ErrorIs(t, err, parsers.ErrInvalidSNSSignature)
+
+	adapter.AllowUnsigned = true
+	got, err := adapter.Parse(context.Background(), []byte(message))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got.Email.Subject)
+}
+
+func TestSESAdapterS3Action(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "inbound-bucket", "mail"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "inbound-bucket", "mail", "o3vrnil0e2ic"), []byte(sesRawEmail), 0o644))
+
+	message := sesNotification(t, map[string]string{"type": "S3", "bucketName": "inbound-bucket", "objectKey": "mail/o3vrnil0e2ic"}, "")
+
+	adapter := &parsers.SESAdapter{Fetcher: parsers.LocalFetcher{Root: root}}
+	got, err := adapter.Parse(context.Background(), []byte(message))
+	require.NoError(t, err)
+	assert.Equal(t, "S3", got.Receipt.ActionType)
+	assert.Equal(t, "hello", got.Email.Subject)
+
+	// ..로 시작하는 이름은 Root 밖이 아님
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "..inbound"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "..inbound", "..mail"), []byte(sesRawEmail), 0o644))
+	dotted := sesNotification(t, map[string]string{"type": "S3", "bucketName": "..inbound", "objectKey": "..mail"}, "")
+	got, err = adapter.Parse(context.Background(), []byte(dotted))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got.Email.Subject)
+
+	for _, location := range [][2]string{{"..", "etc/passwd"}, {"inbound-bucket", "../../etc/passwd"}} {
+		escape := sesNotification(t, map[string]string{"type": "S3", "bucketName": location[0], "objectKey": location[1]}, "")
+		_, err = adapter.Parse(context.Background(), []byte(escape))
+		assert.Error(t, err, location)
+	}
+}
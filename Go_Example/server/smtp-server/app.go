@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrShuttingDown = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Service shutting down, try again later",
+}
+
+// App은 설정으로 만든 SMTP 서버, 리스너, 스풀 묶음입니다
+type App struct {
+	Config    Config
+	Backend   *Backend
+	Server    *smtp.Server
+	Listeners []net.Listener
+	// lmtp 리스너가 있을 때의 LMTP 서버와 리스너 (Backend는 SMTP와 같음)
+	LMTPServer    *smtp.Server
+	LMTPListeners []net.Listener
+	// metrics_addr를 설정했을 때 /metrics를 제공하는 리스너
+	MetricsListener net.Listener
+	metricsServer   *http.Server
+	// postgres_dsn을 설정했을 때 메일 저장소가 쓰는 연결
+	DB *sql.DB
+	// relay를 설정했을 때의 외부 발송
+	Relay *Relay
+	// catcher를 설정했을 때의 메일 수집기와 웹 UI/API 리스너
+	Catcher         *Catcher
+	CatcherListener net.Listener
+	catcherServer   *http.Server
+}
+
+// NewApp은 config대로 Backend와 서버를 구성하고 리스너를 엽니다.
+// 메일은 handler로 처리합니다 (spool_dir이 설정돼 있으면 스풀을 거쳐서).
+func NewApp(config Config, handler MessageHandler) (app *App, err error) {
+	// 만드는 도중에 실패하면 그때까지 연 것을 거꾸로 닫음
+	var cleanups []func()
+	defer func() {
+		if err != nil {
+			for i := len(cleanups) - 1; i >= 0; i-- {
+				cleanups[i]()
+			}
+		}
+	}()
+
+	// 저장소 → handler → 캐처 → 중계 → 웹훅 순서로 실행 (저장에 실패하면 뒤로 넘기지 않고 451)
+	var db *sql.DB
+	var handlers []MessageHandler
+	if config.PostgresDSN != "" {
+		db, err = openMessageStore(config.PostgresDSN)
+		if err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, func() { db.Close() })
+		handlers = append(handlers, &PostgresMessageStore{DB: db})
+	}
+	if handler != nil {
+		handlers = append(handlers, handler)
+	}
+	catcher, err := config.Catcher()
+	if err != nil {
+		return nil, err
+	}
+	if catcher != nil {
+		if closer, ok := catcher.Store.(io.Closer); ok {
+			cleanups = append(cleanups, func() { closer.Close() })
+		}
+		handlers = append(handlers, catcher)
+	}
+	relay, err := config.Relay()
+	if err != nil {
+		return nil, err
+	}
+	if relay != nil {
+		relay.DKIM, err = config.DKIMSigner()
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, relay)
+	}
+	if webhooks := config.WebhookHandler(); webhooks != nil {
+		handlers = append(handlers, webhooks)
+	}
+	switch len(handlers) {
+	case 0:
+		handler = nil
+	case 1:
+		handler = handlers[0]
+	default:
+		handler = Chain(handlers...)
+	}
+	if relay != nil {
+		// 반송 메일도 받은 메일과 같은 경로로 (발신자가 중계 대상이면 다시 큐로)
+		relay.Local = handler
+	}
+
+	backend := &Backend{
+		Handler:         handler,
+		Recipients:      config.RecipientPolicy(db),
+		SPF:             config.SPFPolicy(),
+		Greylist:        config.Greylist(db),
+		SignedAddresses: config.SignedAddresses(),
+		// 바이러스 검사는 응답하기 전에 세션에서 (감염된 메일은 스풀에도 넣지 않음, 반송 메일은 검사하지 않음)
+		VirusScanner: config.VirusScanner(),
+		TLSPolicy: TLSPolicy{
+			RequireForAuth: config.TLS.RequireForAuth,
+			RequireForMail: config.TLS.RequireForMail,
+		},
+	}
+
+	spam, err := config.SpamFilter()
+	if err != nil {
+		return nil, err
+	}
+	backend.Spam = spam
+
+	limiter, err := config.RateLimiter()
+	if err != nil {
+		return nil, err
+	}
+	backend.RateLimit = limiter
+
+	// 여러 인스턴스가 공유하는 그레이리스트 테이블이 없으면 만듦
+	if backend.Greylist != nil {
+		if store, ok := backend.Greylist.Store.(*PostgresGreylistStore); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := store.Migrate(ctx)
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("postgres: %w", err)
+			}
+		}
+	}
+
+	// 자격 증명 저장소가 있으면 SMTP AUTH 활성화
+	backend.Credentials, err = config.CredentialStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// 스풀 디렉토리가 있으면 디스크에 먼저 저장하고 워커가 핸들러에 전달
+	if config.SpoolDir != "" {
+		spool, err := NewSpool(config.SpoolDir, handler)
+		if err != nil {
+			return nil, err
+		}
+		backend.Spool = spool
+	}
+
+	// 인증서가 있으면 STARTTLS와 implicit TLS(465 방식) 리스너 활성화
+	var tlsConfig *tls.Config
+	if config.TLS.CertFile != "" {
+		reloader, err := NewCertReloader(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = reloader.TLSConfig()
+	}
+	newServer := func(lmtp bool) *smtp.Server {
+		server := smtp.NewServer(backend)
+		server.LMTP = lmtp
+		server.Domain = config.Domain
+		server.ReadTimeout = time.Duration(config.ReadTimeout)
+		server.WriteTimeout = time.Duration(config.WriteTimeout)
+		server.MaxMessageBytes = config.MaxMessageBytes
+		server.MaxRecipients = config.MaxRecipients
+		// SIZE, 8BITMIME, CHUNKING은 go-smtp가 항상 광고함. 본문은 바이트 그대로 다루므로 나머지도 받을 수 있음
+		server.EnableSMTPUTF8 = true
+		server.EnableBINARYMIME = true
+		// 평문 AUTH 허용 여부는 Backend.TLSPolicy로 세션에서 결정
+		server.AllowInsecureAuth = true
+		server.TLSConfig = tlsConfig
+		return server
+	}
+
+	app = &App{Config: config, Backend: backend, Server: newServer(false), DB: db, Relay: relay, Catcher: catcher}
+	if config.MetricsAddr != "" {
+		backend.Metrics = NewMetrics()
+		listener, err := net.Listen("tcp", config.MetricsAddr)
+		if err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, func() { listener.Close() })
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", backend.Metrics.Handler())
+		app.MetricsListener = listener
+		app.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	}
+	if catcher != nil {
+		listener, err := net.Listen("tcp", config.Catching.Addr)
+		if err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, func() { listener.Close() })
+		app.CatcherListener = listener
+		app.catcherServer = &http.Server{Handler: catcher.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	}
+
+	for _, listenerConfig := range config.Listeners {
+		listener, err := net.Listen("tcp", listenerConfig.Addr)
+		if err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, func() { listener.Close() })
+		// PROXY 헤더는 TLS 앞에 오므로 TLS보다 안쪽에서 읽음
+		if listenerConfig.ProxyProtocol {
+			trusted, err := ParseCIDRs(listenerConfig.ProxyTrusted)
+			if err != nil {
+				return nil, err
+			}
+			listener = &ProxyListener{Listener: listener, Trusted: trusted}
+		}
+		// 연결 제한은 PROXY 헤더의 실제 주소로 (ProxyListener 바깥, TLS 안쪽)
+		if backend.RateLimit != nil {
+			listener = &ConnLimitListener{Listener: listener, Limiter: backend.RateLimit, Metrics: backend.Metrics}
+		}
+		if listenerConfig.TLS {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		if listenerConfig.LMTP {
+			if app.LMTPServer == nil {
+				app.LMTPServer = newServer(true)
+			}
+			app.LMTPListeners = append(app.LMTPListeners, listener)
+		} else {
+			app.Listeners = append(app.Listeners, listener)
+		}
+	}
+
+	return app, nil
+}
+
+// Run은 ctx가 취소될 때까지 메일을 받고, 취소되면 graceful shutdown합니다.
+// 새 연결을 막고, DATA 진행 중인 세션은 ShutdownTimeout까지 기다린 뒤 나머지 연결을 끊습니다.
+func (a *App) Run(ctx context.Context) error {
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	if a.Backend.Spool != nil {
+		if err := a.Backend.Spool.Start(workerCtx); err != nil {
+			a.closeListeners()
+			return err
+		}
+	}
+
+	if a.Relay != nil {
+		if err := a.Relay.Queue.Start(workerCtx); err != nil {
+			a.closeListeners()
+			return err
+		}
+	}
+	if a.Backend.Greylist != nil {
+		go a.Backend.Greylist.Cleanup(workerCtx, time.Hour)
+	}
+
+	serveErr := make(chan error, len(a.Listeners)+len(a.LMTPListeners))
+	for _, listener := range a.Listeners {
+		slog.Info("SMTP 서버 시작", "addr", listener.Addr().String())
+		go func(listener net.Listener) {
+			serveErr <- a.Server.Serve(listener)
+		}(listener)
+	}
+	for _, listener := range a.LMTPListeners {
+		slog.Info("LMTP 서버 시작", "addr", listener.Addr().String())
+		go func(listener net.Listener) {
+			serveErr <- a.LMTPServer.Serve(listener)
+		}(listener)
+	}
+	slog.Info("도메인", "domain", a.Server.Domain)
+	if a.metricsServer != nil {
+		slog.Info("지표 서버 시작", "addr", a.MetricsListener.Addr().String())
+		go serveHTTP("지표", a.metricsServer, a.MetricsListener)
+	}
+	if a.catcherServer != nil {
+		slog.Info("캐처 웹 UI 시작", "addr", a.CatcherListener.Addr().String())
+		go serveHTTP("캐처", a.catcherServer, a.CatcherListener)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+		slog.Error("리스너 오류", "error", err)
+	}
+
+	a.shutdown()
+	stopWorkers()
+	if a.Backend.Spool != nil {
+		a.Backend.Spool.Wait()
+	}
+	if a.Relay != nil {
+		a.Relay.Queue.Wait()
+	}
+	if a.DB != nil {
+		a.DB.Close()
+	}
+	if a.Catcher != nil {
+		if closer, ok := a.Catcher.Store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return err
+}
+
+func (a *App) shutdown() {
+	slog.Info("SMTP 서버 종료 중", "timeout", time.Duration(a.Config.ShutdownTimeout))
+
+	// 이후의 MAIL은 421로 거절해서 클라이언트가 연결을 끊게 함
+	a.Backend.draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.Config.ShutdownTimeout))
+	defer cancel()
+	servers := []*smtp.Server{a.Server}
+	if a.LMTPServer != nil {
+		servers = append(servers, a.LMTPServer)
+	}
+	// SMTP와 LMTP가 같은 시간 안에 끝나도록 동시에 기다림
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *smtp.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+	for range servers {
+		if err := <-errs; err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+			slog.Warn("대기 시간 초과, 남은 연결 종료", "error", err)
+			// Shutdown 이후에는 Server.Close가 연결을 닫지 않으므로 직접 닫음
+			a.Backend.closeSessions()
+		}
+	}
+	if a.metricsServer != nil {
+		a.metricsServer.Close()
+	}
+	// SSE 연결은 끝나지 않으므로 Shutdown 대신 Close
+	if a.catcherServer != nil {
+		a.catcherServer.Close()
+	}
+	slog.Info("SMTP 서버 종료")
+}
+
+// serveHTTP는 listener에서 server를 실행합니다 (Close로 멈춤)
+func serveHTTP(name string, server *http.Server, listener net.Listener) {
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("HTTP 서버 오류", "server", name, "error", err)
+	}
+}
+
+// openMessageStore는 Postgres에 연결하고 메일 저장소 스키마를 최신으로 맞춥니다
+func openMessageStore(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	if err := (&PostgresMessageStore{DB: db}).Migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	return db, nil
+}
+
+func (a *App) closeListeners() {
+	for _, listener := range append(a.Listeners, a.LMTPListeners...) {
+		listener.Close()
+	}
+	if a.MetricsListener != nil {
+		a.MetricsListener.Close()
+	}
+	if a.CatcherListener != nil {
+		a.CatcherListener.Close()
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"domain": "mx.example.com",
+		"listeners": [{"addr": ":2525"}, {"addr": ":4650", "tls": true}],
+		"read_timeout": "1m",
+		"max_recipients": 10,
+		"tls": {"cert_file": "cert.pem", "key_file": "key.pem", "require_for_auth": true},
+		"recipients": {"domains": ["example.com"], "plus_separator": "+"}
+	}`), 0o600))
+
+	t.Setenv("SMTP_MAX_RECIPIENTS", "20")
+	t.Setenv("SMTP_SHUTDOWN_TIMEOUT", "5s")
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mx.example.com", config.Domain)
+	assert.Equal(t, []ListenerConfig{{Addr: ":2525"}, {Addr: ":4650", TLS: true}}, config.Listeners)
+	assert.Equal(t, Duration(time.Minute), config.ReadTimeout)
+	assert.Equal(t, Duration(10*time.Second), config.WriteTimeout) // 기본값
+	assert.Equal(t, 20, config.MaxRecipients)                      // 환경 변수가 우선
+	assert.Equal(t, Duration(5*time.Second), config.ShutdownTimeout)
+	assert.True(t, config.TLS.RequireForAuth)
+	assert.Equal(t, "+", config.RecipientPolicy(nil).PlusSeparator)
+
+	t.Setenv("SMTP_LISTEN", ":25, :465/tls, 127.0.0.1:24/lmtp")
+	config, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []ListenerConfig{{Addr: ":25"}, {Addr: ":465", TLS: true}, {Addr: "127.0.0.1:24", LMTP: true}}, config.Listeners)
+
+	t.Setenv("SMTP_LISTEN", ":25, :465/tls/proxy")
+	t.Setenv("SMTP_PROXY_TRUSTED", "10.0.0.0/8,192.0.2.1")
+	config, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []ListenerConfig{{Addr: ":25"}, {Addr: ":465", TLS: true, ProxyProtocol: true, ProxyTrusted: []string{"10.0.0.0/8", "192.0.2.1"}}}, config.Listeners)
+	t.Setenv("SMTP_PROXY_TRUSTED", "not-an-ip")
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"unknown_field": 1}`), 0o600))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	for _, content := range []string{
+		// postgres 저장소는 postgres_dsn이 있어야 함
+		`{"greylist": {"store": "postgres"}}`,
+		`{"recipients": {"domains": ["example.com"], "lookup": "postgres"}}`,
+		`{"credentials_store": "postgres"}`,
+		`{"greylist": {"store": "redis"}, "postgres_dsn": "dbname=mail"}`,
+		`{"recipients": {"lookup": "memory"}, "postgres_dsn": "dbname=mail"}`,
+		`{"credentials_store": "file"}`,
+		`{"credentials_store": "postgres", "credentials_file": "users", "postgres_dsn": "dbname=mail"}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadConfig(path)
+		assert.Error(t, err, content)
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"greylist": {"store": "postgres"},
+		"recipients": {"domains": ["example.com"], "lookup": "postgres", "lookup_query": "SELECT true"},
+		"postgres_dsn": "dbname=mail"
+	}`), 0o600))
+	t.Setenv("SMTP_CREDENTIALS_STORE", "postgres")
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.IsType(t, &PostgresGreylistStore{}, config.Greylist(nil).Store)
+	assert.Equal(t, &PostgresRecipientLookup{Query: "SELECT true"}, config.RecipientPolicy(nil).Lookup)
+	credentials, err := config.CredentialStore(nil)
+	require.NoError(t, err)
+	assert.IsType(t, &PostgresCredentialStore{}, credentials)
+
+	// 기본값은 메모리 그레이리스트, 조회 없음, 자격 증명 없음
+	config = DefaultConfig()
+	config.Greylisting = &GreylistConfig{}
+	config.Recipients = &RecipientConfig{Domains: []string{"example.com"}}
+	assert.IsType(t, &MemoryGreylistStore{}, config.Greylist(nil).Store)
+	assert.Nil(t, config.RecipientPolicy(nil).Lookup)
+	credentials, err = config.CredentialStore(nil)
+	require.NoError(t, err)
+	assert.Nil(t, credentials)
+}
+
+// freeAddr는 지금 비어 있는 로컬 주소입니다
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// openFiles는 이 프로세스가 열고 있는 파일 경로입니다 (/proc이 없으면 건너뜀)
+func openFiles(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	var paths []string
+	for _, entry := range entries {
+		if path, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name())); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func TestNewAppClosesOnError(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.MetricsAddr = freeAddr(t)
+	config.Catching = &CatcherConfig{Addr: freeAddr(t), SQLitePath: filepath.Join(dir, "catcher.db")}
+	config.Listeners = []ListenerConfig{{Addr: freeAddr(t)}, {Addr: busy.Addr().String()}}
+	_, err = NewApp(config, nil)
+	require.Error(t, err)
+
+	// 앞에서 연 리스너는 모두 닫혔으므로 다시 열 수 있음
+	for _, addr := range []string{config.MetricsAddr, config.Catching.Addr, config.Listeners[0].Addr} {
+		listener, err := net.Listen("tcp", addr)
+		require.NoError(t, err, addr)
+		listener.Close()
+	}
+
+	// 리스너보다 먼저 연 캐처 저장소도 뒤에서 실패하면 닫힘
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}}
+	config.CredentialsFile = filepath.Join(dir, "missing")
+	_, err = NewApp(config, nil)
+	require.Error(t, err)
+	for _, path := range openFiles(t) {
+		assert.NotContains(t, path, dir)
+	}
+}
+
+func startApp(t *testing.T, shutdownTimeout time.Duration, handler MessageHandler) (addr string, cancel context.CancelFunc, done <-chan error) {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}}
+	config.ShutdownTimeout = Duration(shutdownTimeout)
+
+	app, err := NewApp(config, handler)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- app.Run(ctx) }()
+	t.Cleanup(cancel)
+
+	return app.Listeners[0].Addr().String(), cancel, errs
+}
+
+func TestGracefulShutdownFinishesData(t *testing.T) {
+	t.Parallel()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	addr, cancel, done := startApp(t, 5*time.Second, MessageHandlerFunc(func(context.Context, *Message) error {
+		close(entered)
+		<-release
+		return nil
+	}))
+
+	sent := make(chan error, 1)
+	go func() { sent <- sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage) }()
+	<-entered
+
+	// DATA 처리 중에 종료 시작
+	cancel()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 5*time.Millisecond, "listener should be closed")
+
+	close(release)
+	require.NoError(t, <-sent)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestGracefulShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	addr, cancel, done := startApp(t, 100*time.Millisecond, LogHandler())
+
+	// 아무것도 보내지 않는 클라이언트
+	client, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Hello("localhost"))
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown timeout")
+	}
+	assert.Error(t, client.Noop())
+}
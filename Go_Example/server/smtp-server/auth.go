@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials는 사용자가 없거나 비밀번호가 틀린 경우입니다
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+var (
+	ErrAuthTemporary = &smtp.SMTPError{
+		Code:         454,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+		Message:      "Temporary authentication failure",
+	}
+	ErrSenderNotAllowed = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Sender address not allowed for this user",
+	}
+)
+
+// User는 SMTP AUTH로 인증된 사용자입니다
+type User struct {
+	Username string
+	// MAIL FROM으로 쓸 수 있는 주소. "*@example.com"은 도메인 전체, "*"는 모든 주소
+	AllowedSenders []string
+}
+
+// CanSendAs는 address를 MAIL FROM으로 쓸 수 있는지 확인합니다
+func (u *User) CanSendAs(address string) bool {
+	_, domain, _ := strings.Cut(address, "@")
+	for _, allowed := range u.AllowedSenders {
+		switch {
+		case allowed == "*":
+			return true
+		case strings.HasPrefix(allowed, "*@"):
+			if strings.EqualFold(allowed[2:], domain) {
+				return true
+			}
+		case strings.EqualFold(allowed, address):
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialStore는 SMTP AUTH 자격 증명을 검증합니다.
+// 자격 증명이 틀리면 ErrInvalidCredentials를, 저장소 장애면 그 외의 에러를 반환합니다.
+type CredentialStore interface {
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+}
+
+// bcrypt 비교를 항상 한 번 하도록 없는 사용자에게 쓰는 해시 (사용자 존재 여부가 응답 시간으로 드러나지 않게)
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+type fileCredential struct {
+	passwordHash   []byte
+	allowedSenders []string
+}
+
+// FileCredentialStore는 파일에서 읽은 bcrypt 자격 증명입니다.
+// 한 줄에 "username:bcrypt-hash:sender1,sender2" 형식이고, '#'으로 시작하는 줄은 주석입니다.
+type FileCredentialStore struct {
+	users map[string]fileCredential
+}
+
+// LoadFileCredentialStore는 path의 자격 증명 파일을 읽습니다
+func LoadFileCredentialStore(path string) (*FileCredentialStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	store := &FileCredentialStore{users: map[string]fileCredential{}}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected username:bcrypt-hash[:senders]", path, lineNo)
+		}
+
+		credential := fileCredential{passwordHash: []byte(fields[1])}
+		if len(fields) == 3 && fields[2] != "" {
+			credential.allowedSenders = strings.Split(fields[2], ",")
+		}
+		store.users[fields[0]] = credential
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *FileCredentialStore) Authenticate(_ context.Context, username, password string) (*User, error) {
+	credential, ok := s.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword(credential.passwordHash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &User{Username: username, AllowedSenders: credential.allowedSenders}, nil
+}
+
+// PostgresCredentialStore는 Postgres 테이블에서 bcrypt 자격 증명을 조회합니다
+type PostgresCredentialStore struct {
+	DB *sql.DB
+	// $1에 username을 받아 (bcrypt 해시, 쉼표로 구분된 허용 발신 주소)를 반환하는 쿼리
+	Query string
+}
+
+const defaultCredentialQuery = `SELECT password_hash, array_to_string(allowed_senders, ',') FROM smtp_users WHERE username = $1 AND enabled`
+
+func (s *PostgresCredentialStore) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	query := s.Query
+	if query == "" {
+		query = defaultCredentialQuery
+	}
+
+	var passwordHash, senders string
+	err := s.DB.QueryRowContext(ctx, query, username).Scan(&passwordHash, &senders)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	user := &User{Username: username}
+	if senders != "" {
+		user.AllowedSenders = strings.Split(senders, ",")
+	}
+	return user, nil
+}
+
+// AuthMechanisms는 지원하는 SASL 메커니즘을 반환합니다 (CredentialStore가 없으면 AUTH를 광고하지 않음)
+func (s *Session) AuthMechanisms() []string {
+	if s.backend.Credentials == nil {
+		return nil
+	}
+	if s.backend.TLSPolicy.RequireForAuth && !s.tls {
+		return nil
+	}
+	return []string{sasl.Plain, sasl.Login}
+}
+
+// Auth는 SASL 인증을 처리합니다
+func (s *Session) Auth(mech string) (sasl.Server, error) {
+	if s.backend.Credentials == nil {
+		return nil, smtp.ErrAuthUnsupported
+	}
+	if s.backend.TLSPolicy.RequireForAuth && !s.tls {
+		return nil, ErrTLSRequired
+	}
+
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if identity != "" && identity != username {
+				return smtp.ErrAuthFailed
+			}
+			return s.authenticate(username, password)
+		}), nil
+	case sasl.Login:
+		return &loginServer{authenticate: s.authenticate}, nil
+	}
+	return nil, smtp.ErrAuthUnknownMechanism
+}
+
+func (s *Session) authenticate(username, password string) error {
+	user, err := s.backend.Credentials.Authenticate(context.Background(), username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		s.logger.Info("인증 실패", "user", username)
+		return smtp.ErrAuthFailed
+	}
+	if err != nil {
+		s.logger.Error("자격 증명 조회 실패", "user", username, "error", err)
+		return ErrAuthTemporary
+	}
+
+	if s.backend.RateLimit != nil {
+		if err := s.backend.RateLimit.Login(s.remoteIP, user.Username); err != nil {
+			return s.reject(reasonRateLimit, err, "user", username)
+		}
+	}
+
+	s.logger.Info("인증 성공", "user", username)
+	s.user = user
+	return nil
+}
+
+// loginServer는 LOGIN SASL 메커니즘의 서버 쪽입니다 (go-sasl에는 클라이언트만 있음)
+type loginServer struct {
+	step         int
+	username     string
+	authenticate func(username, password string) error
+}
+
+func (a *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	a.step++
+	switch a.step {
+	case 1:
+		if response == nil {
+			return []byte("Username:"), false, nil
+		}
+		// 초기 응답(AUTH LOGIN <username>)으로 사용자 이름을 받은 경우
+		a.step++
+		a.username = string(response)
+		return []byte("Password:"), false, nil
+	case 2:
+		a.username = string(response)
+		return []byte("Password:"), false, nil
+	case 3:
+		return nil, true, a.authenticate(a.username, string(response))
+	}
+	return nil, false, errors.New("unexpected LOGIN response")
+}
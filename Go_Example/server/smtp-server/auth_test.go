@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserCanSendAs(t *testing.T) {
+	t.Parallel()
+
+	user := &User{AllowedSenders: []string{"noreply@example.com", "*@notify.example.com"}}
+	assert.True(t, user.CanSendAs("NoReply@example.com"))
+	assert.True(t, user.CanSendAs("alerts@notify.example.com"))
+	assert.False(t, user.CanSendAs("ceo@example.com"))
+	assert.True(t, (&User{AllowedSenders: []string{"*"}}).CanSendAs("anyone@anywhere.com"))
+}
+
+func credentialFile(t *testing.T) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "users")
+	content := "# username:bcrypt-hash:senders\nbilling:" + string(hash) + ":billing@example.com,*@notify.example.com\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestAuth(t *testing.T) {
+	t.Parallel()
+
+	store, err := LoadFileCredentialStore(credentialFile(t))
+	require.NoError(t, err)
+
+	var got []*Message
+	backend := &Backend{
+		Handler: MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+			got = append(got, msg)
+			return nil
+		}),
+		Recipients:  &RecipientPolicy{Domains: []string{"example.com"}},
+		Credentials: store,
+	}
+	addr := startServerWithBackend(t, backend)
+
+	tests := []struct {
+		name     string
+		auth     sasl.Client
+		from     string
+		wantCode int
+	}{
+		{name: "plain", auth: sasl.NewPlainClient("", "billing", "s3cret"), from: "billing@example.com"},
+		{name: "login", auth: sasl.NewLoginClient("billing", "s3cret"), from: "alerts@notify.example.com"},
+		{name: "wrong password", auth: sasl.NewPlainClient("", "billing", "nope"), from: "billing@example.com", wantCode: 535},
+		{name: "unknown user", auth: sasl.NewLoginClient("nobody", "s3cret"), from: "billing@example.com", wantCode: 535},
+		{name: "sender not allowed", auth: sasl.NewPlainClient("", "billing", "s3cret"), from: "ceo@example.com", wantCode: 550},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := smtp.Dial(addr)
+			require.NoError(t, err)
+			defer client.Close()
+
+			err = client.Auth(tt.auth)
+			if err == nil {
+				// 인증된 사용자는 외부 도메인으로 보낼 수 있음
+				err = client.SendMail(tt.from, []string{"customer@other.com"}, strings.NewReader(testMessage))
+			}
+
+			if tt.wantCode == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			require.ErrorAs(t, err, &smtpErr)
+			assert.Equal(t, tt.wantCode, smtpErr.Code)
+		})
+	}
+
+	require.Len(t, got, 2)
+	assert.Equal(t, []string{"customer@other.com"}, got[1].Envelope.To)
+	assert.Equal(t, "billing", got[1].Envelope.User)
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+	_ "modernc.org/sqlite"
+)
+
+var ErrCaughtMessageNotFound = errors.New("catcher: message not found")
+
+//go:embed catcher.html
+var catcherPage []byte
+
+// CaughtMessage는 캐처가 보관한 메일입니다. Raw는 Get으로 가져올 때만 채워집니다.
+type CaughtMessage struct {
+	ID         int64     `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	RemoteIP   string    `json:"remote_ip"`
+	Helo       string    `json:"helo"`
+	SessionID  string    `json:"session_id"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+	Raw        []byte    `json:"-"`
+}
+
+// CatcherStore는 캐처의 메일 저장소입니다. 목록은 최신순입니다.
+type CatcherStore interface {
+	// Add는 메일을 저장하고 ID를 반환합니다. search는 검색 대상 텍스트(소문자)입니다.
+	Add(ctx context.Context, msg CaughtMessage, search string) (int64, error)
+	// List는 search(소문자)가 포함된 메일을 최대 limit개 반환합니다 (search가 비어 있으면 전체)
+	List(ctx context.Context, search string, limit int) ([]CaughtMessage, error)
+	Get(ctx context.Context, id int64) (CaughtMessage, error)
+	Delete(ctx context.Context, id int64) error
+	DeleteAll(ctx context.Context) error
+}
+
+// Catcher는 받은 메일을 보관하고 웹 UI와 JSON API로 보여주는 개발용 메일 수집기입니다 (MailHog 방식).
+// 로컬 개발이나 CI에서 앱이 보낸 메일을 확인하는 용도이며, 인증이 없으므로 외부에 노출하면 안 됩니다.
+type Catcher struct {
+	Store CatcherStore
+
+	mu          sync.Mutex
+	subscribers map[chan CaughtMessage]struct{}
+}
+
+// NewCatcher는 store를 쓰는 Catcher를 만듭니다
+func NewCatcher(store CatcherStore) *Catcher {
+	return &Catcher{Store: store, subscribers: map[chan CaughtMessage]struct{}{}}
+}
+
+func (c *Catcher) HandleMessage(ctx context.Context, msg *Message) error {
+	caught := CaughtMessage{
+		ReceivedAt: time.Now().UTC(),
+		From:       msg.Envelope.From,
+		To:         msg.Envelope.To,
+		Helo:       msg.Envelope.Helo,
+		SessionID:  msg.Envelope.SessionID,
+		Subject:    msg.Parsed.Subject,
+		Size:       len(msg.Raw),
+		Raw:        msg.Raw,
+	}
+	if msg.Envelope.RemoteIP != nil {
+		caught.RemoteIP = msg.Envelope.RemoteIP.String()
+	}
+
+	// 봉투, 헤더 주소, 제목, 본문에서 검색
+	search := strings.ToLower(strings.Join([]string{
+		msg.Envelope.From, strings.Join(msg.Envelope.To, " "),
+		msg.Parsed.From, strings.Join(msg.Parsed.To, " "), strings.Join(msg.Parsed.Cc, " "),
+		msg.Parsed.Subject, msg.Parsed.TextBody, msg.Parsed.HTMLBody,
+	}, "\n"))
+
+	id, err := c.Store.Add(ctx, caught, search)
+	if err != nil {
+		return err
+	}
+	caught.ID = id
+	caught.Raw = nil
+	c.publish(caught)
+	return nil
+}
+
+// subscribe는 새 메일을 받을 채널을 등록합니다. 반환한 함수로 해제합니다.
+func (c *Catcher) subscribe() (<-chan CaughtMessage, func()) {
+	ch := make(chan CaughtMessage, 16)
+	c.mu.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.mu.Unlock()
+
+	return ch, func() {
+		c.mu.Lock()
+		delete(c.subscribers, ch)
+		c.mu.Unlock()
+	}
+}
+
+// publish는 구독자에게 새 메일을 알립니다. 느린 구독자는 기다리지 않고 건너뜁니다.
+func (c *Catcher) publish(msg CaughtMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Handler는 웹 UI와 API를 제공하는 HTTP 핸들러입니다.
+//
+//	GET    /                                   웹 UI
+//	GET    /api/messages?q=&limit=              목록 (q: 봉투/주소/제목/본문 검색)
+//	DELETE /api/messages                        전체 삭제
+//	GET    /api/messages/{id}                   봉투 + 파싱한 메일
+//	DELETE /api/messages/{id}                   삭제
+//	GET    /api/messages/{id}/raw               원본 (message/rfc822)
+//	GET    /api/messages/{id}/attachments/{n}   n번째 첨부파일
+//	GET    /api/events                          새 메일 (server-sent events)
+func (c *Catcher) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(catcherPage)
+	})
+	mux.HandleFunc("GET /api/messages", c.list)
+	mux.HandleFunc("DELETE /api/messages", c.deleteAll)
+	mux.HandleFunc("GET /api/messages/{id}", c.get)
+	mux.HandleFunc("DELETE /api/messages/{id}", c.delete)
+	mux.HandleFunc("GET /api/messages/{id}/raw", c.raw)
+	mux.HandleFunc("GET /api/messages/{id}/attachments/{n}", c.attachment)
+	mux.HandleFunc("GET /api/events", c.events)
+	return mux
+}
+
+func (c *Catcher) list(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	messages, err := c.Store.List(r.Context(), strings.ToLower(r.URL.Query().Get("q")), limit)
+	if err != nil {
+		catcherError(w, err)
+		return
+	}
+	if messages == nil {
+		messages = []CaughtMessage{}
+	}
+	writeJSON(w, messages)
+}
+
+// caughtMessageDetail은 GET /api/messages/{id}의 응답입니다
+type caughtMessageDetail struct {
+	CaughtMessage
+	Email parsers.ParsedEmail `json:"email"`
+}
+
+func (c *Catcher) get(w http.ResponseWriter, r *http.Request) {
+	msg, parsed, ok := c.load(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, caughtMessageDetail{CaughtMessage: msg, Email: parsed})
+}
+
+func (c *Catcher) raw(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	msg, err := c.Store.Get(r.Context(), id)
+	if err != nil {
+		catcherError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="message-%d.eml"`, id))
+	w.Write(msg.Raw)
+}
+
+func (c *Catcher) attachment(w http.ResponseWriter, r *http.Request) {
+	_, parsed, ok := c.load(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 || n >= len(parsed.Attachments) {
+		http.NotFound(w, r)
+		return
+	}
+
+	attachment := parsed.Attachments[n]
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.Filename))
+	w.Write(attachment.Data)
+}
+
+func (c *Catcher) delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := c.Store.Delete(r.Context(), id); err != nil {
+		catcherError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Catcher) deleteAll(w http.ResponseWriter, r *http.Request) {
+	if err := c.Store.DeleteAll(r.Context()); err != nil {
+		catcherError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// events는 새 메일이 올 때마다 "message" 이벤트로 요약을 보냅니다
+func (c *Catcher) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	messages, unsubscribe := c.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// 구독이 끝난 뒤에 보내서, 이걸 받은 클라이언트는 이후의 메일을 놓치지 않음
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	// 프록시가 유휴 연결을 끊지 않도록
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case msg := <-messages:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", msg.ID, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// load는 경로의 {id} 메일을 읽고 파싱합니다. 실패하면 응답을 쓰고 ok가 false입니다.
+func (c *Catcher) load(w http.ResponseWriter, r *http.Request) (CaughtMessage, parsers.ParsedEmail, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return CaughtMessage{}, parsers.ParsedEmail{}, false
+	}
+	msg, err := c.Store.Get(r.Context(), id)
+	if err != nil {
+		catcherError(w, err)
+		return CaughtMessage{}, parsers.ParsedEmail{}, false
+	}
+	parsed, err := parsers.ParseEmail(string(msg.Raw))
+	if err != nil {
+		http.Error(w, "malformed message: "+err.Error(), http.StatusUnprocessableEntity)
+		return CaughtMessage{}, parsers.ParsedEmail{}, false
+	}
+	msg.Raw = nil
+	return msg, parsed, true
+}
+
+func catcherError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCaughtMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Error("캐처 저장소 오류", "error", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// MemoryCatcherStore는 프로세스 메모리에 메일을 둡니다 (재시작하면 사라짐)
+type MemoryCatcherStore struct {
+	// 보관할 최대 개수 (넘으면 오래된 것부터 지움, 0이면 무제한)
+	Limit int
+
+	mu       sync.Mutex
+	lastID   int64
+	messages []memoryCaughtMessage
+}
+
+type memoryCaughtMessage struct {
+	msg    CaughtMessage
+	search string
+}
+
+func (s *MemoryCatcherStore) Add(_ context.Context, msg CaughtMessage, search string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	msg.ID = s.lastID
+	s.messages = append(s.messages, memoryCaughtMessage{msg: msg, search: search})
+	if s.Limit > 0 && len(s.messages) > s.Limit {
+		s.messages = append([]memoryCaughtMessage(nil), s.messages[len(s.messages)-s.Limit:]...)
+	}
+	return msg.ID, nil
+}
+
+func (s *MemoryCatcherStore) List(_ context.Context, search string, limit int) ([]CaughtMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []CaughtMessage
+	for i := len(s.messages) - 1; i >= 0 && len(messages) < limit; i-- {
+		if strings.Contains(s.messages[i].search, search) {
+			msg := s.messages[i].msg
+			msg.Raw = nil
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *MemoryCatcherStore) Get(_ context.Context, id int64) (CaughtMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i, ok := s.find(id); ok {
+		return s.messages[i].msg, nil
+	}
+	return CaughtMessage{}, ErrCaughtMessageNotFound
+}
+
+func (s *MemoryCatcherStore) Delete(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.find(id)
+	if !ok {
+		return ErrCaughtMessageNotFound
+	}
+	s.messages = append(s.messages[:i], s.messages[i+1:]...)
+	return nil
+}
+
+func (s *MemoryCatcherStore) DeleteAll(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	return nil
+}
+
+// find는 ID 순으로 정렬된 messages에서 id의 위치를 찾습니다
+func (s *MemoryCatcherStore) find(id int64) (int, bool) {
+	i := sort.Search(len(s.messages), func(i int) bool { return s.messages[i].msg.ID >= id })
+	return i, i < len(s.messages) && s.messages[i].msg.ID == id
+}
+
+// SQLiteCatcherSchema는 SQLiteCatcherStore가 쓰는 테이블입니다
+const SQLiteCatcherSchema = `CREATE TABLE IF NOT EXISTS caught_messages (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	received_at TEXT NOT NULL,
+	mail_from   TEXT NOT NULL,
+	rcpt_to     TEXT NOT NULL,
+	remote_ip   TEXT NOT NULL,
+	helo        TEXT NOT NULL,
+	session_id  TEXT NOT NULL,
+	subject     TEXT NOT NULL,
+	size        INTEGER NOT NULL,
+	search      TEXT NOT NULL,
+	raw         BLOB NOT NULL
+);`
+
+// SQLiteCatcherStore는 SQLite 파일에 메일을 둡니다 (재시작해도 남음).
+// OpenSQLiteCatcherStore로 열면 스키마가 만들어집니다.
+type SQLiteCatcherStore struct {
+	DB *sql.DB
+}
+
+// OpenSQLiteCatcherStore는 path의 SQLite 데이터베이스를 열고 스키마를 만듭니다
+func OpenSQLiteCatcherStore(path string) (*SQLiteCatcherStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite는 쓰기가 한 번에 하나뿐이므로 연결을 하나만 씀 (SQLITE_BUSY 방지)
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(SQLiteCatcherSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteCatcherStore{DB: db}, nil
+}
+
+func (s *SQLiteCatcherStore) Close() error {
+	return s.DB.Close()
+}
+
+func (s *SQLiteCatcherStore) Add(ctx context.Context, msg CaughtMessage, search string) (int64, error) {
+	to, err := json.Marshal(msg.To)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.DB.ExecContext(ctx, `
+		INSERT INTO caught_messages (received_at, mail_from, rcpt_to, remote_ip, helo, session_id, subject, size, search, raw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ReceivedAt.UTC().Format(time.RFC3339Nano), msg.From, string(to), msg.RemoteIP, msg.Helo,
+		msg.SessionID, msg.Subject, msg.Size, search, msg.Raw)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// sqliteCaughtColumns는 List/Get 공통 열입니다
+const sqliteCaughtColumns = `id, received_at, mail_from, rcpt_to, remote_ip, helo, session_id, subject, size`
+
+func (s *SQLiteCatcherStore) List(ctx context.Context, search string, limit int) ([]CaughtMessage, error) {
+	// instr는 LIKE와 달리 %, _를 이스케이프할 필요가 없음
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+sqliteCaughtColumns+` FROM caught_messages
+		WHERE ? = '' OR instr(search, ?) > 0
+		ORDER BY id DESC
+		LIMIT ?`, search, search, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []CaughtMessage
+	for rows.Next() {
+		var msg CaughtMessage
+		if err := scanCaughtMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *SQLiteCatcherStore) Get(ctx context.Context, id int64) (CaughtMessage, error) {
+	var msg CaughtMessage
+	row := s.DB.QueryRowContext(ctx, `SELECT `+sqliteCaughtColumns+`, raw FROM caught_messages WHERE id = ?`, id)
+	err := scanCaughtMessage(row, &msg, &msg.Raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CaughtMessage{}, ErrCaughtMessageNotFound
+	}
+	return msg, err
+}
+
+func (s *SQLiteCatcherStore) Delete(ctx context.Context, id int64) error {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM caught_messages WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrCaughtMessageNotFound
+	}
+	return err
+}
+
+func (s *SQLiteCatcherStore) DeleteAll(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM caught_messages`)
+	return err
+}
+
+func scanCaughtMessage(row interface{ Scan(...any) error }, msg *CaughtMessage, extra ...any) error {
+	var receivedAt, to string
+	dest := []any{&msg.ID, &receivedAt, &msg.From, &to, &msg.RemoteIP, &msg.Helo, &msg.SessionID, &msg.Subject, &msg.Size}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	var err error
+	if msg.ReceivedAt, err = time.Parse(time.RFC3339Nano, receivedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(to), &msg.To)
+}
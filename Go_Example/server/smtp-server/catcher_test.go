@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatcherStores(t *testing.T) {
+	t.Parallel()
+
+	stores := map[string]func(t *testing.T) CatcherStore{
+		"memory": func(*testing.T) CatcherStore { return &MemoryCatcherStore{Limit: 3} },
+		"sqlite": func(t *testing.T) CatcherStore {
+			store, err := OpenSQLiteCatcherStore(filepath.Join(t.TempDir(), "catcher.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			store := open(t)
+
+			receivedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			var ids []int64
+			for _, subject := range []string{"first", "second", "third"} {
+				id, err := store.Add(ctx, CaughtMessage{
+					ReceivedAt: receivedAt,
+					From:       "a@example.com",
+					To:         []string{"b@example.com"},
+					Subject:    subject,
+					Raw:        []byte("Subject: " + subject + "\r\n\r\nbody\r\n"),
+				}, "a@example.com\n"+subject)
+				require.NoError(t, err)
+				ids = append(ids, id)
+			}
+
+			// 최신순, Raw는 목록에 없음
+			messages, err := store.List(ctx, "", 10)
+			require.NoError(t, err)
+			require.Len(t, messages, 3)
+			assert.Equal(t, "third", messages[0].Subject)
+			assert.Equal(t, []string{"b@example.com"}, messages[0].To)
+			assert.True(t, receivedAt.Equal(messages[0].ReceivedAt))
+			assert.Nil(t, messages[0].Raw)
+
+			messages, err = store.List(ctx, "sec", 10)
+			require.NoError(t, err)
+			require.Len(t, messages, 1)
+			assert.Equal(t, ids[1], messages[0].ID)
+
+			messages, err = store.List(ctx, "", 1)
+			require.NoError(t, err)
+			assert.Len(t, messages, 1)
+
+			msg, err := store.Get(ctx, ids[0])
+			require.NoError(t, err)
+			assert.Equal(t, "Subject: first\r\n\r\nbody\r\n", string(msg.Raw))
+
+			require.NoError(t, store.Delete(ctx, ids[0]))
+			_, err = store.Get(ctx, ids[0])
+			assert.ErrorIs(t, err, ErrCaughtMessageNotFound)
+			assert.ErrorIs(t, store.Delete(ctx, ids[0]), ErrCaughtMessageNotFound)
+
+			require.NoError(t, store.DeleteAll(ctx))
+			messages, err = store.List(ctx, "", 10)
+			require.NoError(t, err)
+			assert.Empty(t, messages)
+		})
+	}
+}
+
+func TestMemoryCatcherStoreLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	store := &MemoryCatcherStore{Limit: 2}
+	for _, subject := range []string{"first", "second", "third"} {
+		_, err := store.Add(ctx, CaughtMessage{Subject: subject}, subject)
+		require.NoError(t, err)
+	}
+
+	// 오래된 것부터 지워짐
+	messages, err := store.List(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "third", messages[0].Subject)
+	assert.Equal(t, "second", messages[1].Subject)
+}
+
+func getJSON(t *testing.T, url string, value any) {
+	t.Helper()
+
+	res, err := http.Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(value))
+}
+
+func TestCatcherHTTP(t *testing.T) {
+	t.Parallel()
+
+	catcher := NewCatcher(&MemoryCatcherStore{})
+	web := httptest.NewServer(catcher.Handler())
+	t.Cleanup(web.Close)
+	addr := startServer(t, catcher)
+
+	// 구독한 뒤에 메일을 보내야 이벤트를 받음
+	res, err := http.Get(web.URL + "/api/events")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := bufio.NewReader(res.Body)
+	line, err := events.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, ": connected\n", line)
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com", "c@other.org"}, testAttachmentMessage))
+
+	var event strings.Builder
+	for !strings.Contains(event.String(), "\n\n") {
+		line, err := events.ReadString('\n')
+		require.NoError(t, err)
+		if line != "\n" || event.Len() > 0 {
+			event.WriteString(line)
+		}
+	}
+	assert.Contains(t, event.String(), "event: message\n")
+	assert.Contains(t, event.String(), `"subject":"hello"`)
+
+	var messages []CaughtMessage
+	getJSON(t, web.URL+"/api/messages", &messages)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "report", messages[0].Subject)
+	assert.Equal(t, []string{"b@example.com", "c@other.org"}, messages[0].To)
+	assert.NotEmpty(t, messages[0].SessionID)
+
+	// 본문 검색 (대소문자 무시)
+	getJSON(t, web.URL+"/api/messages?q=SEE+ATTACHED", &messages)
+	require.Len(t, messages, 1)
+	message := web.URL + "/api/messages/" + strconv.FormatInt(messages[0].ID, 10)
+	getJSON(t, web.URL+"/api/messages?q=nothing", &messages)
+	assert.Empty(t, messages)
+
+	var detail struct {
+		CaughtMessage
+		Email struct {
+			Subject     string `json:"subject"`
+			TextBody    string `json:"text_body"`
+			Attachments []struct {
+				Filename string `json:"filename"`
+			} `json:"attachments"`
+		} `json:"email"`
+	}
+	getJSON(t, message, &detail)
+	assert.Equal(t, "a@example.com", detail.From)
+	assert.Equal(t, "report", detail.Email.Subject)
+	assert.Contains(t, detail.Email.TextBody, "see attached")
+	require.Len(t, detail.Email.Attachments, 1)
+	assert.Equal(t, "report.pdf", detail.Email.Attachments[0].Filename)
+
+	res, err = http.Get(message + "/attachments/0")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "application/pdf", res.Header.Get("Content-Type"))
+	assert.Equal(t, "%PDF-1.4", string(body))
+
+	res, err = http.Get(message + "/raw")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "message/rfc822", res.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "Subject: report\r\n")
+
+	res, err = http.Get(web.URL + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+
+	// 삭제
+	req, err := http.NewRequest(http.MethodDelete, message, nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	res, err = http.Get(message)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete, web.URL+"/api/messages", nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	getJSON(t, web.URL+"/api/messages", &messages)
+	assert.Empty(t, messages)
+}
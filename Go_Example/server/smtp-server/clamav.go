@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrVirusScanUnavailable = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Virus scan temporarily unavailable",
+}
+
+// VirusFoundError는 감염된 메일에 대한 응답입니다
+func VirusFoundError(virus string) error {
+	return &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Message contains a virus: " + virus,
+	}
+}
+
+// clamdChunkSize는 INSTREAM 청크 하나의 크기입니다 (clamd의 StreamMaxLength와는 별개)
+const clamdChunkSize = 64 * 1024
+
+// Clamd는 clamd 데몬에 INSTREAM으로 검사를 요청하는 클라이언트입니다.
+// Addr은 "host:port"(TCP)나 "unix:/run/clamav/clamd.ctl"(유닉스 소켓)입니다.
+type Clamd struct {
+	Addr string
+	// 연결부터 결과를 받을 때까지의 제한 시간 (0이면 30초)
+	Timeout time.Duration
+}
+
+// Scan은 r을 검사해서, 감염됐으면 바이러스 이름을 반환합니다 (깨끗하면 "")
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	network, addr := "tcp", c.Addr
+	if path, ok := strings.CutPrefix(c.Addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if err := clamdStream(conn, r); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	// "stream: OK", "stream: Eicar-Signature FOUND", "INSTREAM size limit exceeded. ERROR"
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		_, found, _ := strings.Cut(reply, ": ")
+		return strings.TrimSuffix(found, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// clamdStream은 INSTREAM 명령과 길이를 붙인 청크들, 끝을 알리는 길이 0을 씁니다
+func clamdStream(w io.Writer, r io.Reader) error {
+	if _, err := io.WriteString(w, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// VirusScanner는 메일을 clamd로 검사한 뒤 깨끗하면 Next에 넘기는 MessageHandler입니다.
+// 감염된 메일은 거절하거나 (554), QuarantineDir이 있으면 거기에 저장하고 Next에 넘기지 않습니다.
+// 스풀을 쓸 때는 250으로 응답한 뒤에 핸들러가 실행되므로 Backend.VirusScanner로 세션에서 검사합니다.
+type VirusScanner struct {
+	Clamd *Clamd
+	Next  MessageHandler
+	// true면 첨부파일마다 따로 검사 (첨부파일이 없으면 검사하지 않음), false면 원본 메일 전체를 한 번에
+	Attachments bool
+	// true면 clamd에 연결할 수 없거나 시간이 지나도 받음 (fail-open), false면 451로 재시도하게 함
+	FailOpen bool
+	// 설정하면 감염된 메일을 거절하지 않고 이 디렉토리에 .eml로 격리
+	QuarantineDir string
+}
+
+func (v *VirusScanner) HandleMessage(ctx context.Context, msg *Message) error {
+	quarantined, err := v.Check(ctx, msg)
+	if err != nil || quarantined || v.Next == nil {
+		return err
+	}
+	return v.Next.HandleMessage(ctx, msg)
+}
+
+// Check는 msg를 검사해서, 받으면 안 되는 메일이면 응답할 에러를 반환합니다.
+// 감염된 메일을 격리했으면 true를 반환합니다 (받은 것처럼 응답하고 전달하지 않음).
+func (v *VirusScanner) Check(ctx context.Context, msg *Message) (quarantined bool, err error) {
+	virus, err := v.scan(ctx, msg)
+	if err != nil {
+		if !v.FailOpen {
+			slog.Error("바이러스 검사 실패", "session", msg.Envelope.SessionID, "error", err)
+			return false, ErrVirusScanUnavailable
+		}
+		slog.Warn("바이러스 검사 실패, 검사 없이 받음", "session", msg.Envelope.SessionID, "error", err)
+	}
+	if virus == "" {
+		return false, nil
+	}
+
+	if v.QuarantineDir == "" {
+		slog.Info("바이러스 발견, 거절", "session", msg.Envelope.SessionID, "virus", virus)
+		return false, VirusFoundError(virus)
+	}
+	path, err := v.quarantine(msg)
+	if err != nil {
+		// 격리하지 못한 감염 메일을 전달하지 않도록 재시도하게 함
+		slog.Error("격리 실패", "session", msg.Envelope.SessionID, "error", err)
+		return false, TemporaryError("Requested action aborted: local error in processing")
+	}
+	slog.Info("바이러스 발견, 격리", "session", msg.Envelope.SessionID, "virus", virus, "path", path)
+	return true, nil
+}
+
+// scan은 설정에 따라 메일 전체나 첨부파일들을 검사해서 처음 발견한 바이러스 이름을 반환합니다
+func (v *VirusScanner) scan(ctx context.Context, msg *Message) (string, error) {
+	if !v.Attachments {
+		return v.Clamd.Scan(ctx, bytes.NewReader(msg.Raw))
+	}
+	for _, attachment := range msg.Parsed.Attachments {
+		virus, err := v.Clamd.Scan(ctx, bytes.NewReader(attachment.Data))
+		if err != nil || virus != "" {
+			return virus, err
+		}
+	}
+	return "", nil
+}
+
+// quarantine은 msg를 QuarantineDir에 씁니다. 봉투는 파일 이름과 X-Quarantine-* 헤더로 남깁니다.
+func (v *VirusScanner) quarantine(msg *Message) (string, error) {
+	if err := os.MkdirAll(v.QuarantineDir, 0o700); err != nil {
+		return "", err
+	}
+	id, err := newSpoolID()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(v.QuarantineDir, id+".eml")
+	header := fmt.Sprintf("X-Quarantine-From: <%s>\r\nX-Quarantine-To: %s\r\nX-Quarantine-Session: %s\r\n",
+		msg.Envelope.From, strings.Join(msg.Envelope.To, ", "), msg.Envelope.SessionID)
+	return path, os.WriteFile(path, append([]byte(header), msg.Raw...), 0o600)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeClamd는 INSTREAM 데이터에 signature가 있으면 "FOUND"로 답하는 clamd입니다 (hang이면 답하지 않음)
+func startFakeClamd(t *testing.T, network, addr, signature string, hang bool) string {
+	t.Helper()
+
+	ln, err := net.Listen(network, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				var data []byte
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					chunk := make([]byte, size)
+					if _, err := io.ReadFull(r, chunk); err != nil {
+						return
+					}
+					data = append(data, chunk...)
+				}
+				if hang {
+					io.Copy(io.Discard, r)
+					return
+				}
+				if bytes.Contains(data, []byte(signature)) {
+					io.WriteString(conn, "stream: Test-Signature FOUND\x00")
+				} else {
+					io.WriteString(conn, "stream: OK\x00")
+				}
+			}()
+		}
+	}()
+
+	if network == "unix" {
+		return "unix:" + addr
+	}
+	return ln.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	t.Parallel()
+
+	// 유닉스 소켓 경로는 길이 제한이 있어서 짧은 임시 디렉토리에
+	dir, err := os.MkdirTemp("", "clamd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, addr := range map[string]string{
+		"tcp":  startFakeClamd(t, "tcp", "127.0.0.1:0", "EICAR", false),
+		"unix": startFakeClamd(t, "unix", filepath.Join(dir, "clamd.sock"), "EICAR", false),
+	} {
+		t.Run(name, func(t *testing.T) {
+			clamd := &Clamd{Addr: addr, Timeout: 5 * time.Second}
+
+			virus, err := clamd.Scan(context.Background(), strings.NewReader(testMessage))
+			require.NoError(t, err)
+			assert.Empty(t, virus)
+
+			// 시그니처가 청크 경계에 걸쳐도 clamd에는 이어진 스트림으로 감
+			large := strings.Repeat("x", clamdChunkSize-2) + "EICAR" + strings.Repeat("y", clamdChunkSize)
+			virus, err = clamd.Scan(context.Background(), strings.NewReader(large))
+			require.NoError(t, err)
+			assert.Equal(t, "Test-Signature", virus)
+		})
+	}
+
+	clamd := &Clamd{Addr: startFakeClamd(t, "tcp", "127.0.0.1:0", "EICAR", true), Timeout: 100 * time.Millisecond}
+	_, err = clamd.Scan(context.Background(), strings.NewReader(testMessage))
+	assert.Error(t, err)
+}
+
+func TestVirusScanner(t *testing.T) {
+	t.Parallel()
+
+	clamdAddr := startFakeClamd(t, "tcp", "127.0.0.1:0", "EICAR", false)
+	infected := strings.Replace(testMessage, "hello", "EICAR", 1)
+
+	t.Run("reject", func(t *testing.T) {
+		t.Parallel()
+		handler := &recordingHandler{}
+		addr := startServerWithBackend(t, &Backend{Handler: &VirusScanner{
+			Clamd: &Clamd{Addr: clamdAddr},
+			Next:  handler,
+		}})
+
+		require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+		err := sendMail(addr, "a@example.com", []string{"b@example.com"}, infected)
+		var smtpErr *smtp.SMTPError
+		require.ErrorAs(t, err, &smtpErr)
+		assert.Equal(t, 554, smtpErr.Code)
+		assert.Contains(t, smtpErr.Message, "Test-Signature")
+		_, delivered := handler.snapshot()
+		assert.Len(t, delivered, 1)
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		t.Parallel()
+		handler := &recordingHandler{}
+		dir := filepath.Join(t.TempDir(), "quarantine")
+		addr := startServerWithBackend(t, &Backend{Handler: &VirusScanner{
+			Clamd:         &Clamd{Addr: clamdAddr},
+			Next:          handler,
+			QuarantineDir: dir,
+		}})
+
+		// 격리한 메일은 받은 것처럼 응답하지만 Next로 넘기지 않음
+		require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, infected))
+		calls, _ := handler.snapshot()
+		assert.Zero(t, calls)
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "X-Quarantine-From: <a@example.com>\r\nX-Quarantine-To: b@example.com\r\n"))
+		assert.Contains(t, string(data), "EICAR")
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		t.Parallel()
+		// "%PDF"는 base64로 인코딩된 원본에는 없고 디코딩한 첨부파일에만 있음
+		pdfClamd := &Clamd{Addr: startFakeClamd(t, "tcp", "127.0.0.1:0", "%PDF", false)}
+		handler := &recordingHandler{}
+		scanner := &VirusScanner{Clamd: pdfClamd, Next: handler}
+		addr := startServerWithBackend(t, &Backend{Handler: scanner})
+		require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testAttachmentMessage))
+
+		scanner.Attachments = true
+		err := sendMail(addr, "a@example.com", []string{"b@example.com"}, testAttachmentMessage)
+		var smtpErr *smtp.SMTPError
+		require.ErrorAs(t, err, &smtpErr)
+		assert.Equal(t, 554, smtpErr.Code)
+		// 첨부파일이 없으면 검사하지 않음
+		require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, strings.Replace(testMessage, "hello", "%PDF", 1)))
+		_, delivered := handler.snapshot()
+		assert.Len(t, delivered, 2)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		t.Parallel()
+		// 닫힌 포트
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		deadAddr := ln.Addr().String()
+		ln.Close()
+
+		handler := &recordingHandler{}
+		scanner := &VirusScanner{Clamd: &Clamd{Addr: deadAddr, Timeout: time.Second}, Next: handler}
+		addr := startServerWithBackend(t, &Backend{Handler: scanner})
+
+		err = sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage)
+		var smtpErr *smtp.SMTPError
+		require.ErrorAs(t, err, &smtpErr)
+		assert.Equal(t, 451, smtpErr.Code)
+
+		scanner.FailOpen = true
+		require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+		_, delivered := handler.snapshot()
+		assert.Len(t, delivered, 1)
+	})
+}
+
+func TestVirusScanWithSpool(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}}
+	config.SpoolDir = filepath.Join(dir, "spool")
+	config.ClamAV = &ClamAVConfig{Addr: startFakeClamd(t, "tcp", "127.0.0.1:0", "EICAR", false)}
+	handler := &recordingHandler{}
+	app, err := NewApp(config, handler)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	addr := app.Listeners[0].Addr().String()
+
+	// 스풀에 넣고 250으로 응답하기 전에 검사해서 거절
+	infected := strings.Replace(testMessage, "hello", "EICAR", 1)
+	err = sendMail(addr, "a@example.com", []string{"b@example.com"}, infected)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 554, smtpErr.Code)
+	assert.Zero(t, countFiles(t, filepath.Join(config.SpoolDir, spoolMsg)))
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	calls, _ := handler.snapshot()
+	assert.Equal(t, 1, calls)
+	require.Eventually(t, func() bool { return countFiles(t, filepath.Join(config.SpoolDir, spoolMsg)) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// 격리할 때도 스풀에 넣지 않음
+	app.Backend.VirusScanner.QuarantineDir = filepath.Join(dir, "quarantine")
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, infected))
+	assert.Equal(t, 1, countFiles(t, app.Backend.VirusScanner.QuarantineDir))
+	assert.Zero(t, countFiles(t, filepath.Join(config.SpoolDir, spoolMsg)))
+	calls, _ = handler.snapshot()
+	assert.Equal(t, 1, calls)
+}
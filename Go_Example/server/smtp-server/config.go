@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration은 JSON에서 "10s" 같은 문자열로 쓰는 time.Duration입니다
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ListenerConfig는 리스너 하나의 설정입니다
+type ListenerConfig struct {
+	Addr string `json:"addr"`
+	// true면 implicit TLS (465 방식), false면 평문 + STARTTLS
+	TLS bool `json:"tls"`
+	// true면 SMTP 대신 LMTP (RFC 2033, 앞단 MTA의 로컬 전달용). DATA 뒤에 수신자마다 응답함
+	LMTP bool `json:"lmtp"`
+	// true면 연결 앞의 PROXY protocol v1/v2 헤더에서 실제 클라이언트 주소를 읽음 (로드 밸런서 뒤에서)
+	ProxyProtocol bool `json:"proxy_protocol"`
+	// 헤더를 믿는 로드 밸런서의 CIDR이나 IP (proxy_protocol이면 필수, 나머지 연결은 헤더를 읽지 않음)
+	ProxyTrusted []string `json:"proxy_trusted"`
+}
+
+type TLSConfig struct {
+	CertFile       string `json:"cert_file"`
+	KeyFile        string `json:"key_file"`
+	RequireForAuth bool   `json:"require_for_auth"`
+	RequireForMail bool   `json:"require_for_mail"`
+}
+
+type RecipientConfig struct {
+	Domains         []string `json:"domains"`
+	Mailboxes       []string `json:"mailboxes"`
+	CatchAllDomains []string `json:"catch_all_domains"`
+	PlusSeparator   string   `json:"plus_separator"`
+	// "postgres"면 mailboxes에 없는 주소를 postgres_dsn의 데이터베이스에서 조회
+	Lookup StoreKind `json:"lookup"`
+	// $1에 정규화된 주소를 받아 bool 하나를 반환하는 쿼리 (비어 있으면 mailboxes 테이블)
+	LookupQuery string `json:"lookup_query"`
+}
+
+// StoreKind는 그레이리스트 기록, 메일함, 자격 증명을 어디에 두는지입니다
+type StoreKind string
+
+const (
+	StoreMemory   StoreKind = "memory"
+	StoreFile     StoreKind = "file"
+	StorePostgres StoreKind = "postgres"
+)
+
+// SPFConfig는 SPF 결과별 처리입니다 ("accept", "tag", "reject", 비어 있으면 SPFPolicy 기본값)
+type SPFConfig struct {
+	Fail      SPFAction `json:"fail"`
+	SoftFail  SPFAction `json:"softfail"`
+	TempError SPFAction `json:"temperror"`
+	PermError SPFAction `json:"permerror"`
+}
+
+// SpamConfig는 스팸 점수 설정입니다. tag, reject는 기본 기준이고 domains로 수신 도메인마다 바꿀 수 있습니다.
+type SpamConfig struct {
+	Rules   []SpamRule                `json:"rules"`
+	Tag     float64                   `json:"tag"`
+	Reject  float64                   `json:"reject"`
+	Domains map[string]SpamThresholds `json:"domains"`
+	// dkim 규칙이 믿는 Authentication-Results의 authserv-id (비어 있으면 domain)
+	AuthServIDs []string `json:"authserv_ids"`
+}
+
+// ClamAVConfig는 clamd 바이러스 검사 설정입니다
+type ClamAVConfig struct {
+	// "127.0.0.1:3310"이나 "unix:/run/clamav/clamd.ctl"
+	Addr    string   `json:"addr"`
+	Timeout Duration `json:"timeout"`
+	// true면 clamd 장애 때도 받음, false면 451로 재시도하게 함
+	FailOpen bool `json:"fail_open"`
+	// true면 첨부파일만 하나씩 검사
+	Attachments bool `json:"attachments"`
+	// 설정하면 감염된 메일을 거절하지 않고 여기에 격리
+	QuarantineDir string `json:"quarantine_dir"`
+}
+
+// SignedAddressConfig는 서명된 회신/VERP 주소 설정입니다
+type SignedAddressConfig struct {
+	// 주소의 도메인 (비어 있으면 domain)
+	Domain string `json:"domain"`
+	// HMAC 키. 첫 번째 키로 서명하고 모든 키로 확인함 (키 교체용)
+	Secrets []string `json:"secrets"`
+}
+
+// GreylistConfig는 그레이리스트 설정입니다 (비어 있으면 Greylist 기본값)
+type GreylistConfig struct {
+	// "memory"(기본값)나 "postgres" (postgres_dsn의 smtp_greylist 테이블, 여러 인스턴스가 공유)
+	Store       StoreKind `json:"store"`
+	Delay       Duration  `json:"delay"`
+	RetryWindow Duration  `json:"retry_window"`
+	Expiry      Duration  `json:"expiry"`
+}
+
+// RateLimitConfig는 전송량 제한 설정입니다. allow/deny는 CIDR이나 IP 목록입니다.
+type RateLimitConfig struct {
+	PerIP     RateLimits `json:"per_ip"`
+	PerUser   RateLimits `json:"per_user"`
+	PerDomain RateLimits `json:"per_domain"`
+	Allow     []string   `json:"allow"`
+	Deny      []string   `json:"deny"`
+}
+
+// WebhookConfig는 수신 도메인 하나의 웹훅 대상입니다 (domain이 "*"면 나머지 전체)
+type WebhookConfig struct {
+	Domain            string        `json:"domain"`
+	URL               string        `json:"url"`
+	Secret            string        `json:"secret"`
+	Format            WebhookFormat `json:"format"`
+	InlineAttachments bool          `json:"inline_attachments"`
+}
+
+// RelayConfig는 외부 발송 설정입니다
+type RelayConfig struct {
+	// 외부 발송 큐 디렉토리 (spool_dir과 달라야 함)
+	QueueDir string             `json:"queue_dir"`
+	Routes   []RelayRouteConfig `json:"routes"`
+}
+
+// RelayRouteConfig는 수신 도메인을 스마트호스트로 보내는 규칙입니다 (domains에 "*"면 나머지 전체)
+type RelayRouteConfig struct {
+	Domains  []string `json:"domains"`
+	Host     string   `json:"host"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TLS      RelayTLS `json:"tls"`
+}
+
+// DKIMConfig는 중계하는 메일의 DKIM 서명 설정입니다
+type DKIMConfig struct {
+	// c= 태그 형식 ("relaxed/relaxed", "relaxed/simple", "simple/simple", 비어 있으면 relaxed/relaxed)
+	Canonicalization string `json:"canonicalization"`
+	// 서명할 헤더 (비어 있으면 DefaultDKIMHeaders, From은 꼭 있어야 함)
+	Headers []string `json:"headers"`
+	// 0이 아니면 서명 유효 기간 (x= 태그)
+	Expiration Duration        `json:"expiration"`
+	Keys       []DKIMKeyConfig `json:"keys"`
+}
+
+// DKIMKeyConfig는 보내는 도메인 하나의 키입니다. key_file은 PEM(RSA나 Ed25519)입니다.
+type DKIMKeyConfig struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+	KeyFile  string `json:"key_file"`
+}
+
+// CatcherConfig는 개발용 메일 수집기 설정입니다
+type CatcherConfig struct {
+	// 웹 UI와 API 주소 (기본값 ":8025")
+	Addr string `json:"addr"`
+	// 설정하면 SQLite 파일에 보관, 비어 있으면 메모리
+	SQLitePath string `json:"sqlite_path"`
+	// 메모리에 보관할 최대 개수 (기본값 1000)
+	MemoryLimit int `json:"memory_limit"`
+}
+
+// Config는 SMTP 서버 설정입니다. 기본값 → 설정 파일(JSON) → 환경 변수 순으로 덮어씁니다.
+type Config struct {
+	Domain          string           `json:"domain"`
+	Listeners       []ListenerConfig `json:"listeners"`
+	ReadTimeout     Duration         `json:"read_timeout"`
+	WriteTimeout    Duration         `json:"write_timeout"`
+	MaxMessageBytes int64            `json:"max_message_bytes"`
+	MaxRecipients   int              `json:"max_recipients"`
+	// SIGTERM 후 진행 중인 세션을 기다리는 최대 시간
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	TLS             TLSConfig `json:"tls"`
+	CredentialsFile string    `json:"credentials_file"`
+	// "file"(기본값, credentials_file)이나 "postgres" (postgres_dsn의 smtp_users 테이블)
+	CredentialsStore StoreKind `json:"credentials_store"`
+	// $1에 username을 받아 (bcrypt 해시, 쉼표로 구분된 허용 발신 주소)를 반환하는 쿼리 (비어 있으면 smtp_users 테이블)
+	CredentialsQuery string           `json:"credentials_query"`
+	SpoolDir         string           `json:"spool_dir"`
+	Recipients       *RecipientConfig `json:"recipients"`
+	// 설정하면 MAIL FROM의 SPF를 검사 (시스템 DNS 사용)
+	SPF *SPFConfig `json:"spf"`
+	// 설정하면 DATA에서 스팸 점수를 매겨 태그하거나 거절 (DNSBL은 시스템 DNS 사용)
+	Spam *SpamConfig `json:"spam"`
+	// 설정하면 메일을 clamd로 검사한 뒤에 핸들러에 넘김 (감염되면 거절하거나 격리)
+	ClamAV *ClamAVConfig `json:"clamav"`
+	// 설정하면 reply+<token>, bounce+<token> 주소의 서명과 만료를 RCPT에서 확인
+	SignedAddressing *SignedAddressConfig `json:"signed_addresses"`
+	// 설정하면 RCPT에서 그레이리스트 적용
+	Greylisting *GreylistConfig  `json:"greylist"`
+	RateLimit   *RateLimitConfig `json:"rate_limit"`
+	// 설정하면 이 주소의 /metrics로 Prometheus 지표를 노출 (예: ":9090")
+	MetricsAddr string `json:"metrics_addr"`
+	// 설정하면 받은 메일을 Postgres에 저장 (시작할 때 마이그레이션 적용, 핸들러 앞에 Chain으로 붙음).
+	// greylist.store, recipients.lookup, credentials_store를 "postgres"로 하면 같은 데이터베이스를 씀.
+	PostgresDSN string `json:"postgres_dsn"`
+	// 설정하면 받은 메일을 보관하고 웹 UI와 API로 보여줌 (로컬 개발, CI용)
+	Catching *CatcherConfig `json:"catcher"`
+	// 설정하면 라우트에 해당하는 수신자의 메일을 스마트호스트로 중계 (핸들러 뒤에 Chain으로 붙음)
+	Relaying *RelayConfig `json:"relay"`
+	// 설정하면 인증한 사용자가 보내는 메일과 반송 메일에 보내는 도메인의 키로 DKIM 서명 (relay 필요)
+	DKIM *DKIMConfig `json:"dkim"`
+	// 받은 메일을 POST할 웹훅 (핸들러 뒤에 Chain으로 붙음)
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// DefaultConfig는 기존 main()에 하드코딩돼 있던 값입니다
+func DefaultConfig() Config {
+	return Config{
+		Domain:          "localhost",
+		Listeners:       []ListenerConfig{{Addr: ":2525"}}, // SMTP 포트 (25번 대신 2525 사용)
+		ReadTimeout:     Duration(10 * time.Second),
+		WriteTimeout:    Duration(10 * time.Second),
+		MaxMessageBytes: 1024 * 1024, // 1MB
+		MaxRecipients:   50,
+		ShutdownTimeout: Duration(30 * time.Second),
+	}
+}
+
+// LoadConfig는 path의 설정 파일(비어 있으면 생략)과 SMTP_* 환경 변수로 설정을 읽습니다
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	if len(config.Listeners) == 0 {
+		return Config{}, fmt.Errorf("config: at least one listener is required")
+	}
+	for _, listener := range config.Listeners {
+		if listener.TLS && config.TLS.CertFile == "" {
+			return Config{}, fmt.Errorf("config: TLS listener %s requires tls.cert_file", listener.Addr)
+		}
+		if listener.ProxyProtocol {
+			if len(listener.ProxyTrusted) == 0 {
+				return Config{}, fmt.Errorf("config: PROXY protocol listener %s requires proxy_trusted", listener.Addr)
+			}
+			if _, err := ParseCIDRs(listener.ProxyTrusted); err != nil {
+				return Config{}, fmt.Errorf("config: listener %s proxy_trusted: %w", listener.Addr, err)
+			}
+		}
+	}
+
+	if config.SPF != nil {
+		for _, action := range []SPFAction{config.SPF.Fail, config.SPF.SoftFail, config.SPF.TempError, config.SPF.PermError} {
+			switch action {
+			case "", SPFAccept, SPFTag, SPFReject:
+			default:
+				return Config{}, fmt.Errorf("config: unknown SPF action %q", action)
+			}
+		}
+	}
+
+	for _, webhook := range config.Webhooks {
+		if webhook.URL == "" || webhook.Domain == "" {
+			return Config{}, fmt.Errorf("config: webhook requires domain and url")
+		}
+		switch webhook.Format {
+		case "", WebhookJSON, WebhookRaw:
+		default:
+			return Config{}, fmt.Errorf("config: unknown webhook format %q", webhook.Format)
+		}
+	}
+
+	if _, err := config.SpamFilter(); err != nil {
+		return Config{}, err
+	}
+
+	if config.Greylisting != nil {
+		if err := config.checkStore("greylist.store", config.Greylisting.Store, StoreMemory); err != nil {
+			return Config{}, err
+		}
+	}
+	if config.Recipients != nil {
+		if err := config.checkStore("recipients.lookup", config.Recipients.Lookup, ""); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := config.checkStore("credentials_store", config.CredentialsStore, StoreFile); err != nil {
+		return Config{}, err
+	}
+	switch {
+	case config.CredentialsStore == StoreFile && config.CredentialsFile == "":
+		return Config{}, fmt.Errorf("config: credentials_store file requires credentials_file")
+	case config.CredentialsStore == StorePostgres && config.CredentialsFile != "":
+		return Config{}, fmt.Errorf("config: credentials_file is not used with credentials_store postgres")
+	}
+
+	if config.SignedAddressing != nil && len(config.SignedAddressing.Secrets) == 0 {
+		return Config{}, fmt.Errorf("config: signed_addresses requires secrets")
+	}
+
+	if config.ClamAV != nil && config.ClamAV.Addr == "" {
+		return Config{}, fmt.Errorf("config: clamav requires addr")
+	}
+
+	if config.Catching != nil && config.Catching.Addr == "" {
+		config.Catching.Addr = ":8025"
+	}
+
+	if config.Relaying != nil {
+		if config.Relaying.QueueDir == "" || config.Relaying.QueueDir == config.SpoolDir {
+			return Config{}, fmt.Errorf("config: relay requires its own queue_dir")
+		}
+		for _, route := range config.Relaying.Routes {
+			if route.Host == "" || len(route.Domains) == 0 {
+				return Config{}, fmt.Errorf("config: relay route requires domains and host")
+			}
+			switch route.TLS {
+			case "", RelaySTARTTLS, RelayImplicitTLS, RelayPlaintext:
+			default:
+				return Config{}, fmt.Errorf("config: unknown relay tls %q", route.TLS)
+			}
+			// 수신자 제한 없이 "*"로 중계하면 오픈 릴레이가 됨
+			if containsFold(route.Domains, "*") && config.Recipients == nil {
+				return Config{}, fmt.Errorf("config: relay route \"*\" requires recipients")
+			}
+		}
+	}
+
+	if config.DKIM != nil {
+		if config.Relaying == nil {
+			return Config{}, fmt.Errorf("config: dkim requires relay")
+		}
+		if _, _, err := ParseDKIMCanonicalization(config.DKIM.Canonicalization); err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+		if len(config.DKIM.Headers) > 0 && !containsFold(config.DKIM.Headers, "From") {
+			return Config{}, fmt.Errorf("config: %w", errDKIMNoFrom)
+		}
+		for _, key := range config.DKIM.Keys {
+			if key.Domain == "" || key.Selector == "" || key.KeyFile == "" {
+				return Config{}, fmt.Errorf("config: dkim key requires domain, selector and key_file")
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// checkStore는 kind가 비어 있거나 fallback이거나, postgres_dsn이 있을 때의 "postgres"인지 확인합니다
+func (c Config) checkStore(name string, kind, fallback StoreKind) error {
+	switch kind {
+	case "", fallback:
+		return nil
+	case StorePostgres:
+		if c.PostgresDSN == "" {
+			return fmt.Errorf("config: %s postgres requires postgres_dsn", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("config: unknown %s %q", name, kind)
+}
+
+// applyEnv는 환경 변수로 설정을 덮어씁니다.
+// SMTP_LISTEN은 쉼표로 구분한 주소 목록이고, "/tls"를 붙이면 implicit TLS, "/lmtp"를 붙이면 LMTP,
+// 마지막에 "/proxy"를 붙이면 PROXY protocol입니다 (예: ":2525,:4650/tls/proxy,127.0.0.1:24/lmtp").
+// PROXY protocol 리스너는 SMTP_PROXY_TRUSTED(쉼표로 구분한 CIDR)의 로드 밸런서만 믿습니다.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"SMTP_DOMAIN":           &c.Domain,
+		"SMTP_TLS_CERT":         &c.TLS.CertFile,
+		"SMTP_TLS_KEY":          &c.TLS.KeyFile,
+		"SMTP_CREDENTIALS_FILE": &c.CredentialsFile,
+		"SMTP_SPOOL_DIR":        &c.SpoolDir,
+		"SMTP_METRICS_ADDR":     &c.MetricsAddr,
+		"SMTP_POSTGRES_DSN":     &c.PostgresDSN,
+	}
+	for key, target := range strs {
+		if value, ok := lookup(key); ok {
+			*target = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"SMTP_READ_TIMEOUT":     &c.ReadTimeout,
+		"SMTP_WRITE_TIMEOUT":    &c.WriteTimeout,
+		"SMTP_SHUTDOWN_TIMEOUT": &c.ShutdownTimeout,
+	}
+	for key, target := range durations {
+		if value, ok := lookup(key); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*target = Duration(parsed)
+		}
+	}
+
+	// SMTP_CREDENTIALS_STORE는 "file"이나 "postgres"
+	if value, ok := lookup("SMTP_CREDENTIALS_STORE"); ok {
+		c.CredentialsStore = StoreKind(value)
+	}
+
+	if value, ok := lookup("SMTP_MAX_MESSAGE_BYTES"); ok {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("SMTP_MAX_MESSAGE_BYTES: %w", err)
+		}
+		c.MaxMessageBytes = parsed
+	}
+	if value, ok := lookup("SMTP_MAX_RECIPIENTS"); ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("SMTP_MAX_RECIPIENTS: %w", err)
+		}
+		c.MaxRecipients = parsed
+	}
+
+	if value, ok := lookup("SMTP_LISTEN"); ok {
+		c.Listeners = nil
+		for _, addr := range strings.Split(value, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			addr, proxy := strings.CutSuffix(addr, "/proxy")
+			addr, lmtp := strings.CutSuffix(addr, "/lmtp")
+			addr, tls := strings.CutSuffix(addr, "/tls")
+			c.Listeners = append(c.Listeners, ListenerConfig{Addr: addr, TLS: tls, LMTP: lmtp, ProxyProtocol: proxy})
+		}
+	}
+	if value, ok := lookup("SMTP_PROXY_TRUSTED"); ok {
+		for i := range c.Listeners {
+			if c.Listeners[i].ProxyProtocol {
+				c.Listeners[i].ProxyTrusted = strings.Split(value, ",")
+			}
+		}
+	}
+
+	// SMTP_SIGNED_ADDRESS_SECRETS는 쉼표로 구분한 HMAC 키 목록 (첫 번째로 서명)
+	if value, ok := lookup("SMTP_SIGNED_ADDRESS_SECRETS"); ok {
+		if c.SignedAddressing == nil {
+			c.SignedAddressing = &SignedAddressConfig{}
+		}
+		c.SignedAddressing.Secrets = strings.Split(value, ",")
+	}
+
+	// SMTP_CLAMAV_ADDR을 설정하면 바이러스 검사 (거절, fail-closed)
+	if value, ok := lookup("SMTP_CLAMAV_ADDR"); ok {
+		if c.ClamAV == nil {
+			c.ClamAV = &ClamAVConfig{}
+		}
+		c.ClamAV.Addr = value
+	}
+
+	// SMTP_CATCHER_ADDR을 설정하면 캐처 모드 (메모리 보관)
+	if value, ok := lookup("SMTP_CATCHER_ADDR"); ok {
+		if c.Catching == nil {
+			c.Catching = &CatcherConfig{}
+		}
+		c.Catching.Addr = value
+	}
+
+	if value, ok := lookup("SMTP_ACCEPT_DOMAINS"); ok {
+		if c.Recipients == nil {
+			c.Recipients = &RecipientConfig{}
+		}
+		c.Recipients.Domains = strings.Split(value, ",")
+	}
+
+	return nil
+}
+
+// RecipientPolicy는 설정으로 RecipientPolicy를 만듭니다 (설정이 없으면 nil: 모든 수신자 허용).
+// recipients.lookup이 "postgres"면 db에서 메일함을 조회합니다.
+func (c Config) RecipientPolicy(db *sql.DB) *RecipientPolicy {
+	if c.Recipients == nil {
+		return nil
+	}
+	policy := &RecipientPolicy{
+		Domains:         c.Recipients.Domains,
+		Mailboxes:       c.Recipients.Mailboxes,
+		CatchAllDomains: c.Recipients.CatchAllDomains,
+		PlusSeparator:   c.Recipients.PlusSeparator,
+	}
+	if c.Recipients.Lookup == StorePostgres {
+		policy.Lookup = &PostgresRecipientLookup{DB: db, Query: c.Recipients.LookupQuery}
+	}
+	return policy
+}
+
+// CredentialStore는 설정으로 SMTP AUTH 자격 증명 저장소를 만듭니다 (설정이 없으면 nil: AUTH를 광고하지 않음).
+// credentials_store가 "postgres"면 db에서 조회합니다.
+func (c Config) CredentialStore(db *sql.DB) (CredentialStore, error) {
+	if c.CredentialsStore == StorePostgres {
+		return &PostgresCredentialStore{DB: db, Query: c.CredentialsQuery}, nil
+	}
+	if c.CredentialsFile == "" {
+		return nil, nil
+	}
+	store, err := LoadFileCredentialStore(c.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// SPFPolicy는 설정으로 SPFPolicy를 만듭니다 (설정이 없으면 nil: 검사하지 않음)
+func (c Config) SPFPolicy() *SPFPolicy {
+	if c.SPF == nil {
+		return nil
+	}
+	return &SPFPolicy{
+		Checker:   &SPFChecker{Resolver: NetResolver{}, Hostname: c.Domain},
+		Fail:      c.SPF.Fail,
+		SoftFail:  c.SPF.SoftFail,
+		TempError: c.SPF.TempError,
+		PermError: c.SPF.PermError,
+	}
+}
+
+// SpamFilter는 설정으로 SpamFilter를 만듭니다 (설정이 없으면 nil: 검사하지 않음)
+func (c Config) SpamFilter() (*SpamFilter, error) {
+	if c.Spam == nil {
+		return nil, nil
+	}
+	filter, err := NewSpamFilter(c.Spam.Rules, NetResolver{})
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	filter.Default = SpamThresholds{Tag: c.Spam.Tag, Reject: c.Spam.Reject}
+	filter.Domains = c.Spam.Domains
+	filter.AuthServIDs = c.Spam.AuthServIDs
+	if len(filter.AuthServIDs) == 0 {
+		filter.AuthServIDs = []string{c.Domain}
+	}
+	return filter, nil
+}
+
+// VirusScanner는 설정으로 Backend.VirusScanner에 쓸 VirusScanner를 만듭니다 (설정이 없으면 nil: 검사하지 않음)
+func (c Config) VirusScanner() *VirusScanner {
+	if c.ClamAV == nil {
+		return nil
+	}
+	return &VirusScanner{
+		Clamd:         &Clamd{Addr: c.ClamAV.Addr, Timeout: time.Duration(c.ClamAV.Timeout)},
+		Attachments:   c.ClamAV.Attachments,
+		FailOpen:      c.ClamAV.FailOpen,
+		QuarantineDir: c.ClamAV.QuarantineDir,
+	}
+}
+
+// SignedAddresses는 설정으로 SignedAddresses를 만듭니다 (설정이 없으면 nil: 확인하지 않음)
+func (c Config) SignedAddresses() *SignedAddresses {
+	if c.SignedAddressing == nil {
+		return nil
+	}
+	domain := c.SignedAddressing.Domain
+	if domain == "" {
+		domain = c.Domain
+	}
+	keys := make([][]byte, 0, len(c.SignedAddressing.Secrets))
+	for _, secret := range c.SignedAddressing.Secrets {
+		keys = append(keys, []byte(secret))
+	}
+	return &SignedAddresses{Domain: domain, Keys: keys}
+}
+
+// Greylist는 설정으로 Greylist를 만듭니다 (설정이 없으면 nil: 적용하지 않음).
+// greylist.store가 "postgres"면 db에 기록을 둡니다 (테이블은 PostgresGreylistStore.Migrate로 만듦).
+func (c Config) Greylist(db *sql.DB) *Greylist {
+	if c.Greylisting == nil {
+		return nil
+	}
+	var store GreylistStore = &MemoryGreylistStore{}
+	if c.Greylisting.Store == StorePostgres {
+		store = &PostgresGreylistStore{DB: db}
+	}
+	return &Greylist{
+		Store:       store,
+		Delay:       time.Duration(c.Greylisting.Delay),
+		RetryWindow: time.Duration(c.Greylisting.RetryWindow),
+		Expiry:      time.Duration(c.Greylisting.Expiry),
+	}
+}
+
+// RateLimiter는 설정으로 RateLimiter를 만듭니다 (설정이 없으면 nil: 제한 없음)
+func (c Config) RateLimiter() (*RateLimiter, error) {
+	if c.RateLimit == nil {
+		return nil, nil
+	}
+	allow, err := ParseCIDRs(c.RateLimit.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("config: rate_limit.allow: %w", err)
+	}
+	deny, err := ParseCIDRs(c.RateLimit.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("config: rate_limit.deny: %w", err)
+	}
+	return &RateLimiter{
+		PerIP:     c.RateLimit.PerIP,
+		PerUser:   c.RateLimit.PerUser,
+		PerDomain: c.RateLimit.PerDomain,
+		Allow:     allow,
+		Deny:      deny,
+	}, nil
+}
+
+// WebhookHandler는 설정으로 WebhookHandler를 만듭니다 (설정이 없으면 nil)
+func (c Config) WebhookHandler() *WebhookHandler {
+	if len(c.Webhooks) == 0 {
+		return nil
+	}
+	routes := map[string][]WebhookTarget{}
+	for _, webhook := range c.Webhooks {
+		routes[webhook.Domain] = append(routes[webhook.Domain], WebhookTarget{
+			URL:               webhook.URL,
+			Secret:            webhook.Secret,
+			Format:            webhook.Format,
+			InlineAttachments: webhook.InlineAttachments,
+		})
+	}
+	return NewWebhookHandler(routes)
+}
+
+// Relay는 설정으로 Relay를 만듭니다 (설정이 없으면 nil: 중계하지 않음)
+func (c Config) Relay() (*Relay, error) {
+	if c.Relaying == nil {
+		return nil, nil
+	}
+	routes := make([]RelayRoute, 0, len(c.Relaying.Routes))
+	for _, route := range c.Relaying.Routes {
+		routes = append(routes, RelayRoute{
+			Domains:  route.Domains,
+			Host:     route.Host,
+			Username: route.Username,
+			Password: route.Password,
+			TLS:      route.TLS,
+		})
+	}
+	relay, err := NewRelay(routes, c.Relaying.QueueDir, c.Domain)
+	if err != nil {
+		return nil, err
+	}
+	// 중계는 IsLocal만 쓰므로 메일함 조회는 필요 없음
+	relay.Recipients = c.RecipientPolicy(nil)
+	return relay, nil
+}
+
+// DKIMSigner는 설정으로 키 파일을 읽어 DKIMSigner를 만듭니다 (설정이 없으면 nil: 서명하지 않음)
+func (c Config) DKIMSigner() (*DKIMSigner, error) {
+	if c.DKIM == nil {
+		return nil, nil
+	}
+	keys := make([]*DKIMKey, 0, len(c.DKIM.Keys))
+	for _, keyConfig := range c.DKIM.Keys {
+		key, err := LoadDKIMKey(keyConfig.Domain, keyConfig.Selector, keyConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	var headers []string
+	if len(c.DKIM.Headers) > 0 {
+		headers = c.DKIM.Headers
+	}
+	signer, err := NewDKIMSigner(keys, headers)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	signer.HeaderCanonicalization, signer.BodyCanonicalization, err = ParseDKIMCanonicalization(c.DKIM.Canonicalization)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	signer.Expiration = time.Duration(c.DKIM.Expiration)
+	return signer, nil
+}
+
+// Catcher는 설정으로 Catcher를 만듭니다 (설정이 없으면 nil: 보관하지 않음)
+func (c Config) Catcher() (*Catcher, error) {
+	if c.Catching == nil {
+		return nil, nil
+	}
+	if c.Catching.SQLitePath != "" {
+		store, err := OpenSQLiteCatcherStore(c.Catching.SQLitePath)
+		if err != nil {
+			return nil, fmt.Errorf("config: catcher: %w", err)
+		}
+		return NewCatcher(store), nil
+	}
+	limit := c.Catching.MemoryLimit
+	if limit <= 0 {
+		limit = 1000
+	}
+	return NewCatcher(&MemoryCatcherStore{Limit: limit}), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/mail"
+
+	"github.com/emersion/go-smtp"
+)
+
+// maxHeaderBytes는 DATA에서 헤더 부분으로 모으는 최대 크기입니다 (본문은 모으지 않음)
+const maxHeaderBytes = 1 << 20
+
+// malformedMessage는 파싱할 수 없는 메일에 대한 응답입니다
+func malformedMessage(err error) error {
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+		Message:      "Malformed message: " + err.Error(),
+	}
+}
+
+// messageReader는 DATA를 흘려보내면서 크기를 세고, 헤더 부분만 모아서 형식을 검사합니다.
+// 헤더가 잘못됐으면 Read가 malformed를 반환하므로 스풀에 다 쓰기 전에 멈춥니다.
+type messageReader struct {
+	r io.Reader
+	// 지금까지 읽은 바이트 수
+	size int64
+	// 헤더 검사가 끝나기 전까지 모은 데이터
+	header     []byte
+	headerDone bool
+	// 헤더 형식 오류 (550으로 응답)
+	malformed error
+	// r이 반환한 에러 (io.EOF 제외, 클라이언트 쪽 문제)
+	err error
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.size += int64(n)
+	if err != nil && err != io.EOF {
+		m.err = err
+	}
+	if m.headerDone {
+		return n, err
+	}
+
+	// 빈 줄이 청크 경계에 걸칠 수 있으므로 앞의 3바이트부터 찾음
+	from := max(len(m.header)-3, 0)
+	m.header = append(m.header, p[:n]...)
+	if end := headerEnd(m.header[from:]); end >= 0 {
+		m.header = m.header[:from+end]
+	} else if err == nil && len(m.header) <= maxHeaderBytes {
+		return n, err
+	}
+	m.headerDone = true
+
+	if len(m.header) > maxHeaderBytes {
+		m.malformed = malformedMessage(errHeaderTooLarge)
+	} else if _, parseErr := mail.ReadMessage(bytes.NewReader(m.header)); parseErr != nil {
+		m.malformed = malformedMessage(parseErr)
+	}
+	m.header = nil
+	if m.malformed != nil {
+		return n, m.malformed
+	}
+	return n, err
+}
+
+var errHeaderTooLarge = errors.New("header section too large")
+
+// headerEnd는 헤더를 끝내는 빈 줄 다음 위치입니다 (없으면 -1)
+func headerEnd(data []byte) int {
+	end := -1
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		end = i + 4
+	}
+	// 줄 끝이 LF뿐인 메일
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	return end
+}
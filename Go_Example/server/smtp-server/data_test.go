@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageReader(t *testing.T) {
+	t.Parallel()
+
+	// 헤더 끝의 빈 줄이 한 바이트씩 나눠 와도 찾음
+	body := &messageReader{r: iotest.OneByteReader(strings.NewReader(testMessage))}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, testMessage, string(data))
+	assert.Equal(t, int64(len(testMessage)), body.size)
+	assert.Nil(t, body.header)
+
+	// 헤더만 있고 본문이 없어도 됨
+	_, err = io.ReadAll(&messageReader{r: strings.NewReader("Subject: only headers\r\n")})
+	assert.NoError(t, err)
+
+	// 헤더가 잘못됐으면 본문을 읽기 전에 멈춤
+	body = &messageReader{r: strings.NewReader("not a header\r\n\r\n" + strings.Repeat("x", 1<<16))}
+	var out bytes.Buffer
+	_, err = io.Copy(&out, body)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Less(t, out.Len(), 1<<16)
+}
+
+func TestDataSizeLimitWithSpool(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{}
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}}
+	config.SpoolDir = t.TempDir()
+	config.MaxMessageBytes = 4096
+	app, err := NewApp(config, handler)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go app.Run(ctx)
+	addr := app.Listeners[0].Addr().String()
+
+	large := testMessage + strings.Repeat("0123456789abcdef\r\n", 1024)
+	err = sendMail(addr, "a@example.com", []string{"b@example.com"}, large)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 552, smtpErr.Code)
+	// 쓰던 스풀 파일은 지워짐
+	assert.Zero(t, countFiles(t, filepath.Join(config.SpoolDir, spoolTmp)))
+	assert.Zero(t, countFiles(t, filepath.Join(config.SpoolDir, spoolMsg)))
+
+	err = sendMail(addr, "a@example.com", []string{"b@example.com"}, "bad header\r\n\r\nbody\r\n")
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestChunkingBinaryMIME(t *testing.T) {
+	t.Parallel()
+
+	srv := NewTestServer(t, nil)
+	conn, err := net.Dial("tcp", srv.Addr)
+	require.NoError(t, err)
+	text := textproto.NewConn(conn)
+	defer text.Close()
+
+	cmd := func(expect int, format string, args ...any) string {
+		t.Helper()
+		id, err := text.Cmd(format, args...)
+		require.NoError(t, err)
+		text.StartResponse(id)
+		defer text.EndResponse(id)
+		_, msg, err := text.ReadResponse(expect)
+		require.NoError(t, err)
+		return msg
+	}
+	_, _, err = text.ReadResponse(220)
+	require.NoError(t, err)
+
+	caps := cmd(250, "EHLO client.example")
+	for _, ext := range []string{"8BITMIME", "CHUNKING", "BINARYMIME", "SMTPUTF8", "SIZE"} {
+		assert.Contains(t, caps, ext)
+	}
+
+	message := "Subject: binary\r\n\r\n\x00\x01 bare\nlf\r\n.\r\n"
+	cmd(250, "MAIL FROM:<a@example.com> BODY=BINARYMIME SMTPUTF8")
+	cmd(250, "RCPT TO:<b@example.com>")
+	// BDAT는 명령 뒤에 바로 데이터를 보내고 응답을 기다림
+	bdat := func(chunk, last string) {
+		t.Helper()
+		_, err := text.W.WriteString("BDAT " + strconv.Itoa(len(chunk)) + last + "\r\n" + chunk)
+		require.NoError(t, err)
+		require.NoError(t, text.W.Flush())
+		_, _, err = text.ReadResponse(250)
+		require.NoError(t, err)
+	}
+	bdat(message[:10], "")
+	bdat(message[10:], " LAST")
+
+	messages := srv.WaitMessages(t, 1, 5*time.Second)
+	// BDAT는 점 처리 없이 바이트 그대로
+	assert.True(t, strings.HasSuffix(string(messages[0].Raw), message))
+	assert.Equal(t, smtp.BodyBinaryMIME, messages[0].Envelope.Body)
+	assert.True(t, messages[0].Envelope.SMTPUTF8)
+}
+
+func TestRelayHonorsBodyType(t *testing.T) {
+	t.Parallel()
+
+	upstream := NewTestServer(t, nil)
+	relay := &Relay{
+		Routes:   []RelayRoute{{Domains: []string{"*"}, Host: upstream.Addr, TLS: RelayPlaintext}},
+		Hostname: "relay.test",
+		Timeout:  5 * time.Second,
+	}
+	send := func(envelope Envelope) error {
+		envelope.From = "a@example.com"
+		envelope.To = []string{"b@remote.example"}
+		return relay.deliver(context.Background(), &Message{Envelope: envelope, Raw: []byte(testMessage)})
+	}
+
+	require.NoError(t, send(Envelope{Body: smtp.Body8BitMIME, SMTPUTF8: true}))
+	received := upstream.WaitMessages(t, 1, 5*time.Second)[0].Envelope
+	assert.True(t, received.SMTPUTF8)
+	// 8비트 본문은 MAIL FROM에 BODY=8BITMIME으로 알림 (RFC 6152 3)
+	assert.Equal(t, smtp.Body8BitMIME, received.Body)
+
+	// BDAT로 보낼 수 없으므로 반송
+	err := send(Envelope{Body: smtp.BodyBinaryMIME})
+	require.Error(t, err)
+	assert.Equal(t, ErrRelayConversionRequired, recipientError(err, "b@remote.example"))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// DefaultDKIMHeaders는 HeaderKeys를 정하지 않았을 때 서명하는 헤더입니다 (RFC 6376 5.4.1)
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// DKIMKey는 보내는 도메인 하나의 서명 키입니다
+type DKIMKey struct {
+	Domain   string
+	Selector string
+	// *rsa.PrivateKey(rsa-sha256)나 ed25519.PrivateKey(ed25519-sha256)
+	Signer crypto.Signer
+}
+
+// LoadDKIMKey는 PEM 파일(PKCS#1 RSA나 PKCS#8 RSA/Ed25519)에서 키를 읽습니다
+func LoadDKIMKey(domain, selector, path string) (*DKIMKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("dkim: %s: no PEM block", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("dkim: %s: unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %s: %w", path, err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &DKIMKey{Domain: domain, Selector: selector, Signer: key}, nil
+	case ed25519.PrivateKey:
+		return &DKIMKey{Domain: domain, Selector: selector, Signer: key}, nil
+	default:
+		return nil, fmt.Errorf("dkim: %s: unsupported key type %T", path, key)
+	}
+}
+
+// DNSRecord는 <selector>._domainkey.<domain>에 올릴 TXT 레코드입니다
+func (k *DKIMKey) DNSRecord() (string, error) {
+	switch public := k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public), nil
+	default:
+		return "", fmt.Errorf("dkim: unsupported key type %T", public)
+	}
+}
+
+// ParseDKIMCanonicalization은 c= 태그 형식("relaxed/simple")을 읽습니다.
+// 비어 있으면 relaxed/relaxed, 본문 쪽을 생략하면 simple입니다 (RFC 6376 3.5).
+func ParseDKIMCanonicalization(value string) (header, body dkim.Canonicalization, err error) {
+	if value == "" {
+		return dkim.CanonicalizationRelaxed, dkim.CanonicalizationRelaxed, nil
+	}
+	h, b, ok := strings.Cut(value, "/")
+	if !ok {
+		b = string(dkim.CanonicalizationSimple)
+	}
+	for _, c := range []string{h, b} {
+		if c != string(dkim.CanonicalizationSimple) && c != string(dkim.CanonicalizationRelaxed) {
+			return "", "", fmt.Errorf("dkim: unknown canonicalization %q", value)
+		}
+	}
+	return dkim.Canonicalization(h), dkim.Canonicalization(b), nil
+}
+
+// DKIMSigner는 중계하거나 만들어 보내는 메일에 DKIM-Signature를 붙입니다.
+// 키는 헤더 From의 도메인으로 고르고, 없으면 봉투 MAIL FROM의 도메인으로 고릅니다
+// (다른 도메인의 메일을 전달할 때는 우리 반송 주소의 도메인으로 서명). 상위 도메인의 키도 씁니다.
+type DKIMSigner struct {
+	// 소문자 도메인별 키
+	Keys map[string]*DKIMKey
+	// 비어 있으면 simple (ParseDKIMCanonicalization 참고)
+	HeaderCanonicalization dkim.Canonicalization
+	BodyCanonicalization   dkim.Canonicalization
+	// 서명할 헤더 (nil이면 DefaultDKIMHeaders, From은 꼭 있어야 함)
+	HeaderKeys []string
+	// 0이 아니면 x= 태그로 서명 유효 기간을 넣음
+	Expiration time.Duration
+}
+
+var errDKIMNoFrom = errors.New("dkim: header list must include From")
+
+// NewDKIMSigner는 keys를 도메인별로 묶은 DKIMSigner를 만듭니다
+func NewDKIMSigner(keys []*DKIMKey, headerKeys []string) (*DKIMSigner, error) {
+	if headerKeys != nil && !containsFold(headerKeys, "From") {
+		return nil, errDKIMNoFrom
+	}
+	signer := &DKIMSigner{Keys: map[string]*DKIMKey{}, HeaderKeys: headerKeys}
+	for _, key := range keys {
+		signer.Keys[strings.ToLower(key.Domain)] = key
+	}
+	return signer, nil
+}
+
+// Sign은 raw 앞에 DKIM-Signature를 붙인 메일을 반환합니다. 쓸 키가 없으면 raw를 그대로 반환합니다.
+func (s *DKIMSigner) Sign(envelopeFrom string, raw []byte) ([]byte, error) {
+	key := s.key(headerFromDomain(raw))
+	if key == nil {
+		_, domain, _ := strings.Cut(envelopeFrom, "@")
+		key = s.key(domain)
+	}
+	if key == nil {
+		return raw, nil
+	}
+
+	headerKeys := s.HeaderKeys
+	if headerKeys == nil {
+		headerKeys = DefaultDKIMHeaders
+	}
+	options := &dkim.SignOptions{
+		Domain:                 key.Domain,
+		Selector:               key.Selector,
+		Signer:                 key.Signer,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: s.HeaderCanonicalization,
+		BodyCanonicalization:   s.BodyCanonicalization,
+		HeaderKeys:             headerKeys,
+	}
+	if s.Expiration > 0 {
+		options.Expiration = time.Now().Add(s.Expiration)
+	}
+
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, bytes.NewReader(raw), options); err != nil {
+		return nil, fmt.Errorf("dkim: %s: %w", key.Domain, err)
+	}
+	return signed.Bytes(), nil
+}
+
+// key는 domain이나 그 상위 도메인의 키입니다 (없으면 nil)
+func (s *DKIMSigner) key(domain string) *DKIMKey {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for domain != "" {
+		if key, ok := s.Keys[domain]; ok {
+			return key
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return nil
+}
+
+// headerFromDomain은 헤더 From 주소의 도메인입니다 (읽을 수 없으면 "")
+func headerFromDomain(raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return ""
+	}
+	_, domain, _ := strings.Cut(from.Address, "@")
+	return domain
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDKIMKeys는 RSA(PKCS#1)와 Ed25519(PKCS#8) 키 파일을 만듭니다
+func writeDKIMKeys(t *testing.T) (rsaFile, ed25519File string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaFile = filepath.Join(dir, "rsa.pem")
+	require.NoError(t, os.WriteFile(rsaFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), 0o600))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	ed25519File = filepath.Join(dir, "ed25519.pem")
+	require.NoError(t, os.WriteFile(ed25519File, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return rsaFile, ed25519File
+}
+
+// verifyDKIM은 keys의 DNSRecord를 DNS 대신 써서 서명을 확인합니다
+func verifyDKIM(t *testing.T, raw []byte, keys ...*DKIMKey) []*dkim.Verification {
+	t.Helper()
+
+	records := map[string]string{}
+	for _, key := range keys {
+		record, err := key.DNSRecord()
+		require.NoError(t, err)
+		records[key.Selector+"._domainkey."+key.Domain] = record
+	}
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if record, ok := records[domain]; ok {
+				return []string{record}, nil
+			}
+			return nil, fmt.Errorf("no record for %s", domain)
+		},
+	})
+	require.NoError(t, err)
+	return verifications
+}
+
+func TestDKIMSigner(t *testing.T) {
+	t.Parallel()
+
+	rsaFile, ed25519File := writeDKIMKeys(t)
+	rsaKey, err := LoadDKIMKey("example.com", "s1", rsaFile)
+	require.NoError(t, err)
+	edKey, err := LoadDKIMKey("bounces.example.net", "ed1", ed25519File)
+	require.NoError(t, err)
+	_, err = LoadDKIMKey("example.com", "s1", filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	message := "From: Support <support@mail.example.com>\r\n" +
+		"To: b@remote.example\r\n" +
+		"Subject:  Hello   there\r\n" +
+		"Date: Sat, 17 Oct 2026 09:00:00 +0000\r\n" +
+		"X-Unsigned: trace\r\n" +
+		"\r\n" +
+		"hello  world \r\n\r\n\r\n"
+
+	for _, canonicalization := range []string{"", "simple/simple", "relaxed/simple", "simple"} {
+		header, body, err := ParseDKIMCanonicalization(canonicalization)
+		require.NoError(t, err)
+		signer, err := NewDKIMSigner([]*DKIMKey{rsaKey, edKey}, nil)
+		require.NoError(t, err)
+		signer.HeaderCanonicalization, signer.BodyCanonicalization = header, body
+		signer.Expiration = time.Hour
+
+		// 헤더 From(mail.example.com)의 상위 도메인 키로 rsa-sha256
+		signed, err := signer.Sign("a@elsewhere.example", []byte(message))
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(signed), message), canonicalization)
+		assert.Contains(t, string(signed), "a=rsa-sha256")
+		assert.Contains(t, string(signed), "c="+string(header)+"/"+string(body))
+		verifications := verifyDKIM(t, signed, rsaKey)
+		require.Len(t, verifications, 1)
+		assert.NoError(t, verifications[0].Err, canonicalization)
+		assert.Equal(t, "example.com", verifications[0].Domain)
+		assert.NotContains(t, verifications[0].HeaderKeys, "X-Unsigned")
+		assert.False(t, verifications[0].Expiration.IsZero())
+	}
+
+	// 전달하는 외부 도메인 메일은 봉투 MAIL FROM의 도메인 키로 ed25519-sha256
+	forwarded := strings.Replace(message, "support@mail.example.com", "someone@gmail.example", 1)
+	signer, err := NewDKIMSigner([]*DKIMKey{rsaKey, edKey}, []string{"From", "Subject"})
+	require.NoError(t, err)
+	signed, err := signer.Sign("bounce+abc@bounces.example.net", []byte(forwarded))
+	require.NoError(t, err)
+	assert.Contains(t, string(signed), "a=ed25519-sha256")
+	verifications := verifyDKIM(t, signed, edKey)
+	require.Len(t, verifications, 1)
+	assert.NoError(t, verifications[0].Err)
+	assert.Equal(t, []string{"From", "Subject"}, verifications[0].HeaderKeys)
+
+	// 서명한 뒤 본문이 바뀌면 확인 실패
+	tampered := bytes.Replace(signed, []byte("hello"), []byte("HELLO"), 1)
+	assert.Error(t, verifyDKIM(t, tampered, edKey)[0].Err)
+
+	// 키가 없으면 그대로
+	unsigned, err := signer.Sign("a@other.example", []byte(forwarded))
+	require.NoError(t, err)
+	assert.Equal(t, forwarded, string(unsigned))
+
+	_, err = NewDKIMSigner(nil, []string{"Subject"})
+	assert.Error(t, err)
+	_, _, err = ParseDKIMCanonicalization("loose/simple")
+	assert.Error(t, err)
+}
+
+func TestRelayDKIM(t *testing.T) {
+	t.Parallel()
+
+	rsaFile, _ := writeDKIMKeys(t)
+	key, err := LoadDKIMKey("example.com", "s1", rsaFile)
+	require.NoError(t, err)
+	signer, err := NewDKIMSigner([]*DKIMKey{key}, nil)
+	require.NoError(t, err)
+	signer.HeaderCanonicalization, signer.BodyCanonicalization = dkim.CanonicalizationRelaxed, dkim.CanonicalizationRelaxed
+
+	upstream := NewTestServer(t, nil)
+	relay := newTestRelay(t, RelayRoute{Domains: []string{"*"}, Host: upstream.Addr, TLS: RelayPlaintext})
+	relay.DKIM = signer
+
+	require.NoError(t, relay.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"b@remote.example"}, User: "billing"},
+		Raw:      []byte(testMessage),
+	}))
+	received := upstream.WaitMessages(t, 1, 5*time.Second)[0]
+	// 업스트림이 붙인 Received 헤더는 서명 대상이 아니므로 그대로 확인됨
+	verifications := verifyDKIM(t, received.Raw, key)
+	require.Len(t, verifications, 1)
+	assert.NoError(t, verifications[0].Err)
+
+	// 인증하지 않은 클라이언트가 우리 도메인 From으로 보낸 메일은 서명하지 않음
+	require.NoError(t, relay.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"c@remote.example"}},
+		Raw:      []byte(testMessage),
+	}))
+	received = upstream.WaitMessages(t, 2, 5*time.Second)[1]
+	assert.NotContains(t, string(received.Raw), "DKIM-Signature")
+
+	// 우리가 만든 반송 메일은 서명 (From이 MAILER-DAEMON@<Hostname>)
+	relay.Hostname = "mx.example.com"
+	require.NoError(t, relay.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{To: []string{"d@remote.example"}, Generated: true},
+		Raw:      relay.DSN(Envelope{From: "d@remote.example"}, []byte(testMessage), []RecipientStatus{{Recipient: "x@example.com", State: RecipientFailed}}, time.Now()),
+	}))
+	received = upstream.WaitMessages(t, 3, 5*time.Second)[2]
+	verifications = verifyDKIM(t, received.Raw, key)
+	require.Len(t, verifications, 1)
+	assert.NoError(t, verifications[0].Err)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrGreylisted = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+	Message:      "Greylisted, please try again later",
+}
+
+// GreylistRecord는 (IP 대역, 발신자, 수신자) 하나의 그레이리스트 상태입니다
+type GreylistRecord struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// 재시도 후 메일을 받은 적이 있으면 true (이후로는 지연 없이 받음)
+	Passed bool
+	// 이 시각이 지나면 기록이 없는 것으로 취급
+	ExpiresAt time.Time
+}
+
+// GreylistStore는 그레이리스트 기록 저장소입니다
+type GreylistStore interface {
+	// Get은 key의 기록을 반환합니다 (없으면 ok가 false)
+	Get(ctx context.Context, key string) (record GreylistRecord, ok bool, err error)
+	Put(ctx context.Context, key string, record GreylistRecord) error
+	// DeleteExpired는 ExpiresAt이 now 이전인 기록을 지웁니다
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// Greylist는 처음 보는 (IP 대역, 발신자, 수신자)를 451로 거절하고,
+// Delay가 지난 뒤의 재시도는 받습니다. 재시도해서 메일을 받으면 화이트리스트에 올립니다.
+type Greylist struct {
+	Store GreylistStore
+	// 첫 시도 후 재시도를 받기까지의 최소 시간 (기본값 5분)
+	Delay time.Duration
+	// 첫 시도 후 이 시간 안에 재시도하지 않으면 처음부터 다시 (기본값 4시간)
+	RetryWindow time.Duration
+	// 화이트리스트에 오른 뒤 마지막 메일로부터 유지되는 시간 (기본값 36일)
+	Expiry time.Duration
+
+	// 테스트에서 시간을 바꾸기 위한 훅
+	now func() time.Time
+}
+
+// Check는 RCPT 단계에서 호출합니다. 받을 수 있으면 nil, 아니면 ErrGreylisted를 반환합니다.
+// 저장소 오류는 메일을 잃지 않도록 통과시킵니다.
+func (g *Greylist) Check(ctx context.Context, ip net.IP, from, to string) error {
+	key := greylistKey(ip, from, to)
+	now := g.clock()
+
+	record, ok, err := g.Store.Get(ctx, key)
+	if err != nil {
+		slog.Error("그레이리스트 조회 실패", "error", err)
+		return nil
+	}
+	if ok && now.Before(record.ExpiresAt) {
+		if record.Passed || now.Sub(record.FirstSeen) >= g.delay() {
+			return nil
+		}
+		return ErrGreylisted
+	}
+
+	// 처음 보거나 기록이 만료됨
+	record = GreylistRecord{
+		FirstSeen: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(orDefaultDuration(g.RetryWindow, 4*time.Hour)),
+	}
+	if err := g.Store.Put(ctx, key, record); err != nil {
+		slog.Error("그레이리스트 저장 실패", "error", err)
+		return nil
+	}
+	return ErrGreylisted
+}
+
+// Pass는 메일을 받은 뒤 호출해서 (IP 대역, 발신자, 수신자)를 화이트리스트에 올립니다
+func (g *Greylist) Pass(ctx context.Context, ip net.IP, from, to string) {
+	key := greylistKey(ip, from, to)
+	now := g.clock()
+
+	record, ok, err := g.Store.Get(ctx, key)
+	if err != nil {
+		slog.Error("그레이리스트 조회 실패", "error", err)
+		return
+	}
+	if !ok {
+		record.FirstSeen = now
+	}
+	record.LastSeen = now
+	record.Passed = true
+	record.ExpiresAt = now.Add(orDefaultDuration(g.Expiry, 36*24*time.Hour))
+	if err := g.Store.Put(ctx, key, record); err != nil {
+		slog.Error("그레이리스트 저장 실패", "error", err)
+	}
+}
+
+// Cleanup은 interval마다 만료된 기록을 지웁니다. ctx가 취소될 때까지 반환하지 않습니다.
+func (g *Greylist) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.Store.DeleteExpired(ctx, g.clock()); err != nil {
+				slog.Error("그레이리스트 정리 실패", "error", err)
+			}
+		}
+	}
+}
+
+func (g *Greylist) delay() time.Duration {
+	return orDefaultDuration(g.Delay, 5*time.Minute)
+}
+
+func (g *Greylist) clock() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+// greylistKey는 IPv4는 /24, IPv6는 /64 대역으로 묶어서 키를 만듭니다.
+// 여러 IP에서 재시도하는 대형 메일 서버도 통과할 수 있게 하기 위함입니다.
+func greylistKey(ip net.IP, from, to string) string {
+	network := "unknown"
+	if ip4 := ip.To4(); ip4 != nil {
+		network = ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	} else if ip != nil {
+		network = ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return network + " " + strings.ToLower(from) + " " + strings.ToLower(to)
+}
+
+func orDefaultDuration(value, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// MemoryGreylistStore는 프로세스 메모리에 기록을 둡니다 (재시작하면 사라짐, 단일 인스턴스용)
+type MemoryGreylistStore struct {
+	mu      sync.Mutex
+	records map[string]GreylistRecord
+}
+
+func (s *MemoryGreylistStore) Get(_ context.Context, key string) (GreylistRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	return record, ok, nil
+}
+
+func (s *MemoryGreylistStore) Put(_ context.Context, key string, record GreylistRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = map[string]GreylistRecord{}
+	}
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryGreylistStore) DeleteExpired(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// PostgresGreylistSchema는 PostgresGreylistStore가 쓰는 테이블입니다
+const PostgresGreylistSchema = `CREATE TABLE IF NOT EXISTS smtp_greylist (
+	key        text PRIMARY KEY,
+	first_seen timestamptz NOT NULL,
+	last_seen  timestamptz NOT NULL,
+	passed     boolean NOT NULL DEFAULT false,
+	expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS smtp_greylist_expires_at ON smtp_greylist (expires_at);`
+
+// PostgresGreylistStore는 Postgres 테이블에 기록을 둡니다 (여러 인스턴스가 공유)
+type PostgresGreylistStore struct {
+	DB *sql.DB
+}
+
+// Migrate는 PostgresGreylistSchema의 테이블이 없으면 만듭니다
+func (s *PostgresGreylistStore) Migrate(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, PostgresGreylistSchema)
+	return err
+}
+
+func (s *PostgresGreylistStore) Get(ctx context.Context, key string) (GreylistRecord, bool, error) {
+	var record GreylistRecord
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT first_seen, last_seen, passed, expires_at FROM smtp_greylist WHERE key = $1`, key,
+	).Scan(&record.FirstSeen, &record.LastSeen, &record.Passed, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return GreylistRecord{}, false, nil
+	}
+	if err != nil {
+		return GreylistRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+func (s *PostgresGreylistStore) Put(ctx context.Context, key string, record GreylistRecord) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO smtp_greylist (key, first_seen, last_seen, passed, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE SET
+			first_seen = EXCLUDED.first_seen,
+			last_seen = EXCLUDED.last_seen,
+			passed = EXCLUDED.passed,
+			expires_at = EXCLUDED.expires_at`,
+		key, record.FirstSeen, record.LastSeen, record.Passed, record.ExpiresAt)
+	return err
+}
+
+func (s *PostgresGreylistStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM smtp_greylist WHERE expires_at <= $1`, now)
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock은 테스트에서 직접 움직이는 시계입니다
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestGreylist(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := &MemoryGreylistStore{}
+	greylist := &Greylist{
+		Store:       store,
+		Delay:       5 * time.Minute,
+		RetryWindow: time.Hour,
+		Expiry:      24 * time.Hour,
+		now:         clock.Now,
+	}
+	ip := net.ParseIP("192.0.2.10")
+
+	// 처음 보면 거절, 지연 시간 전의 재시도도 거절
+	assert.Equal(t, ErrGreylisted, greylist.Check(ctx, ip, "a@example.com", "b@example.com"))
+	clock.Advance(time.Minute)
+	assert.Equal(t, ErrGreylisted, greylist.Check(ctx, ip, "a@example.com", "b@example.com"))
+
+	// 지연 시간이 지나면 같은 /24의 다른 IP에서 온 재시도도 받음
+	clock.Advance(5 * time.Minute)
+	assert.NoError(t, greylist.Check(ctx, net.ParseIP("192.0.2.99"), "A@example.com", "b@example.com"))
+	greylist.Pass(ctx, ip, "a@example.com", "b@example.com")
+
+	// 다른 수신자는 별개
+	assert.Equal(t, ErrGreylisted, greylist.Check(ctx, ip, "a@example.com", "c@example.com"))
+
+	// 화이트리스트는 RetryWindow가 지나도 유지되고, Expiry가 지나면 처음부터
+	clock.Advance(2 * time.Hour)
+	assert.NoError(t, greylist.Check(ctx, ip, "a@example.com", "b@example.com"))
+	clock.Advance(25 * time.Hour)
+	assert.Equal(t, ErrGreylisted, greylist.Check(ctx, ip, "a@example.com", "b@example.com"))
+
+	// RetryWindow 안에 재시도하지 않은 기록(c@)은 정리됨
+	require.NoError(t, store.DeleteExpired(ctx, clock.Now()))
+	_, ok, err := store.Get(ctx, greylistKey(ip, "a@example.com", "c@example.com"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = store.Get(ctx, greylistKey(ip, "a@example.com", "b@example.com"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestGreylistAtRcpt(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Now()}
+	handler := &recordingHandler{}
+	addr := startServerWithBackend(t, &Backend{
+		Handler:  handler,
+		Greylist: &Greylist{Store: &MemoryGreylistStore{}, now: clock.Now},
+	})
+
+	err := sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	assert.Equal(t, smtp.EnhancedCode{4, 7, 1}, smtpErr.EnhancedCode)
+
+	clock.Advance(10 * time.Minute)
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+
+	// 받은 뒤에는 지연 없이 통과
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+
+	_, delivered := handler.snapshot()
+	assert.Len(t, delivered, 2)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+)
+
+// Envelope는 SMTP 트랜잭션의 봉투 정보입니다
+type Envelope struct {
+	From     string
+	To       []string
+	RemoteIP net.IP
+	Helo     string
+	// MAIL FROM의 SPF 결과 (검사하지 않았으면 "")
+	SPF SPFResult
+	// 메일을 받은 세션의 ID (로그, Received 헤더와 같은 값)
+	SessionID string
+	// MAIL FROM의 BODY= 값 ("", 7BIT, 8BITMIME, BINARYMIME)과 SMTPUTF8 여부
+	Body     smtp.BodyType
+	SMTPUTF8 bool
+	// 서명된 회신/VERP 주소로 온 수신자의 토큰 내용 (Backend.SignedAddresses 참고)
+	Tokens []AddressToken
+	// AUTH로 인증한 사용자 (인증하지 않았으면 "")
+	User string
+	// 받은 메일이 아니라 이 서버가 만든 메일이면 true (반송 메일 등)
+	Generated bool
+}
+
+// Message는 DATA까지 받은 메일 한 통입니다
+type Message struct {
+	Envelope Envelope
+	// DATA로 받은 원본 메일 (RFC 5322)
+	Raw    []byte
+	Parsed parsers.ParsedEmail
+}
+
+// DedupKey는 같은 메일을 다시 처리하는지 알아보는 키입니다 (Message-ID, 봉투 발신자, 수신자의 SHA-256).
+// 핸들러가 451을 반환하면 클라이언트는 새 세션에서 같은 메일을 다시 보내므로 세션 ID는 넣지 않고,
+// Message-ID가 없으면 세션 ID로 대신하고 (스풀 재시도만 구분됨), 둘 다 없으면 ""입니다 (구분하지 않음).
+func (m *Message) DedupKey() string {
+	id := ""
+	if values := m.Parsed.Headers["Message-Id"]; len(values) > 0 {
+		id = strings.TrimSpace(values[0])
+	}
+	if id == "" && m.Envelope.SessionID != "" {
+		id = "session:" + m.Envelope.SessionID
+	}
+	if id == "" {
+		return ""
+	}
+	recipients := make([]string, len(m.Envelope.To))
+	for i, recipient := range m.Envelope.To {
+		recipients[i] = strings.ToLower(recipient)
+	}
+	sort.Strings(recipients)
+
+	h := sha256.New()
+	for _, value := range append([]string{id, strings.ToLower(m.Envelope.From)}, recipients...) {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MessageHandler는 수신한 메일을 처리합니다.
+// 반환한 에러는 DATA 응답으로 클라이언트에 전달됩니다 (TemporaryError, PermanentError 참고).
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, msg *Message) error
+}
+
+// MessageHandlerFunc는 일반 함수를 MessageHandler로 쓰기 위한 어댑터입니다
+type MessageHandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f MessageHandlerFunc) HandleMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Chain은 handlers를 순서대로 실행하는 MessageHandler를 만듭니다.
+// 하나라도 에러를 반환하면 뒤의 핸들러는 실행하지 않습니다.
+// 그러면 클라이언트나 스풀이 같은 메일을 다시 보내므로, 앞의 핸들러는 Message.DedupKey로 중복을 걸러야 합니다.
+// RecipientErrors로 일부 수신자만 실패하면 뒤의 핸들러는 성공한 수신자로만 실행하고, 실패한 수신자를 모아서 반환합니다.
+func Chain(handlers ...MessageHandler) MessageHandler {
+	return MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		failed := RecipientErrors{}
+		for _, handler := range handlers {
+			err := handler.HandleMessage(ctx, msg)
+			if err == nil {
+				continue
+			}
+			var errs RecipientErrors
+			if !errors.As(err, &errs) {
+				if len(failed) == 0 {
+					return err
+				}
+				// 앞에서 일부가 실패했으면 남은 수신자도 모두 이 에러로
+				for _, recipient := range msg.Envelope.To {
+					failed[recipient] = err
+				}
+				return failed
+			}
+
+			var remaining []string
+			for _, recipient := range msg.Envelope.To {
+				if recipientErr, ok := errs[recipient]; ok && recipientErr != nil {
+					failed[recipient] = recipientErr
+				} else {
+					remaining = append(remaining, recipient)
+				}
+			}
+			if len(remaining) == 0 {
+				return failed
+			}
+			narrowed := *msg
+			narrowed.Envelope.To = remaining
+			msg = &narrowed
+		}
+		if len(failed) > 0 {
+			return failed
+		}
+		return nil
+	})
+}
+
+// TemporaryError는 클라이언트가 나중에 재시도해야 하는 실패(451)입니다
+func TemporaryError(message string) error {
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      message,
+	}
+}
+
+// PermanentError는 재시도해도 소용없는 실패(550)입니다
+func PermanentError(message string) error {
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      message,
+	}
+}
+
+// RecipientErrors는 수신자마다 결과가 다를 때 핸들러가 반환하는 에러입니다 (실패한 수신자 → 에러).
+// 여기에 없는 수신자는 성공한 것으로 봅니다. 스풀은 실패한 수신자만 재시도하거나 반송합니다.
+type RecipientErrors map[string]error
+
+func (e RecipientErrors) Error() string {
+	recipients := make([]string, 0, len(e))
+	for recipient, err := range e {
+		recipients = append(recipients, recipient+": "+err.Error())
+	}
+	sort.Strings(recipients)
+	return strings.Join(recipients, "; ")
+}
+
+// recipientError는 handler가 반환한 err 중 recipient에 해당하는 에러입니다
+func recipientError(err error, recipient string) error {
+	var errs RecipientErrors
+	if errors.As(err, &errs) {
+		return errs[recipient]
+	}
+	return err
+}
+
+// isPermanent는 재시도해도 소용없는 에러(5xx SMTP 응답)인지 확인합니다. 그 밖의 에러는 일시적인 것으로 봅니다.
+func isPermanent(err error) bool {
+	var smtpErr *smtp.SMTPError
+	return errors.As(err, &smtpErr) && !smtpErr.Temporary()
+}
+
+// smtpError는 핸들러 에러를 SMTP 응답으로 바꿉니다.
+// *smtp.SMTPError가 아닌 에러는 내부 오류로 보고 451로 응답해서 재시도하게 합니다.
+func smtpError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr
+	}
+
+	slog.Error("메일 처리 실패", "error", err)
+	return TemporaryError("Requested action aborted: local error in processing")
+}
+
+// LogHandler는 수신한 메일을 로그로 남깁니다
+func LogHandler() MessageHandler {
+	return MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+		// 수신자 도메인 추출
+		domains := make([]string, 0, len(msg.Envelope.To))
+		for _, recipient := range msg.Envelope.To {
+			if idx := strings.Index(recipient, "@"); idx != -1 {
+				domains = append(domains, recipient[idx+1:])
+			}
+		}
+
+		slog.Info("메일 수신",
+			"session", msg.Envelope.SessionID,
+			"from", msg.Envelope.From,
+			"to", msg.Envelope.To,
+			"domains", domains,
+			"remote_ip", msg.Envelope.RemoteIP,
+			"helo", msg.Envelope.Helo,
+			"subject", msg.Parsed.Subject,
+			"size", len(msg.Raw),
+		)
+
+		return nil
+	})
+}
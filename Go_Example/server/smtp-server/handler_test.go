@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: a@example.com\r\nTo: b@example.com\r\nSubject: hello\r\n\r\nbody\r\n"
+
+// startServer는 handler로 메일을 처리하는 서버를 임의의 포트로 띄웁니다
+func startServer(t *testing.T, handler MessageHandler) string {
+	t.Helper()
+	return startServerWithBackend(t, &Backend{Handler: handler})
+}
+
+func startServerWithBackend(t *testing.T, backend *Backend) string {
+	t.Helper()
+	return NewTestServer(t, backend).Addr
+}
+
+// sendMail은 STARTTLS 없이 평문으로 메일을 보냅니다
+func sendMail(addr, from string, to []string, message string) error {
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.SendMail(from, to, strings.NewReader(message)); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func TestHandlerChain(t *testing.T) {
+	t.Parallel()
+
+	var got []*Message
+	var order []string
+	handler := Chain(
+		MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+			order = append(order, "first")
+			return nil
+		}),
+		MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+			order = append(order, "second")
+			got = append(got, msg)
+			return nil
+		}),
+	)
+	addr := startServer(t, handler)
+
+	err := sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage)
+	require.NoError(t, err)
+
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, "a@example.com", got[0].Envelope.From)
+	assert.Equal(t, []string{"b@example.com"}, got[0].Envelope.To)
+	assert.Equal(t, "localhost", got[0].Envelope.Helo)
+	assert.True(t, got[0].Envelope.RemoteIP.IsLoopback())
+	assert.Equal(t, "hello", got[0].Parsed.Subject)
+}
+
+func TestHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "temporary", err: TemporaryError("try later"), wantCode: 451},
+		{name: "permanent", err: PermanentError("no thanks"), wantCode: 550},
+		{name: "wrapped permanent", err: errors.Join(errors.New("db"), PermanentError("no thanks")), wantCode: 550},
+		{name: "internal error", err: errors.New("db is down"), wantCode: 451},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			handler := Chain(
+				MessageHandlerFunc(func(context.Context, *Message) error { return tt.err }),
+				MessageHandlerFunc(func(context.Context, *Message) error { calls++; return nil }),
+			)
+			addr := startServer(t, handler)
+
+			err := sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage)
+			var smtpErr *smtp.SMTPError
+			require.ErrorAs(t, err, &smtpErr)
+			assert.Equal(t, tt.wantCode, smtpErr.Code)
+			assert.Zero(t, calls)
+		})
+	}
+}
+
+func TestHandlerChainPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	full := &smtp.SMTPError{Code: 452, EnhancedCode: smtp.EnhancedCode{4, 2, 2}, Message: "Mailbox full"}
+	gone := PermanentError("No such mailbox")
+	var later []string
+	handler := Chain(
+		MessageHandlerFunc(func(context.Context, *Message) error {
+			return RecipientErrors{"full@example.com": full}
+		}),
+		MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+			later = append(later, msg.Envelope.To...)
+			return RecipientErrors{"gone@example.com": gone}
+		}),
+		MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+			later = append(later, msg.Envelope.To...)
+			return nil
+		}),
+	)
+
+	msg := &Message{Envelope: Envelope{From: "a@example.com", To: []string{"ok@example.com", "full@example.com", "gone@example.com"}}}
+	err := handler.HandleMessage(context.Background(), msg)
+
+	// 뒤의 핸들러는 앞에서 성공한 수신자로만 실행하고, 실패는 모아서 반환
+	assert.Equal(t, []string{"ok@example.com", "gone@example.com", "ok@example.com"}, later)
+	assert.Equal(t, RecipientErrors{"full@example.com": full, "gone@example.com": gone}, err)
+	assert.Nil(t, recipientError(err, "ok@example.com"))
+	// 호출한 쪽의 봉투는 그대로
+	assert.Len(t, msg.Envelope.To, 3)
+
+	// 일부가 실패한 뒤 메일 전체가 실패하면 남은 수신자도 실패
+	down := TemporaryError("webhook is down")
+	err = Chain(
+		MessageHandlerFunc(func(context.Context, *Message) error { return RecipientErrors{"full@example.com": full} }),
+		MessageHandlerFunc(func(context.Context, *Message) error { return down }),
+	).HandleMessage(context.Background(), msg)
+	assert.Equal(t, RecipientErrors{"full@example.com": full, "ok@example.com": down, "gone@example.com": down}, err)
+}
+
+func TestLMTPPerRecipientStatus(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var delivered []*Message
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}, {Addr: "127.0.0.1:0", LMTP: true}}
+	app, err := NewApp(config, MessageHandlerFunc(func(_ context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, msg)
+		return RecipientErrors{
+			"full@example.com": &smtp.SMTPError{Code: 452, EnhancedCode: smtp.EnhancedCode{4, 2, 2}, Message: "Mailbox full"},
+			"gone@example.com": PermanentError("No such mailbox"),
+		}
+	}))
+	require.NoError(t, err)
+	require.Len(t, app.LMTPListeners, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go app.Run(ctx)
+
+	conn, err := net.Dial("tcp", app.LMTPListeners[0].Addr().String())
+	require.NoError(t, err)
+	client := smtp.NewClientLMTP(conn)
+	defer client.Close()
+	require.NoError(t, client.Hello("mta.example.com"))
+	require.NoError(t, client.Mail("a@example.com", nil))
+	for _, rcpt := range []string{"ok@example.com", "full@example.com", "gone@example.com"} {
+		require.NoError(t, client.Rcpt(rcpt, nil))
+	}
+	data, err := client.Data()
+	require.NoError(t, err)
+	_, err = data.Write([]byte(testMessage))
+	require.NoError(t, err)
+
+	// 한 수신자의 실패가 메일 전체를 거절하지 않음
+	responses, err := data.CloseWithLMTPResponse()
+	var lmtpErr smtp.LMTPDataError
+	require.ErrorAs(t, err, &lmtpErr)
+	assert.Len(t, lmtpErr, 2)
+	assert.Equal(t, 452, lmtpErr["full@example.com"].Code)
+	assert.Equal(t, 550, lmtpErr["gone@example.com"].Code)
+	require.Contains(t, responses, "ok@example.com")
+	require.NoError(t, client.Quit())
+
+	mu.Lock()
+	require.Len(t, delivered, 1)
+	assert.Contains(t, string(delivered[0].Raw), "with LMTP id ")
+	mu.Unlock()
+
+	// SMTP 리스너는 그대로 SMTP (수신자별 실패는 메일 전체를 451로)
+	err = sendMail(app.Listeners[0].Addr().String(), "a@example.com", []string{"ok@example.com", "full@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+}
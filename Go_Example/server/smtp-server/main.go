@@ -1,94 +1,487 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/emersion/go-smtp"
+	"github.com/looko-corp/acloset-api/pkg/parsers"
 )
 
 // Backend는 SMTP 서버의 백엔드를 구현합니다
-type Backend struct{}
+type Backend struct {
+	// 수신한 메일을 처리할 핸들러 (여러 개는 Chain으로 묶음)
+	Handler MessageHandler
+	// 받을 수신자 정책 (nil이면 모든 수신자를 받음)
+	Recipients *RecipientPolicy
+	// SMTP AUTH 자격 증명 저장소 (nil이면 AUTH를 지원하지 않음)
+	Credentials CredentialStore
+	// 평문 연결에서의 AUTH/MAIL 허용 여부
+	TLSPolicy TLSPolicy
+	// 설정하면 메일을 스풀에 저장한 뒤 250으로 응답하고, Handler 대신 스풀 워커가 전달함
+	Spool *Spool
+	// MAIL FROM의 SPF 검사 정책 (nil이면 검사하지 않음)
+	SPF *SPFPolicy
+	// 설정하면 처음 보는 (IP 대역, 발신자, 수신자)를 RCPT에서 451로 거절
+	Greylist *Greylist
+	// 사용자, IP, 발신 도메인별 전송량과 사용자별 연결 수 제한 (nil이면 제한 없음).
+	// IP별 연결 수와 차단 목록은 리스너를 ConnLimitListener로 감싸서 확인함
+	RateLimit *RateLimiter
+	// Prometheus 지표 (nil이면 기록하지 않음)
+	Metrics *Metrics
+	// 설정하면 다른 검사를 통과한 MAIL/RCPT마다 호출해서, 에러를 반환하면 그 에러로 거절 (TestServer의 장애 주입 등)
+	CheckMail func(from string) error
+	CheckRcpt func(from, to string) error
+	// 설정하면 DATA를 받은 뒤 응답하기 전에 스팸 점수를 매겨서 태그하거나 거절 (인증된 사용자는 제외)
+	Spam *SpamFilter
+	// 설정하면 DATA를 받은 뒤 응답하기 전에 (스풀에 넣기 전에) 바이러스를 검사해서 거절하거나 격리 (Next는 쓰지 않음)
+	VirusScanner *VirusScanner
+	// 설정하면 RCPT에서 서명된 회신/VERP 주소를 확인해서, 위조되거나 만료된 주소는 거절하고
+	// 맞는 주소는 수신자 정책 없이 받은 뒤 내용을 Envelope.Tokens로 넘김
+	SignedAddresses *SignedAddresses
+
+	// 종료 중이면 새 트랜잭션을 받지 않음
+	draining atomic.Bool
+
+	mu       sync.Mutex
+	sessions map[*Session]struct{}
+}
 
 // NewSession은 새로운 SMTP 세션을 생성합니다
-func (bkd *Backend) NewSession(_ *smtp.Conn) (smtp.Session, error) {
-	return &Session{}, nil
+func (bkd *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	if bkd.draining.Load() {
+		bkd.Metrics.messageRejected(reasonShuttingDown)
+		return nil, ErrShuttingDown
+	}
+
+	// IP별 동시 연결 수는 HELO 전부터 세야 하므로 ConnLimitListener에서 확인함
+	ip := remoteIP(c.Conn().RemoteAddr())
+
+	// STARTTLS 이후에는 세션이 새로 만들어지므로 여기서 TLS 여부를 확인하면 됨
+	_, isTLS := c.TLSConnectionState()
+	id := newSessionID()
+	session := &Session{
+		backend:  bkd,
+		conn:     c,
+		id:       id,
+		logger:   slog.Default().With("session", id, "remote_ip", ip),
+		remoteIP: ip,
+		helo:     c.Hostname(),
+		tls:      isTLS,
+	}
+	session.logger.Info("세션 시작", "helo", session.helo, "tls", isTLS)
+	bkd.Metrics.sessionOpened()
+
+	bkd.mu.Lock()
+	if bkd.sessions == nil {
+		bkd.sessions = map[*Session]struct{}{}
+	}
+	bkd.sessions[session] = struct{}{}
+	bkd.mu.Unlock()
+
+	return session, nil
+}
+
+// closeSessions는 남아 있는 모든 연결을 끊습니다 (graceful shutdown 시간 초과 시)
+func (bkd *Backend) closeSessions() {
+	bkd.mu.Lock()
+	conns := make([]*smtp.Conn, 0, len(bkd.sessions))
+	for session := range bkd.sessions {
+		conns = append(conns, session.conn)
+	}
+	bkd.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
 }
 
 // Session은 SMTP 세션을 나타냅니다
 type Session struct {
 	From string
 	To   []string
+
+	backend *Backend
+	conn    *smtp.Conn
+	// 로그와 Received 헤더에 남기는 세션 ID
+	id       string
+	logger   *slog.Logger
+	remoteIP net.IP
+	helo     string
+	tls      bool
+	// AUTH로 인증된 사용자 (인증하지 않았으면 nil)
+	user *User
+	// 현재 트랜잭션의 SPF 결과와, tag 정책일 때 메일 앞에 붙일 Received-SPF 헤더
+	spf       SPFResult
+	spfHeader string
+	// 현재 트랜잭션의 MAIL FROM 파라미터 (BODY=, SMTPUTF8)
+	body smtp.BodyType
+	utf8 bool
+	// 현재 트랜잭션에서 확인한 서명된 주소들
+	tokens []AddressToken
 }
 
 // Mail은 메일 발신자를 설정합니다
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
-	log.Printf("메일 발신자: %s\n", from)
+	s.logger.Info("메일 발신자", "from", from)
+	if s.backend.draining.Load() {
+		return s.reject(reasonShuttingDown, ErrShuttingDown)
+	}
+	if s.backend.TLSPolicy.RequireForMail && !s.tls {
+		return s.reject(reasonTLSRequired, ErrTLSRequired)
+	}
+	if s.user != nil && !s.user.CanSendAs(from) {
+		return s.reject(reasonSenderNotAllowed, ErrSenderNotAllowed, "from", from, "user", s.user.Username)
+	}
+	if s.backend.RateLimit != nil {
+		if err := s.backend.RateLimit.Message(s.remoteIP, s.username(), from); err != nil {
+			return s.reject(reasonRateLimit, err, "from", from)
+		}
+	}
+	// 인증된 사용자는 SPF를 검사하지 않음 (submission)
+	if policy := s.backend.SPF; policy != nil && s.user == nil {
+		outcome, action := policy.Check(s.remoteIP, s.helo, from)
+		s.logger.Info("SPF", "result", outcome.Result, "domain", outcome.Domain, "action", action)
+		switch action {
+		case SPFReject:
+			return s.reject(reasonSPF, spfRejection(outcome), "from", from)
+		case SPFTag:
+			s.spfHeader = outcome.Header(s.conn.Server().Domain)
+		}
+		s.spf = outcome.Result
+	}
+	// BODY, SMTPUTF8은 go-smtp가 광고한 확장인지 확인함. 외부로 중계할 때 그대로 전달하기 위해 봉투에 남김
+	s.body = opts.Body
+	s.utf8 = opts.UTF8
+	if check := s.backend.CheckMail; check != nil {
+		if err := check(from); err != nil {
+			return s.reject(reasonCheck, smtpError(err), "from", from)
+		}
+	}
 	s.From = from
 	return nil
 }
 
 // Rcpt는 메일 수신자를 추가합니다
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
-	log.Printf("메일 수신자: %s\n", to)
+	s.logger.Info("메일 수신자", "to", to)
+	var token *AddressToken
+	if addresses := s.backend.SignedAddresses; addresses != nil {
+		var err error
+		token, err = addresses.Parse(to)
+		if err != nil {
+			return s.reject(reasonRecipient, err, "to", to)
+		}
+	}
+	// 인증된 사용자는 외부 도메인으로도 보낼 수 있음 (submission), 서명을 확인한 주소는 우리 주소
+	if policy := s.backend.Recipients; policy != nil && s.user == nil && token == nil {
+		if err := policy.Check(context.Background(), to); err != nil {
+			return s.reject(reasonRecipient, err, "to", to)
+		}
+	}
+	if greylist := s.backend.Greylist; greylist != nil && s.user == nil {
+		if err := greylist.Check(context.Background(), s.remoteIP, s.From, to); err != nil {
+			return s.reject(reasonGreylist, err, "from", s.From, "to", to)
+		}
+	}
+	if s.backend.RateLimit != nil {
+		if err := s.backend.RateLimit.Recipient(s.remoteIP, s.username(), s.From); err != nil {
+			return s.reject(reasonRateLimit, err, "from", s.From, "to", to)
+		}
+	}
+	if check := s.backend.CheckRcpt; check != nil {
+		if err := check(s.From, to); err != nil {
+			return s.reject(reasonCheck, smtpError(err), "from", s.From, "to", to)
+		}
+	}
+	if token != nil {
+		s.tokens = append(s.tokens, *token)
+	}
 	s.To = append(s.To, to)
 	return nil
 }
 
-// Data는 메일 본문을 읽어들입니다
+// Data는 메일 본문을 읽어들여 핸들러에 넘깁니다
 func (s *Session) Data(r io.Reader) error {
-	body, err := io.ReadAll(r)
-	if err != nil {
-		return err
+	return s.data(r, nil)
+}
+
+// LMTPData는 LMTP의 DATA입니다 (RFC 2033).
+// 핸들러가 RecipientErrors를 반환하면 실패한 수신자만 거절하고 나머지는 250으로 응답합니다.
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	return s.data(r, status)
+}
+
+// data는 DATA를 처리합니다. status가 있으면 (LMTP) 수신자별 결과를 거기에 씁니다.
+func (s *Session) data(r io.Reader, status smtp.StatusCollector) error {
+	// Received-SPF는 우리가 붙이는 Received보다 위에 둠 (RFC 7208 9.1)
+	body := &messageReader{r: io.MultiReader(strings.NewReader(s.spfHeader+s.receivedHeader()), r)}
+
+	spam := s.backend.Spam
+	if s.user != nil {
+		// 인증된 사용자는 검사하지 않음 (submission)
+		spam = nil
 	}
 
-	log.Printf("=== 메일 수신 ===\n")
-	log.Printf("발신자: %s\n", s.From)
-	log.Printf("수신자: %v\n", s.To)
+	scanner := s.backend.VirusScanner
 
-	// 수신자 도메인 추출
-	for _, recipient := range s.To {
-		if idx := strings.Index(recipient, "@"); idx != -1 {
-			domain := recipient[idx+1:]
-			log.Printf("수신 도메인: %s (전체: %s)\n", domain, recipient)
+	start := time.Now()
+	switch {
+	case s.backend.Spool != nil && spam == nil && scanner == nil:
+		// 메모리에 모으지 않고 스풀 파일로 바로 씀 (본문 파싱은 스풀 워커가 전달할 때)
+		id, err := s.backend.Spool.EnqueueReader(s.envelope(), body)
+		s.backend.Metrics.handlerObserved(time.Since(start))
+		if err != nil {
+			return s.dataError(body, err)
+		}
+		s.logger.Info("스풀 저장", "spool_id", id)
+	case s.backend.Spool == nil && s.backend.Handler == nil && spam == nil && scanner == nil:
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return s.dataError(body, err)
+		}
+	default:
+		// 핸들러와 스팸, 바이러스 검사에는 원본 전체가 필요하므로 모음 (MaxMessageBytes를 넘으면 그 전에 552)
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return s.dataError(body, err)
+		}
+		parsed, err := parsers.ParseEmail(string(raw))
+		if err != nil {
+			return s.reject(reasonMalformed, malformedMessage(err))
+		}
+		msg := &Message{
+			Envelope: s.envelope(),
+			Raw:      raw,
+			Parsed:   parsed,
+		}
+		if spam != nil {
+			if err := s.checkSpam(spam, msg); err != nil {
+				return err
+			}
+		}
+		quarantined := false
+		if scanner != nil {
+			quarantined, err = scanner.Check(context.Background(), msg)
+			if err != nil {
+				return s.reject(reasonVirus, err)
+			}
+		}
+
+		switch {
+		case quarantined:
+			// 격리한 메일은 받은 것처럼 응답하고 전달하지 않음
+		case s.backend.Spool != nil:
+			id, err := s.backend.Spool.Enqueue(msg.Envelope, msg.Raw)
+			s.backend.Metrics.handlerObserved(time.Since(start))
+			if err != nil {
+				return s.dataError(body, err)
+			}
+			s.logger.Info("스풀 저장", "spool_id", id)
+		case s.backend.Handler != nil:
+			err = s.backend.Handler.HandleMessage(context.Background(), msg)
+			s.backend.Metrics.handlerObserved(time.Since(start))
+			var errs RecipientErrors
+			if status != nil && errors.As(err, &errs) {
+				return s.recipientStatus(status, errs, len(raw))
+			}
+			if err != nil {
+				return s.reject(reasonHandler, smtpError(err))
+			}
+		}
+	}
+
+	s.backend.Metrics.messageAccepted(int(body.size))
+	s.logger.Info("메일 수신 완료", "from", s.From, "to", s.To, "size", body.size)
+	s.passGreylist()
+	return nil
+}
+
+// checkSpam은 msg의 스팸 점수를 매겨서, 거절이면 에러를 반환하고 태그면 X-Spam-* 헤더를 붙입니다
+func (s *Session) checkSpam(filter *SpamFilter, msg *Message) error {
+	verdict := filter.Check(context.Background(), msg)
+	s.logger.Info("스팸 검사", "score", verdict.Score, "rules", verdict.Rules, "action", verdict.Action)
+	switch verdict.Action {
+	case SpamReject:
+		return s.reject(reasonSpam, ErrSpamRejected, "score", verdict.Score)
+	case SpamTag:
+		header := verdict.Header()
+		msg.Raw = append([]byte(header), msg.Raw...)
+		for _, line := range strings.Split(strings.TrimSuffix(header, "\r\n"), "\r\n") {
+			name, value, _ := strings.Cut(line, ": ")
+			msg.Parsed.Headers[name] = append(msg.Parsed.Headers[name], value)
 		}
 	}
+	return nil
+}
 
-	log.Printf("본문:\n%s\n", string(body))
-	log.Printf("================\n")
+// dataError는 본문을 읽거나 스풀에 쓰다가 난 에러를 DATA 응답으로 바꿉니다
+func (s *Session) dataError(body *messageReader, err error) error {
+	switch {
+	case body.malformed != nil:
+		return s.reject(reasonMalformed, body.malformed)
+	case errors.Is(err, smtp.ErrDataTooLarge):
+		return s.reject(reasonSize, smtp.ErrDataTooLarge, "size", body.size)
+	case body.err != nil:
+		// 클라이언트 쪽 문제 (연결 끊김, BDAT 도중 RSET 등)
+		return err
+	}
+	s.logger.Error("스풀 저장 실패", "error", err)
+	return s.reject(reasonSpool, TemporaryError("Requested action aborted: local error in processing"))
+}
 
+// recipientStatus는 LMTP에서 수신자마다 결과를 응답합니다 (errs에 없는 수신자는 성공)
+func (s *Session) recipientStatus(status smtp.StatusCollector, errs RecipientErrors, size int) error {
+	var accepted []string
+	for _, to := range s.To {
+		err := errs[to]
+		if err != nil {
+			err = s.reject(reasonHandler, smtpError(err), "to", to)
+		} else {
+			accepted = append(accepted, to)
+		}
+		status.SetStatus(to, err)
+	}
+	if len(accepted) > 0 {
+		s.backend.Metrics.messageAccepted(size)
+		s.logger.Info("메일 수신 완료", "from", s.From, "to", accepted, "size", size)
+		s.passGreylist()
+	}
 	return nil
 }
 
+// reject는 거절 사유를 지표와 로그에 남기고 err를 그대로 반환합니다
+func (s *Session) reject(reason string, err error, args ...any) error {
+	s.backend.Metrics.messageRejected(reason)
+	s.logger.Info("거절", append([]any{"reason", reason, "error", err}, args...)...)
+	return err
+}
+
+// receivedHeader는 메일 맨 앞에 붙이는 Received 헤더입니다 (RFC 5321 4.4, 프로토콜 이름은 RFC 3848)
+func (s *Session) receivedHeader() string {
+	protocol := "ESMTP"
+	if s.conn.Server().LMTP {
+		protocol = "LMTP"
+	}
+	if s.tls {
+		protocol += "S"
+	}
+	if s.user != nil {
+		protocol += "A"
+	}
+	recipient := ""
+	if len(s.To) == 1 {
+		recipient = "\r\n\tfor <" + s.To[0] + ">"
+	}
+	return fmt.Sprintf("Received: from %s ([%s])\r\n\tby %s with %s id %s%s;\r\n\t%s\r\n",
+		s.helo, s.remoteIP, s.conn.Server().Domain, protocol, s.id, recipient, time.Now().Format(time.RFC1123Z))
+}
+
+// passGreylist는 메일을 받은 뒤 이 트랜잭션의 수신자들을 그레이리스트에서 통과시킵니다
+func (s *Session) passGreylist() {
+	if s.backend.Greylist == nil || s.user != nil {
+		return
+	}
+	for _, to := range s.To {
+		s.backend.Greylist.Pass(context.Background(), s.remoteIP, s.From, to)
+	}
+}
+
+// envelope는 현재 트랜잭션의 봉투 정보를 반환합니다
+func (s *Session) envelope() Envelope {
+	return Envelope{
+		From:      s.From,
+		To:        append([]string(nil), s.To...),
+		RemoteIP:  s.remoteIP,
+		Helo:      s.helo,
+		SPF:       s.spf,
+		SessionID: s.id,
+		Body:      s.body,
+		SMTPUTF8:  s.utf8,
+		Tokens:    append([]AddressToken(nil), s.tokens...),
+		User:      s.username(),
+	}
+}
+
 // Reset은 세션을 초기화합니다
 func (s *Session) Reset() {
 	s.From = ""
 	s.To = nil
+	s.tokens = nil
+	s.spf = ""
+	s.spfHeader = ""
+	s.body = ""
+	s.utf8 = false
+}
+
+// username은 인증된 사용자 이름입니다 (인증하지 않았으면 "")
+func (s *Session) username() string {
+	if s.user == nil {
+		return ""
+	}
+	return s.user.Username
 }
 
 // Logout은 세션을 종료합니다
 func (s *Session) Logout() error {
+	s.backend.mu.Lock()
+	delete(s.backend.sessions, s)
+	s.backend.mu.Unlock()
+	s.backend.Metrics.sessionClosed()
+	s.logger.Info("세션 종료")
+
+	if s.backend.RateLimit != nil && s.user != nil {
+		s.backend.RateLimit.Logout(s.remoteIP, s.user.Username)
+	}
 	return nil
 }
 
 func main() {
-	backend := &Backend{}
+	configPath := flag.String("config", "", "설정 파일 경로 (JSON, SMTP_* 환경 변수가 우선)")
+	flag.Parse()
 
-	server := smtp.NewServer(backend)
-	server.Addr = ":2525" // SMTP 포트 (25번 대신 2525 사용)
-	server.Domain = "localhost"
-	server.ReadTimeout = 10 * time.Second
-	server.WriteTimeout = 10 * time.Second
-	server.MaxMessageBytes = 1024 * 1024 // 1MB
-	server.MaxRecipients = 50
-	server.AllowInsecureAuth = true // 테스트용이므로 비보안 인증 허용
+	// 세션 ID 등으로 검색할 수 있도록 JSON 로그
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
 
-	log.Printf("SMTP 서버 시작: %s\n", server.Addr)
-	log.Printf("도메인: %s\n", server.Domain)
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	app, err := NewApp(config, LogHandler())
+	if err != nil {
+		fatal(err)
+	}
+
+	// SIGTERM(쿠버네티스 롤링 배포)이나 Ctrl+C를 받으면 graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	if err := app.Run(ctx); err != nil {
+		fatal(err)
 	}
 }
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
+// remoteIP는 접속한 클라이언트의 IP를 반환합니다 (TCP가 아니면 nil)
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 거절 사유 (smtp_messages_rejected_total의 reason 라벨)
+const (
+	reasonShuttingDown     = "shutting_down"
+	reasonAccessDenied     = "access_denied"
+	reasonTLSRequired      = "tls_required"
+	reasonSenderNotAllowed = "sender_not_allowed"
+	reasonRateLimit        = "rate_limit"
+	reasonSPF              = "spf"
+	reasonRecipient        = "recipient"
+	reasonGreylist         = "greylist"
+	reasonMalformed        = "malformed"
+	reasonSize             = "size"
+	reasonSpool            = "spool"
+	reasonHandler          = "handler"
+	reasonSpam             = "spam"
+	reasonVirus            = "virus"
+	reasonCheck            = "check"
+)
+
+// Metrics는 SMTP 서버의 Prometheus 지표입니다. nil이면 아무것도 기록하지 않습니다.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	connections     prometheus.Counter
+	activeSessions  prometheus.Gauge
+	accepted        prometheus.Counter
+	rejected        *prometheus.CounterVec
+	messageSize     prometheus.Histogram
+	handlerDuration prometheus.Histogram
+}
+
+// NewMetrics는 새 레지스트리에 지표를 등록합니다 (Go 런타임, 프로세스 지표 포함)
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "smtp_connections_total",
+			Help: "SMTP sessions opened (after HELO/EHLO).",
+		}),
+		activeSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "smtp_active_sessions",
+			Help: "SMTP sessions currently open.",
+		}),
+		accepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "smtp_messages_accepted_total",
+			Help: "Messages accepted with 250 after DATA.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smtp_messages_rejected_total",
+			Help: "Connections, commands and messages rejected, by reason.",
+		}, []string{"reason"}),
+		messageSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "smtp_message_size_bytes",
+			Help:    "Size of messages received with DATA.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 9), // 1KB ~ 64MB
+		}),
+		handlerDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "smtp_handler_duration_seconds",
+			Help:    "Time spent delivering a message to the handler or the spool.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connections, m.activeSessions, m.accepted, m.rejected, m.messageSize, m.handlerDuration,
+	)
+	return m
+}
+
+// Handler는 /metrics에 연결할 HTTP 핸들러입니다
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) sessionOpened() {
+	if m == nil {
+		return
+	}
+	m.connections.Inc()
+	m.activeSessions.Inc()
+}
+
+func (m *Metrics) sessionClosed() {
+	if m == nil {
+		return
+	}
+	m.activeSessions.Dec()
+}
+
+func (m *Metrics) messageAccepted(size int) {
+	if m == nil {
+		return
+	}
+	m.accepted.Inc()
+	m.messageSize.Observe(float64(size))
+}
+
+func (m *Metrics) messageRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) handlerObserved(elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.handlerDuration.Observe(elapsed.Seconds())
+}
+
+// newSessionID는 로그와 Received 헤더에 쓰는 세션 ID를 만듭니다
+func newSessionID() string {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(random)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceivedHeader(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{}
+	addr := startServer(t, handler)
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+
+	_, delivered := handler.snapshot()
+	require.Len(t, delivered, 1)
+	msg := delivered[0]
+
+	require.NotEmpty(t, msg.Envelope.SessionID)
+	raw := string(msg.Raw)
+	assert.True(t, strings.HasPrefix(raw, "Received: from localhost ([127.0.0.1])\r\n\tby localhost with ESMTP id "+msg.Envelope.SessionID+"\r\n\tfor <b@example.com>;\r\n\t"), raw)
+	assert.True(t, strings.HasSuffix(raw, testMessage))
+	assert.Equal(t, "hello", msg.Parsed.Subject)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{{Addr: "127.0.0.1:0"}}
+	config.MetricsAddr = "127.0.0.1:0"
+	config.Recipients = &RecipientConfig{Domains: []string{"example.com"}, Mailboxes: []string{"b@example.com"}}
+
+	app, err := NewApp(config, &recordingHandler{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go app.Run(ctx)
+
+	addr := app.Listeners[0].Addr().String()
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+	assert.Error(t, sendMail(addr, "a@example.com", []string{"nobody@example.com"}, testMessage))
+
+	resp, err := http.Get("http://" + app.MetricsListener.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	metrics := string(body)
+	assert.Contains(t, metrics, "smtp_connections_total 2")
+	assert.Contains(t, metrics, "smtp_messages_accepted_total 1")
+	assert.Contains(t, metrics, `smtp_messages_rejected_total{reason="recipient"} 1`)
+	assert.Contains(t, metrics, "smtp_message_size_bytes_count 1")
+	assert.Contains(t, metrics, "smtp_handler_duration_seconds_count 1")
+}
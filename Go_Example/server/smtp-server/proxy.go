@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature는 PROXY protocol v2 헤더의 시작입니다
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength는 v1 헤더 한 줄의 최대 길이입니다 (CRLF 포함)
+const proxyV1MaxLength = 107
+
+var errProxyHeader = errors.New("proxy: invalid PROXY protocol header")
+
+// ProxyListener는 로드 밸런서(HAProxy, NLB 등)가 붙이는 PROXY protocol v1/v2 헤더를 읽어서
+// 연결의 RemoteAddr을 실제 클라이언트 주소로 바꾸는 리스너입니다.
+// Trusted에 있는 주소에서 온 연결만 헤더를 읽고 (헤더가 없거나 잘못됐으면 연결을 끊음),
+// 나머지는 그대로 둡니다 (아무나 주소를 속이지 못하도록).
+// implicit TLS 리스너에서는 헤더가 TLS 앞에 오므로 이 리스너를 tls.NewListener 안쪽에 둬야 합니다.
+type ProxyListener struct {
+	net.Listener
+	Trusted []*net.IPNet
+	// 헤더를 기다리는 최대 시간 (0이면 5초)
+	Timeout time.Duration
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !containsIP(l.Trusted, remoteIP(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	// 헤더는 세션을 시작할 때(RemoteAddr을 처음 부를 때) 연결의 고루틴에서 읽음 (Accept 루프를 막지 않도록)
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// proxyConn은 PROXY 헤더를 읽은 뒤의 연결입니다
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once sync.Once
+	// 헤더의 실제 클라이언트 주소 (LOCAL이나 UNKNOWN이면 nil: 연결 주소 그대로)
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			slog.Warn("PROXY 헤더 오류, 연결 끊음", "upstream", c.Conn.RemoteAddr(), "error", c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) Write(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(p)
+}
+
+// readProxyHeader는 v1이나 v2 헤더를 읽고 클라이언트 주소를 반환합니다 (LOCAL, UNKNOWN, TCP가 아니면 nil)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if err != nil && !bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1은 "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"을 읽습니다
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errProxyHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2는 바이너리 헤더를 읽습니다 (TLV는 건너뜀)
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	command, family := header[12]&0x0f, header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL: 로드 밸런서 자신의 연결 (헬스 체크)
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+	switch family {
+	case 0x11: // TCP over IPv4: src(4) dst(4) sport(2) dport(2)
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6: src(16) dst(16) sport(2) dport(2)
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		// UDP, 유닉스 소켓, UNSPEC은 연결 주소 그대로
+		return nil, nil
+	}
+}
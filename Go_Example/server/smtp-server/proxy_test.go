@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyV2Header는 TCP 연결의 v2 헤더를 만듭니다 (command 0이면 LOCAL)
+func proxyV2Header(command byte, src, dst *net.TCPAddr) string {
+	family, length := byte(0x11), 12
+	if src.IP.To4() == nil {
+		family, length = 0x21, 36
+	}
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	// 뒤에 TLV 4바이트를 붙여서 건너뛰는지 확인
+	header = binary.BigEndian.AppendUint16(header, uint16(length+4))
+	if family == 0x11 {
+		header = append(header, src.IP.To4()...)
+		header = append(header, dst.IP.To4()...)
+	} else {
+		header = append(header, src.IP.To16()...)
+		header = append(header, dst.IP.To16()...)
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(src.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(dst.Port))
+	header = append(header, 0x04, 0x00, 0x01, 0x00)
+	return string(header)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 25}
+	for name, tt := range map[string]struct {
+		header string
+		want   string
+		err    bool
+	}{
+		"v1 tcp4":    {header: "PROXY TCP4 203.0.113.7 10.0.0.1 51234 25\r\n", want: "203.0.113.7:51234"},
+		"v1 tcp6":    {header: "PROXY TCP6 2001:db8::7 2001:db8::1 51234 25\r\n", want: "[2001:db8::7]:51234"},
+		"v1 unknown": {header: "PROXY UNKNOWN\r\n"},
+		"v1 family":  {header: "PROXY TCP4 2001:db8::7 10.0.0.1 51234 25\r\n", err: true},
+		"v1 long":    {header: "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", err: true},
+		"v2 tcp4":    {header: proxyV2Header(1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}, dst), want: "203.0.113.7:51234"},
+		"v2 tcp6":    {header: proxyV2Header(1, &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 25}), want: "[2001:db8::7]:51234"},
+		"v2 local":   {header: proxyV2Header(0, &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}, dst)},
+		"no header":  {header: "EHLO client.example\r\n", err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "EHLO client.example\r\n"))
+			addr, err := readProxyHeader(r)
+			if tt.err {
+				assert.ErrorIs(t, err, errProxyHeader)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Nil(t, addr)
+			} else {
+				assert.Equal(t, tt.want, addr.String())
+			}
+			// 헤더 뒤의 SMTP 명령은 그대로 남음
+			rest, err := r.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "EHLO client.example\r\n", rest)
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{}
+	config := DefaultConfig()
+	config.Listeners = []ListenerConfig{
+		{Addr: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: []string{"127.0.0.1"}},
+		{Addr: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: []string{"10.0.0.0/8"}},
+	}
+	app, err := NewApp(config, handler)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go app.Run(ctx)
+	trusted, untrusted := app.Listeners[0].Addr().String(), app.Listeners[1].Addr().String()
+
+	send := func(addr, header string) error {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(header))
+		require.NoError(t, err)
+		client := smtp.NewClient(conn)
+		defer client.Close()
+		if err := client.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader(testMessage)); err != nil {
+			return err
+		}
+		return client.Quit()
+	}
+
+	require.NoError(t, send(trusted, "PROXY TCP4 203.0.113.7 127.0.0.1 51234 25\r\n"))
+	require.NoError(t, send(trusted, proxyV2Header(1, &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 1}, &net.TCPAddr{IP: net.ParseIP("::1"), Port: 25})))
+	// 믿는 곳에서 헤더 없이 오면 끊음
+	assert.Error(t, send(trusted, ""))
+	// 믿지 않는 곳은 헤더를 읽지 않으므로 연결 주소 그대로
+	require.NoError(t, send(untrusted, ""))
+
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) == 3
+	}, 5*time.Second, 5*time.Millisecond)
+	_, delivered := handler.snapshot()
+	assert.Equal(t, "203.0.113.7", delivered[0].Envelope.RemoteIP.String())
+	assert.Equal(t, "2001:db8::7", delivered[1].Envelope.RemoteIP.String())
+	assert.Equal(t, "127.0.0.1", delivered[2].Envelope.RemoteIP.String())
+	// Received 헤더도 실제 클라이언트 주소
+	assert.Contains(t, string(delivered[0].Raw), "203.0.113.7")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var (
+	ErrAccessDenied = &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Access denied",
+	}
+	ErrTooManyConnections = &smtp.SMTPError{
+		Code:         421,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+		Message:      "Too many concurrent connections, try again later",
+	}
+	ErrMessageRateExceeded = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Message rate limit exceeded, try again later",
+	}
+	ErrRecipientRateExceeded = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Recipient rate limit exceeded, try again later",
+	}
+)
+
+// RateLimits는 한 대상(IP, 사용자, 발신 도메인)에 적용할 제한입니다. 0이면 제한하지 않습니다.
+type RateLimits struct {
+	// 동시 연결 수 (발신 도메인에는 적용하지 않음)
+	MaxConnections int `json:"max_connections"`
+	// 분당 메일 수 (MAIL 명령 기준)
+	MessagesPerMinute int `json:"messages_per_minute"`
+	// 시간당 수신자 수 (RCPT 명령 기준)
+	RecipientsPerHour int `json:"recipients_per_hour"`
+}
+
+// RateLimiter는 클라이언트 IP, 인증된 사용자, MAIL FROM 도메인별로 연결과 전송량을 제한합니다.
+// 메일과 수신자 수는 토큰 버킷으로 세므로 한도만큼은 한꺼번에 보낼 수 있습니다.
+type RateLimiter struct {
+	PerIP     RateLimits
+	PerUser   RateLimits
+	PerDomain RateLimits
+	// 여기 속한 IP는 제한하지 않음 (내부 서버 등)
+	Allow []*net.IPNet
+	// 여기 속한 IP는 연결을 거절 (Allow보다 우선)
+	Deny []*net.IPNet
+
+	// 테스트에서 시간을 바꾸기 위한 훅
+	now func() time.Time
+
+	mu      sync.Mutex
+	conns   map[string]int
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket은 per마다 limit개씩 채워지는 토큰 버킷입니다
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  int
+	per    time.Duration
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.tokens += float64(b.limit) * elapsed.Seconds() / b.per.Seconds()
+	if b.tokens > float64(b.limit) {
+		b.tokens = float64(b.limit)
+	}
+}
+
+// ParseCIDRs는 "192.0.2.0/24"나 "2001:db8::1" 같은 목록을 네트워크로 바꿉니다 (단일 IP는 /32, /128)
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Connect는 새 연결을 받을지 확인하고 동시 연결 수를 셉니다.
+// nil을 반환했으면 연결이 끝날 때 Disconnect를 호출해야 합니다.
+func (l *RateLimiter) Connect(ip net.IP) error {
+	if containsIP(l.Deny, ip) {
+		return ErrAccessDenied
+	}
+	if l.exempt(ip) {
+		return nil
+	}
+	return l.acquire("ip:"+ip.String(), l.PerIP.MaxConnections, ErrTooManyConnections)
+}
+
+// Disconnect는 Connect로 센 연결을 뺍니다
+func (l *RateLimiter) Disconnect(ip net.IP) {
+	if l.exempt(ip) {
+		return
+	}
+	l.release("ip:" + ip.String())
+}
+
+// Login은 인증된 사용자의 동시 연결 수를 셉니다. nil을 반환했으면 나중에 Logout을 호출해야 합니다.
+func (l *RateLimiter) Login(ip net.IP, username string) error {
+	if l.exempt(ip) {
+		return nil
+	}
+	return l.acquire("user:"+username, l.PerUser.MaxConnections, ErrTooManyConnections)
+}
+
+// Logout은 Login으로 센 연결을 뺍니다
+func (l *RateLimiter) Logout(ip net.IP, username string) {
+	if l.exempt(ip) {
+		return
+	}
+	l.release("user:" + username)
+}
+
+// Message는 MAIL 명령에서 메일 한 통을 보낼 수 있는지 확인합니다 (username은 인증하지 않았으면 "")
+func (l *RateLimiter) Message(ip net.IP, username, from string) error {
+	return l.take(ip, username, from, time.Minute, ErrMessageRateExceeded, func(limits RateLimits) int {
+		return limits.MessagesPerMinute
+	})
+}
+
+// Recipient는 RCPT 명령에서 수신자를 하나 더 받을 수 있는지 확인합니다
+func (l *RateLimiter) Recipient(ip net.IP, username, from string) error {
+	return l.take(ip, username, from, time.Hour, ErrRecipientRateExceeded, func(limits RateLimits) int {
+		return limits.RecipientsPerHour
+	})
+}
+
+func (l *RateLimiter) exempt(ip net.IP) bool {
+	return ip == nil || containsIP(l.Allow, ip)
+}
+
+func (l *RateLimiter) acquire(key string, limit int, exceeded error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit > 0 && l.conns[key] >= limit {
+		return exceeded
+	}
+	if l.conns == nil {
+		l.conns = map[string]int{}
+	}
+	l.conns[key]++
+	return nil
+}
+
+func (l *RateLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[key] <= 1 {
+		delete(l.conns, key)
+		return
+	}
+	l.conns[key]--
+}
+
+// take는 IP, 사용자, 발신 도메인의 버킷에서 토큰을 하나씩 꺼냅니다.
+// 하나라도 비어 있으면 아무것도 꺼내지 않고 exceeded를 반환합니다.
+func (l *RateLimiter) take(ip net.IP, username, from string, per time.Duration, exceeded error, limitOf func(RateLimits) int) error {
+	if l.exempt(ip) {
+		return nil
+	}
+
+	type target struct {
+		key   string
+		limit int
+	}
+	targets := []target{{"ip:" + ip.String(), limitOf(l.PerIP)}}
+	if username != "" {
+		targets = append(targets, target{"user:" + username, limitOf(l.PerUser)})
+	}
+	if _, domain, ok := strings.Cut(from, "@"); ok && domain != "" {
+		targets = append(targets, target{"domain:" + strings.ToLower(domain), limitOf(l.PerDomain)})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock()
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	if len(l.buckets) > 10000 {
+		l.sweep(now)
+	}
+
+	buckets := make([]*tokenBucket, 0, len(targets))
+	for _, t := range targets {
+		if t.limit <= 0 {
+			continue
+		}
+		key := t.key + "/" + per.String()
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = &tokenBucket{tokens: float64(t.limit), last: now, limit: t.limit, per: per}
+			l.buckets[key] = bucket
+		}
+		bucket.refill(now)
+		if bucket.tokens < 1 {
+			return exceeded
+		}
+		buckets = append(buckets, bucket)
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// sweep은 가득 찬(= 새로 만든 것과 같은) 버킷을 지워서 메모리를 회수합니다
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= bucket.per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *RateLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnLimitListener는 연결을 받을 때 RateLimiter.Connect로 IP별 동시 연결 수와 차단 목록을 확인하는 리스너입니다.
+// HELO 전에는 세션이 만들어지지 않으므로 인사하지 않고 연결만 잡아 두는 클라이언트도 여기서 셉니다.
+// 확인은 연결의 첫 Read/Write에서 연결의 고루틴이 하므로 ProxyListener 바깥에 두면 PROXY 헤더의 주소로 셉니다.
+// 거절할 때 서버가 먼저 쓰려던 것(평문 SMTP의 인사말)이면 그 대신 421/554 응답을 쓰고 끊고,
+// 먼저 읽으려던 것(implicit TLS 핸드셰이크)이면 그냥 끊습니다.
+type ConnLimitListener struct {
+	net.Listener
+	Limiter *RateLimiter
+	// 거절을 기록할 지표 (nil이면 기록하지 않음)
+	Metrics *Metrics
+}
+
+func (l *ConnLimitListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &limitConn{Conn: conn, listener: l}, nil
+}
+
+// limitConn은 Connect로 센 연결입니다. Close할 때 Disconnect합니다.
+type limitConn struct {
+	net.Conn
+	listener *ConnLimitListener
+
+	once    sync.Once
+	ip      net.IP
+	counted bool
+	// 거절했으면 net.ErrClosed
+	err error
+
+	closeOnce sync.Once
+}
+
+// check는 처음 한 번 연결을 받을지 확인합니다. reply면 거절 응답을 쓰고 끊습니다.
+// 거절한 뒤에는 net.ErrClosed를 반환합니다 (서버가 정상 종료로 보고 에러 로그를 남기지 않도록).
+func (c *limitConn) check(reply bool) error {
+	c.once.Do(func() {
+		c.ip = remoteIP(c.Conn.RemoteAddr())
+		err := c.listener.Limiter.Connect(c.ip)
+		if err == nil {
+			c.counted = true
+			return
+		}
+
+		c.err = net.ErrClosed
+		reason := reasonRateLimit
+		if err == ErrAccessDenied {
+			reason = reasonAccessDenied
+		}
+		c.listener.Metrics.messageRejected(reason)
+		slog.Info("연결 거절", "remote_ip", c.ip, "reason", reason)
+		if smtpErr, ok := err.(*smtp.SMTPError); ok && reply {
+			c.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			fmt.Fprintf(c.Conn, "%d %d.%d.%d %s\r\n", smtpErr.Code,
+				smtpErr.EnhancedCode[0], smtpErr.EnhancedCode[1], smtpErr.EnhancedCode[2], smtpErr.Message)
+		}
+		c.Conn.Close()
+	})
+	return c.err
+}
+
+func (c *limitConn) Read(p []byte) (int, error) {
+	if err := c.check(false); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *limitConn) Write(p []byte) (int, error) {
+	if err := c.check(true); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *limitConn) Close() error {
+	// 먼저 닫아야 PROXY 헤더를 기다리던 check가 끝남
+	err := c.Conn.Close()
+	c.once.Do(func() {})
+	c.closeOnce.Do(func() {
+		if c.counted {
+			c.listener.Limiter.Disconnect(c.ip)
+		}
+	})
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	allow, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	deny, err := ParseCIDRs([]string{"203.0.113.66"})
+	require.NoError(t, err)
+	limiter := &RateLimiter{
+		PerIP:     RateLimits{MaxConnections: 2, MessagesPerMinute: 3, RecipientsPerHour: 100},
+		PerUser:   RateLimits{MaxConnections: 1},
+		PerDomain: RateLimits{MessagesPerMinute: 2},
+		Allow:     allow,
+		Deny:      deny,
+		now:       clock.Now,
+	}
+	ip := net.ParseIP("192.0.2.10")
+
+	// 동시 연결
+	require.NoError(t, limiter.Connect(ip))
+	require.NoError(t, limiter.Connect(ip))
+	assert.Equal(t, ErrTooManyConnections, limiter.Connect(ip))
+	limiter.Disconnect(ip)
+	assert.NoError(t, limiter.Connect(ip))
+	assert.Equal(t, ErrAccessDenied, limiter.Connect(net.ParseIP("203.0.113.66")))
+
+	require.NoError(t, limiter.Login(ip, "billing"))
+	assert.Equal(t, ErrTooManyConnections, limiter.Login(net.ParseIP("192.0.2.11"), "billing"))
+	limiter.Logout(ip, "billing")
+	assert.NoError(t, limiter.Login(ip, "billing"))
+
+	// 발신 도메인 한도(2)가 IP 한도(3)보다 먼저 걸리고, 거절된 요청은 IP 토큰을 쓰지 않음
+	assert.NoError(t, limiter.Message(ip, "", "a@example.com"))
+	assert.NoError(t, limiter.Message(ip, "", "b@Example.com"))
+	assert.Equal(t, ErrMessageRateExceeded, limiter.Message(ip, "", "c@example.com"))
+	assert.NoError(t, limiter.Message(ip, "", "a@other.com"))
+	assert.Equal(t, ErrMessageRateExceeded, limiter.Message(ip, "", "b@other.com"))
+
+	// 허용 목록은 제한하지 않음
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, limiter.Message(net.ParseIP("10.1.2.3"), "", "a@example.com"))
+	}
+
+	// 20초면 IP 토큰 하나가 다시 참
+	clock.Advance(20 * time.Second)
+	assert.NoError(t, limiter.Message(ip, "", "b@other.com"))
+	assert.Equal(t, ErrMessageRateExceeded, limiter.Message(ip, "", "c@other.com"))
+}
+
+func TestRateLimitAtSession(t *testing.T) {
+	t.Parallel()
+
+	limiter := &RateLimiter{
+		PerIP: RateLimits{MaxConnections: 1, MessagesPerMinute: 1},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := smtp.NewServer(&Backend{RateLimit: limiter})
+	server.Domain = "localhost"
+	go server.Serve(&ConnLimitListener{Listener: listener, Limiter: limiter})
+	t.Cleanup(func() { server.Close() })
+	addr := listener.Addr().String()
+
+	// HELO 없이 연결만 잡고 있어도 셈
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	greeting, err := bufio.NewReader(first).ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(greeting, "220 "), greeting)
+
+	// 인사말 대신 421을 받고 끊김
+	second, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	err = second.Hello("localhost")
+	second.Close()
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 421, smtpErr.Code)
+
+	first.Close()
+
+	// 연결이 끝나면 다시 접속할 수 있고, 분당 한 통까지만 보낼 수 있음
+	require.Eventually(t, func() bool {
+		return sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage) == nil
+	}, time.Second, 10*time.Millisecond)
+	err = sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+}
+
+func TestConnLimitBehindProxy(t *testing.T) {
+	t.Parallel()
+
+	limiter := &RateLimiter{PerIP: RateLimits{MaxConnections: 1}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	trusted, err := ParseCIDRs([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	server := smtp.NewServer(&Backend{})
+	server.Domain = "localhost"
+	go server.Serve(&ConnLimitListener{Listener: &ProxyListener{Listener: listener, Trusted: trusted}, Limiter: limiter})
+	t.Cleanup(func() { server.Close() })
+
+	dial := func(client string) (net.Conn, string) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		fmt.Fprintf(conn, "PROXY TCP4 %s 192.0.2.100 40000 25\r\n", client)
+		reply, _ := bufio.NewReader(conn).ReadString('\n')
+		return conn, reply
+	}
+
+	// 로드 밸런서 주소가 아니라 PROXY 헤더의 클라이언트 주소별로 셈
+	_, reply := dial("203.0.113.1")
+	assert.True(t, strings.HasPrefix(reply, "220 "), reply)
+	_, reply = dial("203.0.113.2")
+	assert.True(t, strings.HasPrefix(reply, "220 "), reply)
+	_, reply = dial("203.0.113.1")
+	assert.True(t, strings.HasPrefix(reply, "421 "), reply)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+var (
+	ErrUnknownRecipient = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "No such user here",
+	}
+	ErrRelayDenied = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Relay access denied",
+	}
+	ErrRecipientLookupFailed = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Recipient lookup failed, try again later",
+	}
+)
+
+// RecipientLookup은 정규화된 수신 주소(소문자, plus 태그 제거)의 메일함이 있는지 조회합니다
+type RecipientLookup interface {
+	LookupRecipient(ctx context.Context, address string) (bool, error)
+}
+
+// RecipientPolicy는 RCPT 단계에서 받을 수신자를 결정합니다
+type RecipientPolicy struct {
+	// 메일을 받는 도메인
+	Domains []string
+	// Domains 중에서 존재하는 메일함 (user@domain)
+	Mailboxes []string
+	// 모든 메일함을 받는 도메인 (Domains에 없어도 됨)
+	CatchAllDomains []string
+	// plus-address 구분자 (보통 "+"). 비어 있으면 정규화하지 않음
+	PlusSeparator string
+	// Mailboxes에 없는 주소를 추가로 조회 (nil이면 조회하지 않음)
+	Lookup RecipientLookup
+}
+
+// Normalize는 주소를 비교용 형태(소문자, plus 태그 제거)로 바꿉니다
+func (p *RecipientPolicy) Normalize(address string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(address), "@")
+	if !ok {
+		return local
+	}
+	if p.PlusSeparator != "" {
+		local, _, _ = strings.Cut(local, p.PlusSeparator)
+	}
+	return local + "@" + domain
+}
+
+// IsLocal은 address가 우리가 받는 도메인(Domains, CatchAllDomains)의 주소인지 확인합니다 (메일함은 조회하지 않음)
+func (p *RecipientPolicy) IsLocal(address string) bool {
+	_, domain, ok := strings.Cut(address, "@")
+	return ok && (containsFold(p.Domains, domain) || containsFold(p.CatchAllDomains, domain))
+}
+
+// Check는 수신자를 받을지 결정합니다. 거절할 때는 *smtp.SMTPError를 반환합니다.
+func (p *RecipientPolicy) Check(ctx context.Context, address string) error {
+	normalized := p.Normalize(address)
+	_, domain, ok := strings.Cut(normalized, "@")
+	if !ok {
+		return ErrUnknownRecipient
+	}
+
+	if containsFold(p.CatchAllDomains, domain) {
+		return nil
+	}
+	if !containsFold(p.Domains, domain) {
+		return ErrRelayDenied
+	}
+	if containsFold(p.Mailboxes, normalized) {
+		return nil
+	}
+
+	if p.Lookup != nil {
+		exists, err := p.Lookup.LookupRecipient(ctx, normalized)
+		if err != nil {
+			slog.Error("수신자 조회 실패", "to", normalized, "error", err)
+			return ErrRecipientLookupFailed
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	return ErrUnknownRecipient
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// PostgresRecipientLookup은 Postgres 테이블에서 메일함을 조회합니다
+type PostgresRecipientLookup struct {
+	DB *sql.DB
+	// $1에 정규화된 주소를 받아 bool 하나를 반환하는 쿼리 (비어 있으면 기본 쿼리)
+	Query string
+}
+
+const defaultRecipientQuery = `SELECT EXISTS (SELECT 1 FROM mailboxes WHERE address = $1)`
+
+func (l *PostgresRecipientLookup) LookupRecipient(ctx context.Context, address string) (bool, error) {
+	query := l.Query
+	if query == "" {
+		query = defaultRecipientQuery
+	}
+
+	var exists bool
+	if err := l.DB.QueryRowContext(ctx, query, address).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
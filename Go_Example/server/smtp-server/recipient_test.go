@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mapLookup map[string]bool
+
+func (m mapLookup) LookupRecipient(_ context.Context, address string) (bool, error) {
+	if address == "broken@example.com" {
+		return false, errors.New("connection refused")
+	}
+	return m[address], nil
+}
+
+func TestRecipientPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := &RecipientPolicy{
+		Domains:         []string{"example.com"},
+		Mailboxes:       []string{"support@example.com"},
+		CatchAllDomains: []string{"catch.example.com"},
+		PlusSeparator:   "+",
+		Lookup:          mapLookup{"user@example.com": true},
+	}
+
+	tests := []struct {
+		to   string
+		want error
+	}{
+		{to: "support@example.com", want: nil},
+		{to: "Support+ticket-42@Example.COM", want: nil},
+		{to: "user+news@example.com", want: nil},
+		{to: "anything@catch.example.com", want: nil},
+		{to: "nobody@example.com", want: ErrUnknownRecipient},
+		{to: "support@other.com", want: ErrRelayDenied},
+		{to: "broken@example.com", want: ErrRecipientLookupFailed},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, policy.Check(context.Background(), tt.to), tt.to)
+	}
+}
+
+func TestRecipientRejectedAtRcpt(t *testing.T) {
+	t.Parallel()
+
+	addr := startServerWithBackend(t, &Backend{
+		Recipients: &RecipientPolicy{Domains: []string{"example.com"}, Mailboxes: []string{"b@example.com"}},
+	})
+
+	err := sendMail(addr, "a@example.com", []string{"nobody@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Equal(t, smtp.EnhancedCode{5, 1, 1}, smtpErr.EnhancedCode)
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+)
+
+var ErrNoRelayRoute = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 4, 4},
+	Message:      "Unable to route message",
+}
+
+var ErrRelayTLSUnavailable = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 10},
+	Message:      "Smarthost does not offer STARTTLS",
+}
+
+// go-smtp 클라이언트는 BDAT를 보낼 수 없어서 BINARYMIME 메일은 중계할 수 없음 (RFC 3030 5: 변환하지 않으면 반송)
+var ErrRelayConversionRequired = &smtp.SMTPError{
+	Code:         554,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 3},
+	Message:      "Conversion required but not supported by smarthost",
+}
+
+var ErrRelaySMTPUTF8Unavailable = &smtp.SMTPError{
+	Code:         553,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 7},
+	Message:      "Smarthost does not support SMTPUTF8",
+}
+
+var ErrRelayMessageTooLarge = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Message size exceeds smarthost limit",
+}
+
+// RelayTLS는 스마트호스트 연결의 TLS 방식입니다
+type RelayTLS string
+
+const (
+	// RelaySTARTTLS는 평문으로 연결한 뒤 STARTTLS를 요구합니다 (기본값)
+	RelaySTARTTLS RelayTLS = "starttls"
+	// RelayImplicitTLS는 처음부터 TLS로 연결합니다 (465 방식)
+	RelayImplicitTLS RelayTLS = "tls"
+	// RelayPlaintext는 TLS 없이 보냅니다 (내부망 전용)
+	RelayPlaintext RelayTLS = "none"
+)
+
+// RelayRoute는 수신 도메인을 업스트림 스마트호스트로 보내는 규칙입니다
+type RelayRoute struct {
+	// 수신 도메인 ("*"는 다른 어디에도 해당하지 않는 도메인)
+	Domains []string
+	// 스마트호스트 주소 (host:port)
+	Host string
+	// 비어 있으면 AUTH 없이 보냄
+	Username string
+	Password string
+	// 비어 있으면 RelaySTARTTLS
+	TLS RelayTLS
+	// nil이면 시스템 CA로 Host의 인증서를 검증
+	TLSConfig *tls.Config
+}
+
+// Relay는 라우트에 해당하는 수신자의 메일을 외부 발송 큐(Spool)에 넣고, 큐 워커가 스마트호스트로 보냅니다.
+// 일시적 실패는 큐가 백오프로 재시도하고, 영구 실패하거나 재시도를 다 쓴 수신자에게는 반송 메일(DSN)을 보냅니다.
+//
+// "*" 라우트는 인증하지 않은 클라이언트의 아무 수신자나 중계할 수 있으므로 RecipientPolicy와 함께 써야 합니다.
+type Relay struct {
+	Routes []RelayRoute
+	// 외부 발송 큐 (NewRelay가 만듦)
+	Queue *Spool
+	// EHLO 이름이자 반송 메일의 Reporting-MTA
+	Hostname string
+	// 연결과 명령마다의 타임아웃
+	Timeout time.Duration
+	// 반송 메일을 전달할 핸들러 (보통 받은 메일과 같은 체인).
+	// nil이면 발신자 도메인에 라우트가 있을 때만 큐로 반송합니다.
+	Local MessageHandler
+	// 우리가 받는 도메인. 이 도메인의 수신자와 서명된 주소(Envelope.Tokens)의 수신자는
+	// "*" 라우트로 보내지 않음 (받은 메일을 스마트호스트로 다시 내보내지 않도록)
+	Recipients *RecipientPolicy
+	// 설정하면 인증한 사용자가 보낸 메일(Envelope.User)과 우리가 만든 반송 메일에 보내기 전에 DKIM-Signature를 붙임
+	// (보내는 도메인의 키가 없으면 그대로). 인증하지 않고 "*"로 중계하는 메일은 From을 믿을 수 없으므로 서명하지 않음
+	DKIM *DKIMSigner
+}
+
+// NewRelay는 queueDir에 외부 발송 큐를 만들고 기본 설정의 Relay를 반환합니다
+func NewRelay(routes []RelayRoute, queueDir, hostname string) (*Relay, error) {
+	relay := &Relay{
+		Routes:   routes,
+		Hostname: hostname,
+		Timeout:  5 * time.Minute,
+	}
+
+	queue, err := NewSpool(queueDir, MessageHandlerFunc(relay.deliver))
+	if err != nil {
+		return nil, err
+	}
+	queue.Bounce = relay.bounce
+	relay.Queue = queue
+	return relay, nil
+}
+
+// HandleMessage는 라우트가 있는 수신자만 골라 외부 발송 큐에 넣습니다 (나머지 수신자는 다른 핸들러의 몫)
+func (r *Relay) HandleMessage(_ context.Context, msg *Message) error {
+	var to []string
+	for _, recipient := range msg.Envelope.To {
+		if r.route(recipient, r.local(recipient, msg.Envelope)) != nil {
+			to = append(to, recipient)
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	envelope := msg.Envelope
+	envelope.To = to
+	// 뒤의 핸들러가 실패해서 클라이언트가 다시 보낸 메일은 한 번만 보냄
+	id, duplicate, err := r.Queue.EnqueueOnce(msg.DedupKey(), envelope, msg.Raw)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		slog.Info("이미 외부 발송 대기에 넣은 메일", "session", envelope.SessionID, "spool_id", id, "to", to)
+		return nil
+	}
+	slog.Info("외부 발송 대기", "session", envelope.SessionID, "spool_id", id, "to", to)
+	return nil
+}
+
+// local은 recipient가 우리가 받는 주소인지 확인합니다
+func (r *Relay) local(recipient string, envelope Envelope) bool {
+	for _, token := range envelope.Tokens {
+		if strings.EqualFold(token.Recipient, recipient) {
+			return true
+		}
+	}
+	return r.Recipients != nil && r.Recipients.IsLocal(recipient)
+}
+
+// route는 recipient 도메인의 라우트를 반환합니다 (없으면 nil). local이면 "*" 라우트는 쓰지 않습니다.
+func (r *Relay) route(recipient string, local bool) *RelayRoute {
+	domain := recipient
+	if idx := strings.LastIndex(recipient, "@"); idx != -1 {
+		domain = recipient[idx+1:]
+	}
+
+	var fallback *RelayRoute
+	for i := range r.Routes {
+		for _, routeDomain := range r.Routes[i].Domains {
+			if routeDomain == "*" {
+				if fallback == nil && !local {
+					fallback = &r.Routes[i]
+				}
+			} else if strings.EqualFold(routeDomain, domain) {
+				return &r.Routes[i]
+			}
+		}
+	}
+	return fallback
+}
+
+// deliver는 큐 워커가 호출합니다. 라우트별로 스마트호스트에 보내고 수신자별 결과를 반환합니다.
+func (r *Relay) deliver(ctx context.Context, msg *Message) error {
+	errs := RecipientErrors{}
+	var routes []*RelayRoute
+	byRoute := map[*RelayRoute][]string{}
+	for _, recipient := range msg.Envelope.To {
+		route := r.route(recipient, r.local(recipient, msg.Envelope))
+		if route == nil {
+			errs[recipient] = ErrNoRelayRoute
+			continue
+		}
+		if _, ok := byRoute[route]; !ok {
+			routes = append(routes, route)
+		}
+		byRoute[route] = append(byRoute[route], recipient)
+	}
+
+	raw := msg.Raw
+	if r.DKIM != nil && len(routes) > 0 && (msg.Envelope.User != "" || msg.Envelope.Generated) {
+		// 서명하지 못해도 보냄 (키 문제로 메일을 잃지 않도록, 받는 쪽에서는 서명 없는 메일)
+		signed, err := r.DKIM.Sign(msg.Envelope.From, raw)
+		if err != nil {
+			slog.Error("DKIM 서명 실패", "session", msg.Envelope.SessionID, "error", err)
+		} else {
+			raw = signed
+		}
+	}
+
+	for _, route := range routes {
+		for recipient, err := range r.send(ctx, route, msg.Envelope, byRoute[route], raw) {
+			errs[recipient] = err
+		}
+	}
+
+	for _, recipient := range msg.Envelope.To {
+		if err, failed := errs[recipient]; failed {
+			slog.Warn("외부 발송 실패", "session", msg.Envelope.SessionID, "recipient", recipient, "error", err)
+		} else {
+			slog.Info("외부 발송", "session", msg.Envelope.SessionID, "recipient", recipient)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// send는 연결 하나로 to에게 보내고, 실패한 수신자의 에러를 반환합니다.
+// 받을 때의 BODY=, SMTPUTF8을 스마트호스트가 지원하지 않으면 바꾸지 않고 반송합니다.
+func (r *Relay) send(ctx context.Context, route *RelayRoute, envelope Envelope, to []string, raw []byte) RecipientErrors {
+	errs := RecipientErrors{}
+	failAll := func(err error) RecipientErrors {
+		for _, recipient := range to {
+			if _, ok := errs[recipient]; !ok {
+				errs[recipient] = err
+			}
+		}
+		return errs
+	}
+
+	client, err := r.dial(ctx, route)
+	if err != nil {
+		return failAll(err)
+	}
+	defer client.Close()
+
+	if route.Username != "" {
+		if err := client.Auth(sasl.NewPlainClient("", route.Username, route.Password)); err != nil {
+			return failAll(err)
+		}
+	}
+	if err := checkRelayExtensions(client, envelope, len(raw)); err != nil {
+		client.Quit()
+		return failAll(err)
+	}
+	// BODY=는 받을 때 값 그대로 (go-smtp 클라이언트는 8BITMIME을 광고하는 서버에는 항상 BODY=8BITMIME을 붙임)
+	opts := &smtp.MailOptions{Size: int64(len(raw)), UTF8: envelope.SMTPUTF8, Body: envelope.Body}
+	if err := client.Mail(envelope.From, opts); err != nil {
+		return failAll(err)
+	}
+
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient, nil); err != nil {
+			errs[recipient] = err
+		}
+	}
+	if len(errs) == len(to) {
+		client.Quit()
+		return errs
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return failAll(err)
+	}
+	if _, err := writer.Write(raw); err != nil {
+		return failAll(err)
+	}
+	if err := writer.Close(); err != nil {
+		return failAll(err)
+	}
+	client.Quit()
+	return errs
+}
+
+// checkRelayExtensions는 메일을 보내는 데 필요한 확장을 스마트호스트가 지원하는지 확인합니다
+func checkRelayExtensions(client *smtp.Client, envelope Envelope, size int) error {
+	switch envelope.Body {
+	case smtp.BodyBinaryMIME:
+		return ErrRelayConversionRequired
+	case smtp.Body8BitMIME:
+		if ok, _ := client.Extension("8BITMIME"); !ok {
+			return ErrRelayConversionRequired
+		}
+	}
+	if ok, _ := client.Extension("SMTPUTF8"); envelope.SMTPUTF8 && !ok {
+		return ErrRelaySMTPUTF8Unavailable
+	}
+	if limit, ok := client.MaxMessageSize(); ok && limit > 0 && size > limit {
+		return ErrRelayMessageTooLarge
+	}
+	return nil
+}
+
+// dial은 스마트호스트에 연결해서 EHLO와 TLS까지 마친 클라이언트를 반환합니다
+func (r *Relay) dial(ctx context.Context, route *RelayRoute) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(route.Host)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if route.TLSConfig != nil {
+		tlsConfig = route.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: r.Timeout}
+	var conn net.Conn
+	if route.TLS == RelayImplicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", route.Host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", route.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var client *smtp.Client
+	if route.TLS == "" || route.TLS == RelaySTARTTLS {
+		// STARTTLS 전의 EHLO는 go-smtp 기본 이름(localhost)으로 나가고, TLS 이후에 Hostname으로 다시 EHLO
+		client, err = smtp.NewClientStartTLS(conn, tlsConfig)
+		if err != nil {
+			conn.Close()
+			// go-smtp는 STARTTLS 미지원을 별도 에러 타입 없이 반환함
+			if strings.Contains(err.Error(), "doesn't support STARTTLS") {
+				return nil, ErrRelayTLSUnavailable
+			}
+			return nil, err
+		}
+	} else {
+		client = smtp.NewClient(conn)
+	}
+	client.CommandTimeout = r.Timeout
+	client.SubmissionTimeout = r.Timeout
+
+	if err := client.Hello(r.Hostname); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// bounce는 큐가 포기한 수신자에 대한 반송 메일을 원래 발신자에게 보냅니다
+func (r *Relay) bounce(ctx context.Context, envelope Envelope, raw []byte, failed []RecipientStatus) error {
+	// 반송 메일(널 발신자)의 실패는 다시 반송하지 않음 (RFC 5321 4.5.5)
+	if envelope.From == "" {
+		slog.Warn("반송 메일 전달 실패, 폐기", "session", envelope.SessionID)
+		return nil
+	}
+
+	dsn := r.DSN(envelope, raw, failed, time.Now())
+	parsed, err := parsers.ParseEmail(string(dsn))
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		Envelope: Envelope{To: []string{envelope.From}, Helo: r.Hostname, SessionID: envelope.SessionID, Generated: true},
+		Raw:      dsn,
+		Parsed:   parsed,
+	}
+
+	if r.Local != nil {
+		return r.Local.HandleMessage(ctx, msg)
+	}
+	if r.route(envelope.From, r.local(envelope.From, Envelope{})) == nil {
+		return fmt.Errorf("relay: no route for bounce to %s", envelope.From)
+	}
+	return r.HandleMessage(ctx, msg)
+}
+
+// DSN은 failed 수신자에 대한 반송 메일(RFC 3464 multipart/report)을 만듭니다.
+// 원본은 헤더만 붙입니다 (text/rfc822-headers).
+func (r *Relay) DSN(envelope Envelope, raw []byte, failed []RecipientStatus, now time.Time) []byte {
+	boundary := "dsn-" + newSessionID()
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", r.Hostname)
+	fmt.Fprintf(&b, "To: <%s>\r\n", envelope.From)
+	b.WriteString("Subject: Undelivered Mail Returned to Sender\r\n")
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", newSessionID(), r.Hostname)
+	b.WriteString("Auto-Submitted: auto-replied\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=delivery-status; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "This is the mail system at host %s.\r\n\r\n", r.Hostname)
+	b.WriteString("Your message could not be delivered to one or more recipients.\r\n\r\n")
+	for _, status := range failed {
+		fmt.Fprintf(&b, "<%s>: %s\r\n", status.Recipient, status.Error)
+	}
+
+	fmt.Fprintf(&b, "\r\n--%s\r\nContent-Type: message/delivery-status\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "Reporting-MTA: dns; %s\r\n", r.Hostname)
+	for _, status := range failed {
+		fmt.Fprintf(&b, "\r\nFinal-Recipient: rfc822; %s\r\n", status.Recipient)
+		b.WriteString("Action: failed\r\n")
+		fmt.Fprintf(&b, "Status: %s\r\n", dsnStatus(status))
+		if status.Code != 0 {
+			reply := strings.Join(strings.Fields(fmt.Sprintf("%d %s %s", status.Code, status.EnhancedCode, status.Error)), " ")
+			fmt.Fprintf(&b, "Diagnostic-Code: smtp; %s\r\n", reply)
+		}
+		fmt.Fprintf(&b, "Last-Attempt-Date: %s\r\n", status.UpdatedAt.Format(time.RFC1123Z))
+	}
+
+	fmt.Fprintf(&b, "\r\n--%s\r\nContent-Type: text/rfc822-headers\r\n\r\n", boundary)
+	header := raw
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx != -1 {
+		header = raw[:idx+2]
+	}
+	b.Write(header)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+// dsnStatus는 DSN의 Status 필드 값입니다 (RFC 3463)
+func dsnStatus(status RecipientStatus) string {
+	switch {
+	case status.EnhancedCode != "":
+		return status.EnhancedCode
+	case status.Code >= 500:
+		return "5.0.0"
+	default:
+		// 일시적 실패로 재시도를 다 쓴 경우 (delivery time expired)
+		return "4.4.7"
+	}
+}
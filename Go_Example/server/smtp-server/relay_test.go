@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRelay(t *testing.T, routes ...RelayRoute) *Relay {
+	t.Helper()
+
+	relay, err := NewRelay(routes, t.TempDir(), "relay.test")
+	require.NoError(t, err)
+	relay.Timeout = 5 * time.Second
+	relay.Queue.BaseDelay = 10 * time.Millisecond
+	relay.Queue.MaxDelay = 50 * time.Millisecond
+	relay.Queue.PollInterval = 5 * time.Millisecond
+	relay.Queue.MaxAttempts = 3
+	startSpool(t, relay.Queue)
+	return relay
+}
+
+func TestRelayAuthenticatedSTARTTLS(t *testing.T) {
+	t.Parallel()
+
+	store, err := LoadFileCredentialStore(credentialFile(t))
+	require.NoError(t, err)
+	upstream := &recordingHandler{errs: []error{TemporaryError("try again")}}
+	addr, _, certFile, _ := startTLSServer(t, &Backend{
+		Handler:     upstream,
+		Credentials: store,
+		TLSPolicy:   TLSPolicy{RequireForAuth: true, RequireForMail: true},
+	})
+
+	pem, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(pem))
+
+	relay := newTestRelay(t, RelayRoute{
+		Domains:   []string{"remote.example"},
+		Host:      addr,
+		Username:  "billing",
+		Password:  "s3cret",
+		TLSConfig: &tls.Config{RootCAs: roots},
+	})
+	inbound := startServer(t, Chain(&recordingHandler{}, relay))
+
+	require.NoError(t, sendMail(inbound, "billing@example.com", []string{"a@remote.example", "b@local.example"}, testMessage))
+
+	// 첫 시도는 451, 재시도에서 전달
+	require.Eventually(t, func() bool {
+		_, delivered := upstream.snapshot()
+		return len(delivered) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	calls, delivered := upstream.snapshot()
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "billing@example.com", delivered[0].Envelope.From)
+	assert.Equal(t, []string{"a@remote.example"}, delivered[0].Envelope.To)
+	assert.Equal(t, "relay.test", delivered[0].Envelope.Helo)
+	assert.Equal(t, "hello", delivered[0].Parsed.Subject)
+}
+
+func TestRelayBouncesPerRecipient(t *testing.T) {
+	t.Parallel()
+
+	upstream := &recordingHandler{}
+	addr := startServerWithBackend(t, &Backend{
+		Handler:    upstream,
+		Recipients: &RecipientPolicy{Domains: []string{"remote.example"}, Mailboxes: []string{"ok@remote.example"}},
+	})
+
+	local := &recordingHandler{}
+	relay := newTestRelay(t, RelayRoute{Domains: []string{"remote.example"}, Host: addr, TLS: RelayPlaintext})
+	relay.Local = local
+
+	id, err := relay.Queue.Enqueue(Envelope{
+		From:      "sender@example.com",
+		To:        []string{"ok@remote.example", "nobody@remote.example"},
+		SessionID: "abc",
+	}, []byte(testMessage))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, bounces := local.snapshot()
+		return len(bounces) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	_, delivered := upstream.snapshot()
+	require.Len(t, delivered, 1)
+	assert.Equal(t, []string{"ok@remote.example"}, delivered[0].Envelope.To)
+
+	// 반송 메일은 널 발신자로 원래 발신자에게
+	_, bounces := local.snapshot()
+	bounce := bounces[0]
+	assert.Equal(t, "", bounce.Envelope.From)
+	assert.Equal(t, []string{"sender@example.com"}, bounce.Envelope.To)
+	assert.Equal(t, "Undelivered Mail Returned to Sender", bounce.Parsed.Subject)
+	raw := string(bounce.Raw)
+	assert.Contains(t, raw, "Content-Type: multipart/report; report-type=delivery-status")
+	assert.Contains(t, raw, "Final-Recipient: rfc822; nobody@remote.example\r\nAction: failed\r\nStatus: 5.1.1\r\nDiagnostic-Code: smtp; 550 5.1.1 No such user here\r\n")
+	assert.NotContains(t, raw, "Final-Recipient: rfc822; ok@remote.example")
+	assert.Contains(t, raw, "Subject: hello\r\n")
+
+	// 모든 수신자의 처리가 끝나면 큐에서 사라짐
+	require.Eventually(t, func() bool {
+		_, err := relay.Queue.Status(id)
+		return err == ErrSpoolEntryNotFound
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestRelayRoute(t *testing.T) {
+	t.Parallel()
+
+	relay := &Relay{Routes: []RelayRoute{
+		{Domains: []string{"*"}, Host: "fallback:25"},
+		{Domains: []string{"Partner.example"}, Host: "partner:25"},
+	}}
+	assert.Equal(t, "partner:25", relay.route("a@partner.EXAMPLE", false).Host)
+	assert.Equal(t, "fallback:25", relay.route("a@other.example", false).Host)
+
+	relay.Routes = relay.Routes[1:]
+	assert.Nil(t, relay.route("a@other.example", false))
+}
+
+func TestRelaySkipsLocalRecipients(t *testing.T) {
+	t.Parallel()
+
+	relay, err := NewRelay([]RelayRoute{
+		{Domains: []string{"*"}, Host: "smarthost:587"},
+		{Domains: []string{"partner.example"}, Host: "partner:25"},
+	}, t.TempDir(), "relay.test")
+	require.NoError(t, err)
+	relay.Recipients = &RecipientPolicy{Domains: []string{"example.com"}}
+	queued := func() int { return countFiles(t, filepath.Join(relay.Queue.Dir, spoolMsg)) }
+
+	// 우리 도메인과 서명된 주소의 수신자는 "*"로 내보내지 않음
+	reply := "reply+token@in.example.com"
+	require.NoError(t, relay.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{
+			From:   "a@sender.example",
+			To:     []string{"support@example.com", reply},
+			Tokens: []AddressToken{{Recipient: reply, Kind: AddressReply}},
+		},
+		Raw: []byte(testMessage),
+	}))
+	assert.Zero(t, queued())
+
+	// 명시한 라우트와 외부 수신자는 그대로
+	require.NoError(t, relay.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"support@example.com", "b@partner.example", "c@remote.example"}},
+		Raw:      []byte(testMessage),
+	}))
+	assert.Equal(t, 1, queued())
+	assert.Equal(t, "smarthost:587", relay.route("c@remote.example", relay.local("c@remote.example", Envelope{})).Host)
+	assert.Nil(t, relay.route("support@example.com", relay.local("support@example.com", Envelope{})))
+}
+
+func TestRelayRetriedMessageSentOnce(t *testing.T) {
+	t.Parallel()
+
+	upstream := NewTestServer(t, nil)
+	relay, err := NewRelay([]RelayRoute{{Domains: []string{"*"}, Host: upstream.Addr, TLS: RelayPlaintext}}, t.TempDir(), "relay.test")
+	require.NoError(t, err)
+	queued := func() int { return countFiles(t, filepath.Join(relay.Queue.Dir, spoolMsg)) }
+	// 중계 뒤의 핸들러가 한 번 실패
+	last := &recordingHandler{errs: []error{TemporaryError("webhook is down")}}
+	srv := NewTestServer(t, &Backend{Handler: Chain(relay, last)})
+
+	message := "Message-ID: <retry-1@example.com>\r\n" + testMessage
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, sendMail(srv.Addr, "a@example.com", []string{"b@remote.example"}, message), &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	assert.Equal(t, 1, queued())
+
+	// 클라이언트가 새 세션으로 다시 보내도 한 번만 큐에 넣음
+	require.NoError(t, sendMail(srv.Addr, "a@example.com", []string{"b@remote.example"}, message))
+	calls, delivered := last.snapshot()
+	assert.Equal(t, 2, calls)
+	assert.Len(t, delivered, 1)
+	assert.Equal(t, 1, queued())
+
+	// 수신자가 다르면 다른 메일
+	require.NoError(t, sendMail(srv.Addr, "a@example.com", []string{"c@remote.example"}, message))
+	assert.Equal(t, 2, queued())
+
+	relay.Queue.PollInterval = 5 * time.Millisecond
+	startSpool(t, relay.Queue)
+	upstream.WaitMessages(t, 2, 5*time.Second)
+	require.Eventually(t, func() bool { return queued() == 0 }, 5*time.Second, 5*time.Millisecond)
+	assert.Len(t, upstream.Messages(), 2)
+
+	// 전달을 끝낸 뒤에 다시 보내도 KeyTTL 동안은 보내지 않음
+	require.NoError(t, sendMail(srv.Addr, "a@example.com", []string{"b@remote.example"}, message))
+	assert.Zero(t, queued())
+}
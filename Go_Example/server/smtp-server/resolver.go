@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Resolver는 정책 단계(SPF, DNSBL 등)에서 쓰는 DNS 조회입니다.
+// 이름이 없으면 IsNotFound가 true인 *net.DNSError를 반환해야 합니다.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// LookupIP는 A와 AAAA 레코드를 모두 반환합니다
+	LookupIP(ctx context.Context, name string) ([]net.IP, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	// LookupAddr는 PTR 레코드를 반환합니다
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// NetResolver는 net.Resolver를 Resolver로 감쌉니다
+type NetResolver struct {
+	Resolver *net.Resolver
+}
+
+func (r NetResolver) resolver() *net.Resolver {
+	if r.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return r.Resolver
+}
+
+func (r NetResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.resolver().LookupTXT(ctx, name)
+}
+
+func (r NetResolver) LookupIP(ctx context.Context, name string) ([]net.IP, error) {
+	return r.resolver().LookupIP(ctx, "ip", name)
+}
+
+func (r NetResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return r.resolver().LookupMX(ctx, name)
+}
+
+func (r NetResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.resolver().LookupAddr(ctx, addr)
+}
+
+// MemoryResolver는 메모리에 든 zone으로 응답하는 Resolver입니다 (테스트, 로컬 개발용).
+// 이름은 대소문자와 끝의 '.'를 무시합니다.
+type MemoryResolver struct {
+	mu  sync.RWMutex
+	TXT map[string][]string
+	IP  map[string][]net.IP
+	MX  map[string][]*net.MX
+	PTR map[string][]string
+	// 여기 있는 이름은 조회하면 일시적 오류(SERVFAIL)를 반환
+	Fail map[string]bool
+}
+
+// SetTXT 등은 테스트 도중에도 안전하게 레코드를 바꿀 수 있게 합니다
+func (r *MemoryResolver) SetTXT(name string, records ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.TXT == nil {
+		r.TXT = map[string][]string{}
+	}
+	r.TXT[dnsKey(name)] = records
+}
+
+func (r *MemoryResolver) SetIP(name string, ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.IP == nil {
+		r.IP = map[string][]net.IP{}
+	}
+	parsed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		parsed = append(parsed, net.ParseIP(ip))
+	}
+	r.IP[dnsKey(name)] = parsed
+}
+
+func (r *MemoryResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	return memoryLookup(r, r.TXT, name)
+}
+
+func (r *MemoryResolver) LookupIP(_ context.Context, name string) ([]net.IP, error) {
+	return memoryLookup(r, r.IP, name)
+}
+
+func (r *MemoryResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	return memoryLookup(r, r.MX, name)
+}
+
+func (r *MemoryResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	return memoryLookup(r, r.PTR, addr)
+}
+
+func memoryLookup[T any](r *MemoryResolver, records map[string][]T, name string) ([]T, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := dnsKey(name)
+	if r.Fail[key] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	if values, ok := records[key]; ok && len(values) > 0 {
+		return values, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func dnsKey(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// isNotFound는 NXDOMAIN이나 레코드 없음(NODATA)인지 확인합니다
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var (
+	ErrSignedAddressInvalid = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "Invalid reply address",
+	}
+	ErrSignedAddressExpired = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "Reply address has expired",
+	}
+)
+
+// SignedAddressKind는 서명된 주소의 용도로, local part의 "+" 앞부분입니다
+type SignedAddressKind string
+
+const (
+	// AddressReply는 알림 메일의 Reply-To (reply+<token>@domain)
+	AddressReply SignedAddressKind = "reply"
+	// AddressBounce는 VERP 반송 주소로 보내는 메일의 MAIL FROM (bounce+<token>@domain)
+	AddressBounce SignedAddressKind = "bounce"
+)
+
+// signedAddressMACBytes는 토큰에 넣는 HMAC-SHA256의 앞부분 길이입니다 (80비트)
+const signedAddressMACBytes = 10
+
+// tokenEncoding은 대소문자를 바꾸는 MTA가 있어서 소문자만 쓰는 base32입니다
+var tokenEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// AddressToken은 서명된 주소에서 꺼낸 내용입니다 (Envelope.Tokens로 핸들러에 전달)
+type AddressToken struct {
+	// 이 토큰이 들어 있던 수신자 주소 (Envelope.To 중 하나)
+	Recipient string
+	Kind      SignedAddressKind
+	// 주소를 만들 때 넣은 값 (티켓/사용자 ID, VERP면 발송 건 ID 등)
+	ID      string
+	Expires time.Time
+}
+
+// SignedAddresses는 ID와 만료 시각을 HMAC으로 서명해서 넣은 주소를 만들고 확인합니다.
+// 토큰은 만료 시각(4바이트), ID, HMAC 앞 10바이트를 base32로 인코딩한 것이고 HMAC에는 Kind도 들어가므로
+// reply 토큰을 bounce 주소로 쓸 수 없습니다.
+type SignedAddresses struct {
+	// 주소의 도메인 (예: "in.example.com")
+	Domain string
+	// HMAC 키. 첫 번째 키로 서명하고 모든 키로 확인합니다 (키를 바꿀 때 예전 주소도 만료까지 받도록).
+	Keys [][]byte
+	// 현재 시각 (nil이면 time.Now, 테스트용)
+	Now func() time.Time
+}
+
+// Address는 kind 용도로 id를 담고 ttl 뒤에 만료되는 주소를 만듭니다.
+// local part는 64자를 넘을 수 없으므로 id는 20바이트 정도까지만 담을 수 있습니다.
+func (a *SignedAddresses) Address(kind SignedAddressKind, id string, ttl time.Duration) (string, error) {
+	if len(a.Keys) == 0 {
+		return "", errors.New("signed address: no keys")
+	}
+	payload := binary.BigEndian.AppendUint32(nil, uint32(a.now().Add(ttl).Unix()))
+	payload = append(payload, id...)
+	token := tokenEncoding.EncodeToString(append(payload, a.mac(a.Keys[0], kind, payload)...))
+	local := string(kind) + "+" + token
+	if len(local) > 64 {
+		return "", fmt.Errorf("signed address: id %q is too long for a local part", id)
+	}
+	return local + "@" + a.Domain, nil
+}
+
+// Parse는 address가 서명된 주소면 내용을 반환합니다. 서명된 주소 형식이 아니면 (nil, nil)이고,
+// 서명이 맞지 않으면 ErrSignedAddressInvalid, 만료됐으면 ErrSignedAddressExpired를 반환합니다.
+func (a *SignedAddresses) Parse(address string) (*AddressToken, error) {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || !strings.EqualFold(domain, a.Domain) {
+		return nil, nil
+	}
+	prefix, encoded, ok := strings.Cut(local, "+")
+	kind := SignedAddressKind(strings.ToLower(prefix))
+	if !ok || (kind != AddressReply && kind != AddressBounce) {
+		return nil, nil
+	}
+
+	data, err := tokenEncoding.DecodeString(strings.ToLower(encoded))
+	if err != nil || len(data) < 4+signedAddressMACBytes {
+		return nil, ErrSignedAddressInvalid
+	}
+	payload, mac := data[:len(data)-signedAddressMACBytes], data[len(data)-signedAddressMACBytes:]
+	valid := false
+	for _, key := range a.Keys {
+		if hmac.Equal(mac, a.mac(key, kind, payload)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrSignedAddressInvalid
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint32(payload)), 0)
+	if !a.now().Before(expires) {
+		return nil, ErrSignedAddressExpired
+	}
+	return &AddressToken{Recipient: address, Kind: kind, ID: string(payload[4:]), Expires: expires}, nil
+}
+
+func (a *SignedAddresses) mac(key []byte, kind SignedAddressKind, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum(nil)[:signedAddressMACBytes]
+}
+
+func (a *SignedAddresses) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedAddresses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	addresses := &SignedAddresses{
+		Domain: "in.example.com",
+		Keys:   [][]byte{[]byte("new-key"), []byte("old-key")},
+		Now:    func() time.Time { return now },
+	}
+
+	address, err := addresses.Address(AddressReply, "ticket-4821", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(address, "reply+"))
+	assert.True(t, strings.HasSuffix(address, "@in.example.com"))
+	local, _, _ := strings.Cut(address, "@")
+	assert.LessOrEqual(t, len(local), 64)
+
+	token, err := addresses.Parse(address)
+	require.NoError(t, err)
+	assert.Equal(t, &AddressToken{Recipient: address, Kind: AddressReply, ID: "ticket-4821", Expires: now.Add(time.Hour)}, token)
+
+	// 대소문자를 바꾸는 MTA를 거쳐도 됨
+	token, err = addresses.Parse(strings.ToUpper(local) + "@IN.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "ticket-4821", token.ID)
+
+	// 다른 용도로 쓰거나 토큰을 바꾸면 위조
+	_, encoded, _ := strings.Cut(local, "+")
+	_, err = addresses.Parse("bounce+" + encoded + "@in.example.com")
+	assert.Equal(t, ErrSignedAddressInvalid, err)
+	forged := []byte(encoded)
+	forged[len(forged)/2] ^= 1
+	_, err = addresses.Parse("reply+" + string(forged) + "@in.example.com")
+	assert.Equal(t, ErrSignedAddressInvalid, err)
+	_, err = addresses.Parse("reply+!!@in.example.com")
+	assert.Equal(t, ErrSignedAddressInvalid, err)
+
+	// 예전 키로 서명한 주소도 확인됨
+	old := &SignedAddresses{Domain: "in.example.com", Keys: [][]byte{[]byte("old-key")}, Now: addresses.Now}
+	bounce, err := old.Address(AddressBounce, "msg-1", time.Hour)
+	require.NoError(t, err)
+	token, err = addresses.Parse(bounce)
+	require.NoError(t, err)
+	assert.Equal(t, AddressBounce, token.Kind)
+
+	now = now.Add(2 * time.Hour)
+	_, err = addresses.Parse(address)
+	assert.Equal(t, ErrSignedAddressExpired, err)
+
+	// 서명된 주소 형식이 아니면 확인하지 않음
+	for _, address := range []string{"support@in.example.com", "reply+abc@other.example", "list+abc@in.example.com"} {
+		token, err := addresses.Parse(address)
+		assert.NoError(t, err, address)
+		assert.Nil(t, token, address)
+	}
+
+	_, err = addresses.Address(AddressReply, strings.Repeat("x", 40), time.Hour)
+	assert.Error(t, err)
+}
+
+func TestSignedAddressesAtRcpt(t *testing.T) {
+	t.Parallel()
+
+	addresses := &SignedAddresses{Domain: "in.example.com", Keys: [][]byte{[]byte("key")}}
+	srv := NewTestServer(t, &Backend{
+		Recipients:      &RecipientPolicy{CatchAllDomains: []string{"example.com"}},
+		SignedAddresses: addresses,
+	})
+
+	reply, err := addresses.Address(AddressReply, "ticket-7", time.Hour)
+	require.NoError(t, err)
+	expired, err := addresses.Address(AddressReply, "ticket-7", -time.Minute)
+	require.NoError(t, err)
+
+	// 수신자 정책에 없는 도메인이어도 서명이 맞으면 받음
+	require.NoError(t, sendMail(srv.Addr, "a@sender.example", []string{reply, "b@example.com"}, testMessage))
+	messages := srv.WaitMessages(t, 1, 5*time.Second)
+	require.Len(t, messages[0].Envelope.Tokens, 1)
+	assert.Equal(t, reply, messages[0].Envelope.Tokens[0].Recipient)
+	assert.Equal(t, "ticket-7", messages[0].Envelope.Tokens[0].ID)
+
+	var smtpErr *smtp.SMTPError
+	err = sendMail(srv.Addr, "a@sender.example", []string{expired}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, ErrSignedAddressExpired.Message, smtpErr.Message)
+	err = sendMail(srv.Addr, "a@sender.example", []string{"reply+aaaaaaaaaaaaaaaaaaaaaaaaaa@in.example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, ErrSignedAddressInvalid.Message, smtpErr.Message)
+	// 서명된 주소가 아니면 평소대로 수신자 정책
+	err = sendMail(srv.Addr, "a@sender.example", []string{"someone@in.example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, ErrRelayDenied.Message, smtpErr.Message)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrSpamRejected = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Message rejected as spam",
+}
+
+// SpamRuleType은 스팸 규칙의 종류입니다
+type SpamRuleType string
+
+const (
+	// SpamHeader는 Header의 값 중 하나가 Pattern과 맞으면 점수를 더합니다
+	SpamHeader SpamRuleType = "header"
+	// SpamBody는 텍스트/HTML 본문이 Pattern과 맞으면 점수를 더합니다
+	SpamBody SpamRuleType = "body"
+	// SpamURLs는 본문의 URL이 MinURLs개 이상이면 점수를 더합니다
+	SpamURLs SpamRuleType = "urls"
+	// SpamSPF는 MAIL FROM의 SPF 결과가 Results 중 하나면 점수를 더합니다 (SPF를 검사하지 않았으면 적용하지 않음)
+	SpamSPF SpamRuleType = "spf"
+	// SpamDKIM은 신뢰하는 Authentication-Results의 dkim 결과가 Results 중 하나면 점수를 더합니다 (결과가 없으면 "none")
+	SpamDKIM SpamRuleType = "dkim"
+	// SpamDNSBL은 연결한 IP가 Zone의 블록리스트에 있으면 점수를 더합니다 (Codes를 주면 그 응답만)
+	SpamDNSBL SpamRuleType = "dnsbl"
+)
+
+// SpamRule은 스팸 점수 규칙 하나입니다. Type에 따라 쓰는 필드가 다릅니다.
+type SpamRule struct {
+	// X-Spam-Status에 남는 이름
+	Name  string       `json:"name"`
+	Type  SpamRuleType `json:"type"`
+	Score float64      `json:"score"`
+
+	Header  string   `json:"header,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	MinURLs int      `json:"min_urls,omitempty"`
+	Results []string `json:"results,omitempty"`
+	Zone    string   `json:"zone,omitempty"`
+	Codes   []string `json:"codes,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// SpamThresholds는 점수에 따른 처리 기준입니다 (0이면 그 처리를 하지 않음)
+type SpamThresholds struct {
+	// 이 점수 이상이면 X-Spam-* 헤더를 붙여서 받음
+	Tag float64 `json:"tag"`
+	// 이 점수 이상이면 DATA에서 550으로 거절
+	Reject float64 `json:"reject"`
+}
+
+// SpamAction은 점수에 따른 처리입니다
+type SpamAction string
+
+const (
+	SpamAccept SpamAction = "accept"
+	SpamTag    SpamAction = "tag"
+	SpamReject SpamAction = "reject"
+)
+
+func (t SpamThresholds) action(score float64) SpamAction {
+	switch {
+	case t.Reject > 0 && score >= t.Reject:
+		return SpamReject
+	case t.Tag > 0 && score >= t.Tag:
+		return SpamTag
+	default:
+		return SpamAccept
+	}
+}
+
+// SpamVerdict는 메일 한 통의 채점 결과입니다
+type SpamVerdict struct {
+	Score float64
+	// 맞은 규칙 이름 (정렬됨)
+	Rules  []string
+	Action SpamAction
+}
+
+// Header는 태그할 때 메일 앞에 붙이는 X-Spam-* 헤더입니다
+func (v SpamVerdict) Header() string {
+	score := strconv.FormatFloat(v.Score, 'f', 1, 64)
+	tests := strings.Join(v.Rules, ",")
+	if tests == "" {
+		tests = "none"
+	}
+	return "X-Spam-Flag: YES\r\n" +
+		"X-Spam-Score: " + score + "\r\n" +
+		"X-Spam-Status: Yes, score=" + score + " tests=" + tests + "\r\n"
+}
+
+// SpamFilter는 DATA를 받은 뒤 응답하기 전에 규칙으로 점수를 매기고, 수신 도메인의 기준에 따라 처리를 정합니다.
+// NewSpamFilter로 만들어야 패턴이 컴파일됩니다.
+type SpamFilter struct {
+	Rules []SpamRule
+	// DNSBL 조회에 씀 (테스트에서는 MemoryResolver)
+	Resolver Resolver
+	// 수신 도메인별 기준 (없으면 Default)
+	Default SpamThresholds
+	Domains map[string]SpamThresholds
+	// dkim 규칙이 믿는 Authentication-Results의 authserv-id (앞단 MTA의 호스트 이름)
+	AuthServIDs []string
+	// 채점 한 번의 DNS 조회 제한 시간 (0이면 5초)
+	Timeout time.Duration
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://`)
+
+// NewSpamFilter는 rules의 패턴을 컴파일하고 설정을 검사합니다
+func NewSpamFilter(rules []SpamRule, resolver Resolver) (*SpamFilter, error) {
+	compiled := make([]SpamRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("spam: rule requires a name")
+		}
+		switch rule.Type {
+		case SpamHeader, SpamBody:
+			if rule.Type == SpamHeader && rule.Header == "" {
+				return nil, fmt.Errorf("spam: rule %s requires a header", rule.Name)
+			}
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("spam: rule %s: %w", rule.Name, err)
+			}
+			rule.re = re
+		case SpamURLs:
+			if rule.MinURLs <= 0 {
+				return nil, fmt.Errorf("spam: rule %s requires min_urls", rule.Name)
+			}
+		case SpamSPF, SpamDKIM:
+			if len(rule.Results) == 0 {
+				return nil, fmt.Errorf("spam: rule %s requires results", rule.Name)
+			}
+		case SpamDNSBL:
+			if rule.Zone == "" {
+				return nil, fmt.Errorf("spam: rule %s requires a zone", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("spam: rule %s has unknown type %q", rule.Name, rule.Type)
+		}
+		compiled = append(compiled, rule)
+	}
+	return &SpamFilter{Rules: compiled, Resolver: resolver}, nil
+}
+
+// Check는 msg의 점수를 매기고 처리를 정합니다.
+// 수신 도메인마다 기준이 다르면 모든 수신자가 reject일 때만 거절하고, 하나라도 tag나 reject면 태그합니다
+// (SMTP의 DATA 응답은 수신자 전체에 하나이므로).
+func (f *SpamFilter) Check(ctx context.Context, msg *Message) SpamVerdict {
+	timeout := f.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var verdict SpamVerdict
+	for i := range f.Rules {
+		rule := &f.Rules[i]
+		if f.match(ctx, rule, msg) {
+			verdict.Score += rule.Score
+			verdict.Rules = append(verdict.Rules, rule.Name)
+		}
+	}
+	sort.Strings(verdict.Rules)
+
+	verdict.Action = SpamAccept
+	rejectAll := len(msg.Envelope.To) > 0
+	for _, recipient := range msg.Envelope.To {
+		action := f.thresholds(recipient).action(verdict.Score)
+		if action != SpamAccept {
+			verdict.Action = SpamTag
+		}
+		if action != SpamReject {
+			rejectAll = false
+		}
+	}
+	if rejectAll {
+		verdict.Action = SpamReject
+	}
+	return verdict
+}
+
+// thresholds는 recipient 도메인의 기준입니다
+func (f *SpamFilter) thresholds(recipient string) SpamThresholds {
+	_, domain, _ := strings.Cut(recipient, "@")
+	for name, thresholds := range f.Domains {
+		if strings.EqualFold(name, domain) {
+			return thresholds
+		}
+	}
+	return f.Default
+}
+
+func (f *SpamFilter) match(ctx context.Context, rule *SpamRule, msg *Message) bool {
+	switch rule.Type {
+	case SpamHeader:
+		for _, value := range msg.Parsed.Headers[textproto.CanonicalMIMEHeaderKey(rule.Header)] {
+			if rule.re.MatchString(value) {
+				return true
+			}
+		}
+	case SpamBody:
+		return rule.re.MatchString(msg.Parsed.TextBody) || rule.re.MatchString(msg.Parsed.HTMLBody)
+	case SpamURLs:
+		// HTML은 href와 보이는 텍스트에 같은 URL이 겹칠 수 있어서 텍스트 본문이 있으면 그쪽만 셈
+		body := msg.Parsed.TextBody
+		if body == "" {
+			body = msg.Parsed.HTMLBody
+		}
+		return len(urlPattern.FindAllStringIndex(body, rule.MinURLs)) >= rule.MinURLs
+	case SpamSPF:
+		// SPF를 검사하지 않았으면 ("") 결과가 없는 것이지 none이 아님
+		return msg.Envelope.SPF != "" && containsFold(rule.Results, string(msg.Envelope.SPF))
+	case SpamDKIM:
+		return containsFold(rule.Results, f.dkimResult(msg))
+	case SpamDNSBL:
+		return f.listed(ctx, rule, msg.Envelope.RemoteIP)
+	}
+	return false
+}
+
+// dkimResult는 믿는 authserv-id가 남긴 dkim 결과입니다. pass가 하나라도 있으면 pass, 없으면 첫 결과, 결과가 없으면 "none"
+func (f *SpamFilter) dkimResult(msg *Message) string {
+	result := "none"
+	for _, auth := range msg.Parsed.AuthenticationResults {
+		if auth.Method != "dkim" || !containsFold(f.AuthServIDs, auth.AuthServID) {
+			continue
+		}
+		if auth.Result == "pass" {
+			return "pass"
+		}
+		if result == "none" {
+			result = auth.Result
+		}
+	}
+	return result
+}
+
+// listed는 ip가 rule.Zone에 올라 있는지 조회합니다. 조회에 실패하면 없는 것으로 봅니다 (DNS 장애로 메일을 잃지 않도록).
+func (f *SpamFilter) listed(ctx context.Context, rule *SpamRule, ip net.IP) bool {
+	if ip == nil || f.Resolver == nil {
+		return false
+	}
+	name := dnsblName(ip, rule.Zone)
+	addrs, err := f.Resolver.LookupIP(ctx, name)
+	if err != nil {
+		if !isNotFound(err) {
+			slog.Warn("DNSBL 조회 실패", "zone", rule.Zone, "ip", ip, "error", err)
+		}
+		return false
+	}
+	if len(rule.Codes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(addrs, func(addr net.IP) bool {
+		return slices.Contains(rule.Codes, addr.String())
+	})
+}
+
+// dnsblName은 ip를 조회할 이름입니다 (IPv4는 옥텟, IPv6는 니블을 거꾸로 해서 zone 앞에 붙임)
+func dnsblName(ip net.IP, zone string) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+	} else {
+		ip16 := ip.To16()
+		for i := len(ip16) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.FormatUint(uint64(ip16[i]&0x0f), 16), strconv.FormatUint(uint64(ip16[i]>>4), 16))
+		}
+	}
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(zone, ".")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSBLName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "2.0.0.127.zen.example", dnsblName(net.ParseIP("127.0.0.2"), "zen.example."))
+	assert.Equal(t,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.example",
+		dnsblName(net.ParseIP("2001:db8::1"), "bl.example"))
+}
+
+func TestSpamFilterCheck(t *testing.T) {
+	t.Parallel()
+
+	resolver := &MemoryResolver{}
+	resolver.SetIP("5.113.0.203.bl.example", "127.0.0.2")
+	resolver.SetIP("6.113.0.203.bl.example", "127.0.0.10")
+	filter, err := NewSpamFilter([]SpamRule{
+		{Name: "LISTED", Type: SpamDNSBL, Zone: "bl.example", Codes: []string{"127.0.0.2"}, Score: 5},
+		{Name: "SPF_FAIL", Type: SpamSPF, Results: []string{"fail", "softfail"}, Score: 2},
+		{Name: "DKIM_FAIL", Type: SpamDKIM, Results: []string{"fail"}, Score: 2},
+		{Name: "SUBJECT", Type: SpamHeader, Header: "subject", Pattern: "(?i)winner", Score: 1.5},
+		{Name: "BODY", Type: SpamBody, Pattern: "unsubscribe", Score: 1},
+		{Name: "URLS", Type: SpamURLs, MinURLs: 3, Score: 1},
+	}, resolver)
+	require.NoError(t, err)
+	filter.AuthServIDs = []string{"mx.example.com"}
+	filter.Default = SpamThresholds{Tag: 3, Reject: 8}
+	filter.Domains = map[string]SpamThresholds{"lenient.example": {Tag: 10}}
+
+	message := func(ip string, spf SPFResult, to ...string) *Message {
+		return &Message{
+			Envelope: Envelope{From: "a@sender.example", To: to, RemoteIP: net.ParseIP(ip), SPF: spf},
+			Parsed: parsers.ParsedEmail{
+				Headers:  map[string][]string{"Subject": {"You are a WINNER"}},
+				TextBody: "see http://a.example http://b.example https://c.example to unsubscribe",
+				AuthenticationResults: []parsers.AuthenticationResult{
+					// 믿지 않는 authserv-id의 결과는 무시
+					{AuthServID: "forged.example", Method: "dkim", Result: "pass"},
+					{AuthServID: "mx.example.com", Method: "dkim", Result: "fail"},
+				},
+			},
+		}
+	}
+
+	verdict := filter.Check(context.Background(), message("203.0.113.5", SPFFail, "b@example.com"))
+	assert.Equal(t, 12.5, verdict.Score)
+	assert.Equal(t, []string{"BODY", "DKIM_FAIL", "LISTED", "SPF_FAIL", "SUBJECT", "URLS"}, verdict.Rules)
+	assert.Equal(t, SpamReject, verdict.Action)
+
+	// 응답 코드가 Codes에 없으면 DNSBL은 맞지 않음
+	verdict = filter.Check(context.Background(), message("203.0.113.6", SPFPass, "b@example.com"))
+	assert.Equal(t, 5.5, verdict.Score)
+	assert.Equal(t, SpamTag, verdict.Action)
+
+	// 수신 도메인 기준: 모두 reject여야 거절, 하나라도 걸리면 태그
+	verdict = filter.Check(context.Background(), message("203.0.113.6", SPFPass, "b@lenient.example"))
+	assert.Equal(t, SpamAccept, verdict.Action)
+	verdict = filter.Check(context.Background(), message("203.0.113.5", SPFFail, "b@example.com", "c@lenient.example"))
+	assert.Equal(t, SpamTag, verdict.Action)
+
+	// SPF를 검사하지 않았으면 spf 규칙은 none이어도 적용하지 않음
+	noneFilter, err := NewSpamFilter([]SpamRule{{Name: "SPF_NONE", Type: SpamSPF, Results: []string{"none"}, Score: 1}}, resolver)
+	require.NoError(t, err)
+	assert.Empty(t, noneFilter.Check(context.Background(), message("203.0.113.6", "", "b@example.com")).Rules)
+	assert.Equal(t, []string{"SPF_NONE"}, noneFilter.Check(context.Background(), message("203.0.113.6", SPFNone, "b@example.com")).Rules)
+
+	_, err = NewSpamFilter([]SpamRule{{Name: "BAD", Type: SpamBody, Pattern: "("}}, resolver)
+	assert.Error(t, err)
+	_, err = NewSpamFilter([]SpamRule{{Name: "BAD", Type: "bayes"}}, resolver)
+	assert.Error(t, err)
+}
+
+func TestSpamAtData(t *testing.T) {
+	t.Parallel()
+
+	resolver := &MemoryResolver{}
+	resolver.SetIP("1.0.0.127.bl.example", "127.0.0.2")
+	filter, err := NewSpamFilter([]SpamRule{
+		{Name: "LOCALHOST_LISTED", Type: SpamDNSBL, Zone: "bl.example", Score: 4},
+		{Name: "SUBJECT", Type: SpamHeader, Header: "Subject", Pattern: "(?i)winner", Score: 10},
+	}, resolver)
+	require.NoError(t, err)
+	filter.Default = SpamThresholds{Tag: 3, Reject: 10}
+
+	handler := &recordingHandler{}
+	addr := startServerWithBackend(t, &Backend{Handler: handler, Spam: filter})
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+	_, delivered := handler.snapshot()
+	require.Len(t, delivered, 1)
+	raw := string(delivered[0].Raw)
+	assert.True(t, strings.HasPrefix(raw, "X-Spam-Flag: YES\r\nX-Spam-Score: 4.0\r\nX-Spam-Status: Yes, score=4.0 tests=LOCALHOST_LISTED\r\n"))
+	assert.Equal(t, []string{"YES"}, delivered[0].Parsed.Headers["X-Spam-Flag"])
+
+	err = sendMail(addr, "a@example.com", []string{"b@example.com"}, strings.Replace(testMessage, "hello", "winner!", 1))
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Equal(t, ErrSpamRejected.Message, smtpErr.Message)
+	_, delivered = handler.snapshot()
+	assert.Len(t, delivered, 1)
+}
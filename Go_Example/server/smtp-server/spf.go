@@ -0,0 +1,908 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// SPFResult는 RFC 7208의 check_host() 결과입니다
+type SPFResult string
+
+const (
+	SPFNone      SPFResult = "none"
+	SPFNeutral   SPFResult = "neutral"
+	SPFPass      SPFResult = "pass"
+	SPFFail      SPFResult = "fail"
+	SPFSoftFail  SPFResult = "softfail"
+	SPFTempError SPFResult = "temperror"
+	SPFPermError SPFResult = "permerror"
+)
+
+// SPFOutcome은 SPF 검사 한 번의 결과입니다
+type SPFOutcome struct {
+	Result SPFResult
+	// 검사한 신원: "mailfrom"이거나, MAIL FROM이 비어 있으면(바운스) "helo"
+	Identity string
+	Domain   string
+	Sender   string
+	ClientIP net.IP
+	Helo     string
+	// fail이면 exp= 설명, temperror/permerror면 원인
+	Explanation string
+}
+
+// SPFChecker는 RFC 7208에 따라 SPF 레코드를 평가합니다
+type SPFChecker struct {
+	Resolver Resolver
+	// exp의 %{r} 매크로에 쓰는 수신 서버 이름
+	Hostname string
+	// DNS 조회가 필요한 mechanism/modifier의 최대 개수 (0이면 RFC 기본값 10)
+	MaxLookups int
+	// 결과가 없는 DNS 조회의 최대 개수 (0이면 RFC 기본값 2)
+	MaxVoidLookups int
+}
+
+// Check는 ip에서 helo로 접속한 클라이언트가 sender로 보낼 수 있는지 확인합니다
+func (c *SPFChecker) Check(ctx context.Context, ip net.IP, helo, sender string) SPFOutcome {
+	outcome := SPFOutcome{Identity: "mailfrom", Sender: sender, ClientIP: ip, Helo: helo}
+
+	// 널 reverse-path(바운스)는 HELO 이름으로 검사 (RFC 7208 2.4)
+	if sender == "" {
+		outcome.Identity = "helo"
+		sender = "postmaster@" + helo
+	}
+	local, domain, ok := strings.Cut(sender, "@")
+	if !ok {
+		local, domain = "", sender
+	}
+	if local == "" {
+		sender = "postmaster@" + domain
+	}
+	outcome.Domain = domain
+
+	if ip == nil || !validSPFDomain(domain) {
+		outcome.Result = SPFNone
+		return outcome
+	}
+
+	e := &spfEval{checker: c, ctx: ctx, ip: ip, sender: sender, senderDomain: domain, helo: helo}
+	outcome.Result, outcome.Explanation = e.checkHost(domain)
+	return outcome
+}
+
+// Header는 메일 맨 앞에 붙일 Received-SPF 헤더입니다 (RFC 7208 9.1)
+func (o SPFOutcome) Header(hostname string) string {
+	comment := fmt.Sprintf("%s: domain of %s", hostname, o.Domain)
+	switch o.Result {
+	case SPFPass:
+		comment += " designates " + o.ClientIP.String() + " as permitted sender"
+	case SPFFail, SPFSoftFail:
+		comment += " does not designate " + o.ClientIP.String() + " as permitted sender"
+	case SPFNeutral:
+		comment += " neither permits nor denies " + o.ClientIP.String()
+	case SPFNone:
+		comment += " has no SPF record"
+	default:
+		comment += ": " + o.Explanation
+	}
+
+	return fmt.Sprintf("Received-SPF: %s (%s) client-ip=%s; envelope-from=%q; helo=%s; identity=%s;\r\n",
+		o.Result, comment, o.ClientIP, o.Sender, o.Helo, o.Identity)
+}
+
+// validSPFDomain은 check_host()에 넘길 수 있는 도메인인지 확인합니다 (점이 있는 FQDN)
+func validSPFDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if len(domain) == 0 || len(domain) > 253 || !strings.Contains(domain, ".") {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+	}
+	return net.ParseIP(domain) == nil
+}
+
+// spfError는 평가를 즉시 중단하는 temperror/permerror입니다
+type spfError struct {
+	result SPFResult
+	reason string
+}
+
+func (e *spfError) Error() string {
+	return string(e.result) + ": " + e.reason
+}
+
+func spfPermError(format string, args ...any) error {
+	return &spfError{result: SPFPermError, reason: fmt.Sprintf(format, args...)}
+}
+
+func spfTempError(format string, args ...any) error {
+	return &spfError{result: SPFTempError, reason: fmt.Sprintf(format, args...)}
+}
+
+// spfEval은 check_host() 한 번의 상태입니다. include/redirect는 같은 상태로 재귀하므로
+// 조회 횟수 제한이 전체에 적용됩니다.
+type spfEval struct {
+	checker      *SPFChecker
+	ctx          context.Context
+	ip           net.IP
+	sender       string
+	senderDomain string
+	helo         string
+
+	lookups int
+	voids   int
+}
+
+// spfMechanism은 레코드의 mechanism 하나입니다
+type spfMechanism struct {
+	qualifier SPFResult
+	name      string
+	// 매크로를 펼치기 전의 domain-spec (없으면 현재 도메인)
+	domain string
+	// ip4/ip6의 네트워크
+	network *net.IPNet
+	// a/mx의 prefix 길이
+	prefix4 int
+	prefix6 int
+}
+
+func (e *spfEval) checkHost(domain string) (SPFResult, string) {
+	result, explanation, err := e.evaluate(domain)
+	if err != nil {
+		spfErr := err.(*spfError)
+		return spfErr.result, spfErr.reason
+	}
+	return result, explanation
+}
+
+func (e *spfEval) evaluate(domain string) (SPFResult, string, error) {
+	record, err := e.lookupRecord(domain)
+	if err != nil {
+		return "", "", err
+	}
+	if record == "" {
+		return SPFNone, "", nil
+	}
+
+	// 문법 오류가 하나라도 있으면 평가하기 전에 permerror (RFC 7208 4.6)
+	var mechanisms []spfMechanism
+	var redirect, exp string
+	for _, term := range strings.Fields(record)[1:] {
+		mechanism, name, value, err := parseSPFTerm(term)
+		if err != nil {
+			return "", "", err
+		}
+		if mechanism != nil {
+			if mechanism.domain != "" {
+				if _, err := e.expand(mechanism.domain, domain, false); err != nil {
+					return "", "", err
+				}
+			}
+			mechanisms = append(mechanisms, *mechanism)
+			continue
+		}
+
+		switch name {
+		case "redirect", "exp":
+			if (name == "redirect" && redirect != "") || (name == "exp" && exp != "") {
+				return "", "", spfPermError("duplicate %s modifier", name)
+			}
+			if _, err := e.expand(value, domain, false); err != nil {
+				return "", "", err
+			}
+			if name == "redirect" {
+				redirect = value
+			} else {
+				exp = value
+			}
+		}
+		// 알 수 없는 modifier는 무시
+	}
+
+	for _, mechanism := range mechanisms {
+		matched, err := e.match(mechanism, domain)
+		if err != nil {
+			return "", "", err
+		}
+		if !matched {
+			continue
+		}
+		explanation := ""
+		if mechanism.qualifier == SPFFail && exp != "" {
+			explanation = e.explain(exp, domain)
+		}
+		return mechanism.qualifier, explanation, nil
+	}
+
+	// all이 있었다면 여기까지 오지 않으므로 redirect는 자연히 무시됨
+	if redirect != "" {
+		if err := e.countLookup(); err != nil {
+			return "", "", err
+		}
+		target, _ := e.expandDomain(redirect, domain)
+		result, explanation := e.checkHost(target)
+		if result == SPFNone {
+			return "", "", spfPermError("redirect target %s has no SPF record", target)
+		}
+		return result, explanation, nil
+	}
+	return SPFNeutral, "", nil
+}
+
+// lookupRecord는 domain의 SPF 레코드를 찾습니다 (없으면 "")
+func (e *spfEval) lookupRecord(domain string) (string, error) {
+	txts, err := e.checker.Resolver.LookupTXT(e.ctx, domain)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", spfTempError("TXT lookup for %s: %v", domain, err)
+	}
+
+	var records []string
+	for _, txt := range txts {
+		if len(txt) >= 6 && strings.EqualFold(txt[:6], "v=spf1") && (len(txt) == 6 || txt[6] == ' ') {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", nil
+	case 1:
+		return records[0], nil
+	default:
+		return "", spfPermError("%s has %d SPF records", domain, len(records))
+	}
+}
+
+// parseSPFTerm은 term이 mechanism이면 mechanism을, modifier면 이름과 값을 반환합니다
+func parseSPFTerm(term string) (*spfMechanism, string, string, error) {
+	// modifier: name "=" macro-string
+	nameEnd := 0
+	for nameEnd < len(term) && isSPFNameChar(term[nameEnd], nameEnd == 0) {
+		nameEnd++
+	}
+	if nameEnd > 0 && nameEnd < len(term) && term[nameEnd] == '=' {
+		return nil, strings.ToLower(term[:nameEnd]), term[nameEnd+1:], nil
+	}
+
+	mechanism := &spfMechanism{qualifier: SPFPass, prefix4: 32, prefix6: 128}
+	switch term[0] {
+	case '+':
+		term = term[1:]
+	case '-':
+		mechanism.qualifier, term = SPFFail, term[1:]
+	case '~':
+		mechanism.qualifier, term = SPFSoftFail, term[1:]
+	case '?':
+		mechanism.qualifier, term = SPFNeutral, term[1:]
+	}
+
+	nameEnd = strings.IndexAny(term, ":/")
+	if nameEnd < 0 {
+		nameEnd = len(term)
+	}
+	mechanism.name = strings.ToLower(term[:nameEnd])
+	rest := term[nameEnd:]
+	arg, hasArg := strings.CutPrefix(rest, ":")
+
+	switch mechanism.name {
+	case "all":
+		if rest != "" {
+			return nil, "", "", spfPermError("invalid term %q", term)
+		}
+	case "include", "exists":
+		if !hasArg || arg == "" {
+			return nil, "", "", spfPermError("%s requires a domain", mechanism.name)
+		}
+		mechanism.domain = arg
+	case "ptr":
+		if rest != "" && (!hasArg || arg == "") {
+			return nil, "", "", spfPermError("invalid term %q", term)
+		}
+		mechanism.domain = arg
+	case "a", "mx":
+		if rest != "" && !hasArg && rest[0] != '/' {
+			return nil, "", "", spfPermError("invalid term %q", term)
+		}
+		if !hasArg {
+			arg = rest
+		}
+		domain, prefix4, prefix6, err := parseDualCIDR(arg)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if hasArg && domain == "" {
+			return nil, "", "", spfPermError("invalid term %q", term)
+		}
+		mechanism.domain, mechanism.prefix4, mechanism.prefix6 = domain, prefix4, prefix6
+	case "ip4", "ip6":
+		if !hasArg {
+			return nil, "", "", spfPermError("%s requires an address", mechanism.name)
+		}
+		network, err := parseSPFNetwork(arg, mechanism.name == "ip6")
+		if err != nil {
+			return nil, "", "", err
+		}
+		mechanism.network = network
+	default:
+		return nil, "", "", spfPermError("unknown mechanism %q", mechanism.name)
+	}
+	return mechanism, "", "", nil
+}
+
+func isSPFNameChar(c byte, first bool) bool {
+	alpha := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	if first {
+		return alpha
+	}
+	return alpha || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+// parseDualCIDR은 "example.com/24//64"를 도메인과 IPv4, IPv6 prefix 길이로 나눕니다
+func parseDualCIDR(value string) (string, int, int, error) {
+	prefix4, prefix6 := 32, 128
+	if i := strings.Index(value, "//"); i >= 0 {
+		bits, err := parsePrefix(value[i+2:], 128)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		prefix6, value = bits, value[:i]
+	}
+	// 매크로 구분자의 '/'와 헷갈리지 않도록 숫자로 끝날 때만 prefix로 봄
+	if i := strings.LastIndex(value, "/"); i >= 0 && isDigits(value[i+1:]) {
+		bits, err := parsePrefix(value[i+1:], 32)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		prefix4, value = bits, value[:i]
+	}
+	return value, prefix4, prefix6, nil
+}
+
+func parsePrefix(value string, max int) (int, error) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || !isDigits(value) || bits > max || (len(value) > 1 && value[0] == '0') {
+		return 0, spfPermError("invalid prefix length %q", value)
+	}
+	return bits, nil
+}
+
+func parseSPFNetwork(value string, ipv6 bool) (*net.IPNet, error) {
+	address, bitsText, hasBits := strings.Cut(value, "/")
+	ip := net.ParseIP(address)
+	if ip == nil || ipv6 != strings.Contains(address, ":") {
+		return nil, spfPermError("invalid address %q", value)
+	}
+
+	size := 32
+	if ipv6 {
+		size = 128
+	} else {
+		ip = ip.To4()
+	}
+	bits := size
+	if hasBits {
+		var err error
+		if bits, err = parsePrefix(bitsText, size); err != nil {
+			return nil, err
+		}
+	}
+	mask := net.CIDRMask(bits, size)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// match는 mechanism이 클라이언트 IP와 맞는지 확인합니다 (RFC 7208 5)
+func (e *spfEval) match(mechanism spfMechanism, domain string) (bool, error) {
+	switch mechanism.name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		return mechanism.network.Contains(e.ip), nil
+
+	case "include":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target := e.target(mechanism, domain)
+		result, explanation := e.checkHost(target)
+		switch result {
+		case SPFPass:
+			return true, nil
+		case SPFFail, SPFSoftFail, SPFNeutral:
+			return false, nil
+		case SPFTempError:
+			return false, spfTempError("include:%s: %s", target, explanation)
+		default:
+			return false, spfPermError("include:%s: %s", target, result)
+		}
+
+	case "a":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target := e.target(mechanism, domain)
+		ips, err := e.lookupIP(target, true)
+		if err != nil {
+			return false, err
+		}
+		return e.matchIPs(ips, mechanism), nil
+
+	case "mx":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target := e.target(mechanism, domain)
+		mxs, err := e.checker.Resolver.LookupMX(e.ctx, target)
+		if err := e.checkLookup(target, len(mxs), err); err != nil {
+			return false, err
+		}
+		if len(mxs) > 10 {
+			return false, spfPermError("%s has more than 10 MX records", target)
+		}
+		for _, mx := range mxs {
+			ips, err := e.lookupIP(mx.Host, false)
+			if err != nil {
+				return false, err
+			}
+			if e.matchIPs(ips, mechanism) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target := e.target(mechanism, domain)
+		return e.matchPTR(target), nil
+
+	case "exists":
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		target := e.target(mechanism, domain)
+		ips, err := e.lookupIP(target, true)
+		if err != nil {
+			return false, err
+		}
+		for _, ip := range ips {
+			// exists는 클라이언트와 관계없이 A 레코드만 확인
+			if ip.To4() != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, spfPermError("unknown mechanism %q", mechanism.name)
+}
+
+// target은 mechanism의 domain-spec을 펼친 이름입니다 (생략하면 현재 도메인)
+func (e *spfEval) target(mechanism spfMechanism, domain string) string {
+	if mechanism.domain == "" {
+		return domain
+	}
+	// 문법은 평가 전에 확인했으므로 여기서는 실패하지 않음
+	target, _ := e.expandDomain(mechanism.domain, domain)
+	return target
+}
+
+// matchIPs는 클라이언트 IP가 ips 중 하나의 prefix 안에 있는지 확인합니다 (같은 주소 체계끼리만)
+func (e *spfEval) matchIPs(ips []net.IP, mechanism spfMechanism) bool {
+	client4 := e.ip.To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) != client4 {
+			continue
+		}
+		var mask net.IPMask
+		if client4 {
+			mask = net.CIDRMask(mechanism.prefix4, 32)
+			ip = ip.To4()
+		} else {
+			mask = net.CIDRMask(mechanism.prefix6, 128)
+		}
+		if (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).Contains(e.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPTR은 클라이언트 IP의 PTR 이름 중 정방향으로 확인된 이름이 target 아래에 있는지 봅니다.
+// 조회 실패는 일치하지 않는 것으로 취급합니다 (RFC 7208 5.5).
+func (e *spfEval) matchPTR(target string) bool {
+	names, err := e.checker.Resolver.LookupAddr(e.ctx, e.ip.String())
+	if err != nil {
+		return false
+	}
+	if len(names) > 10 {
+		names = names[:10]
+	}
+
+	target = dnsKey(target)
+	for _, name := range names {
+		name = dnsKey(name)
+		if name != target && !strings.HasSuffix(name, "."+target) {
+			continue
+		}
+		ips, err := e.checker.Resolver.LookupIP(e.ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(e.ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupIP는 A/AAAA 레코드를 조회합니다. mechanism 자체의 조회면 결과 없음을 void로 셉니다.
+func (e *spfEval) lookupIP(name string, countVoid bool) ([]net.IP, error) {
+	ips, err := e.checker.Resolver.LookupIP(e.ctx, name)
+	if !countVoid && isNotFound(err) {
+		return nil, nil
+	}
+	if err := e.checkLookup(name, len(ips), err); err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// checkLookup은 DNS 조회 결과를 확인합니다: 결과 없음은 void 조회로 세고, 그 밖의 오류는 temperror
+func (e *spfEval) checkLookup(name string, count int, err error) error {
+	if err != nil && !isNotFound(err) {
+		return spfTempError("lookup %s: %v", name, err)
+	}
+	if err != nil || count == 0 {
+		e.voids++
+		if e.voids > orDefault(e.checker.MaxVoidLookups, 2) {
+			return spfPermError("too many void DNS lookups")
+		}
+	}
+	return nil
+}
+
+// countLookup은 DNS 조회가 필요한 term을 셉니다 (RFC 7208 4.6.4)
+func (e *spfEval) countLookup() error {
+	e.lookups++
+	if e.lookups > orDefault(e.checker.MaxLookups, 10) {
+		return spfPermError("too many DNS lookups")
+	}
+	return nil
+}
+
+// explain은 exp= modifier로 fail 설명을 만듭니다. 어떤 오류든 설명 없이 진행합니다.
+func (e *spfEval) explain(exp, domain string) string {
+	target, err := e.expandDomain(exp, domain)
+	if err != nil {
+		return ""
+	}
+	txts, err := e.checker.Resolver.LookupTXT(e.ctx, target)
+	if err != nil || len(txts) != 1 {
+		return ""
+	}
+	explanation, err := e.expand(txts[0], domain, true)
+	if err != nil {
+		return ""
+	}
+	return explanation
+}
+
+// expandDomain은 domain-spec의 매크로를 펼치고, 253자를 넘으면 왼쪽 label부터 자릅니다
+func (e *spfEval) expandDomain(spec, domain string) (string, error) {
+	expanded, err := e.expand(spec, domain, false)
+	if err != nil {
+		return "", err
+	}
+	for len(expanded) > 253 {
+		_, rest, ok := strings.Cut(expanded, ".")
+		if !ok {
+			break
+		}
+		expanded = rest
+	}
+	return expanded, nil
+}
+
+// expand는 macro-string을 펼칩니다 (RFC 7208 7). exp가 true면 c, r, t 매크로도 허용합니다.
+func (e *spfEval) expand(spec, domain string, exp bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		i++
+		if i == len(spec) {
+			return "", spfPermError("macro: trailing %% in %q", spec)
+		}
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", spfPermError("macro: unterminated %q", spec)
+			}
+			value, err := e.macro(spec[i+1:i+end], domain, exp)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", spfPermError("macro: invalid %%%c in %q", spec[i], spec)
+		}
+	}
+	return b.String(), nil
+}
+
+// macro는 "%{" 와 "}" 사이(예: "ir", "d2", "l-")를 펼칩니다
+func (e *spfEval) macro(body, domain string, exp bool) (string, error) {
+	if body == "" {
+		return "", spfPermError("macro: empty")
+	}
+
+	letter := body[0]
+	var value string
+	switch letter | 0x20 { // 소문자로
+	case 's':
+		value = e.sender
+	case 'l':
+		value, _, _ = strings.Cut(e.sender, "@")
+	case 'o':
+		value = e.senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		value = spfIPMacro(e.ip)
+	case 'p':
+		// 검증된 PTR 이름은 비용이 커서 RFC 권고대로 쓰지 않음
+		value = "unknown"
+	case 'v':
+		value = "in-addr"
+		if e.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'h':
+		value = e.helo
+	case 'c', 'r', 't':
+		if !exp {
+			return "", spfPermError("macro: %%{%c} is only allowed in explanations", letter)
+		}
+		switch letter | 0x20 {
+		case 'c':
+			value = e.ip.String()
+		case 'r':
+			value = orDefaultString(e.checker.Hostname, "unknown")
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", spfPermError("macro: unknown letter %q", letter)
+	}
+
+	// transformers: *DIGIT ["r"], 그 뒤로 구분자
+	rest := body[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", spfPermError("macro: zero labels in %q", body)
+		}
+	}
+	rest = rest[digits:]
+	reverse := false
+	if rest != "" && rest[0]|0x20 == 'r' {
+		reverse, rest = true, rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", spfPermError("macro: invalid delimiter in %q", body)
+		}
+		delimiters = rest
+	}
+
+	if digits > 0 || reverse || delimiters != "." {
+		parts := splitAny(value, delimiters)
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+
+	// 대문자 매크로는 URL 인코딩
+	if letter >= 'A' && letter <= 'Z' {
+		value = spfURLEscape(value)
+	}
+	return value, nil
+}
+
+// spfIPMacro는 %{i}의 값입니다: IPv4는 점 표기, IPv6는 점으로 구분한 nibble
+func spfIPMacro(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// splitAny는 delimiters의 어느 문자로든 value를 나눕니다 (빈 조각도 남김)
+func splitAny(value, delimiters string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(delimiters, value[i]) >= 0 {
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func spfURLEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func orDefault(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func orDefaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// SPFAction은 SPF 결과에 따른 처리입니다
+type SPFAction string
+
+const (
+	// SPFAccept는 결과와 관계없이 받습니다 (Envelope.SPF에만 기록)
+	SPFAccept SPFAction = "accept"
+	// SPFTag는 받되 Received-SPF 헤더를 붙입니다
+	SPFTag SPFAction = "tag"
+	// SPFReject는 MAIL 단계에서 거절합니다 (temperror는 451, 나머지는 550)
+	SPFReject SPFAction = "reject"
+)
+
+var (
+	ErrSPFFail = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 23},
+		Message:      "SPF validation failed",
+	}
+	ErrSPFTempError = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 24},
+		Message:      "SPF DNS lookup failed, try again later",
+	}
+	ErrSPFPermError = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 24},
+		Message:      "SPF record of sender domain is invalid",
+	}
+)
+
+// SPFPolicy는 MAIL FROM의 SPF를 검사하고 결과별로 받을지 정합니다.
+// pass, neutral, none은 항상 받고, 나머지는 설정한 처리(비어 있으면 기본값)를 따릅니다.
+type SPFPolicy struct {
+	Checker *SPFChecker
+	// 기본값: reject
+	Fail SPFAction
+	// 기본값: tag
+	SoftFail SPFAction
+	// 기본값: tag (DNS 장애로 메일을 잃지 않도록)
+	TempError SPFAction
+	// 기본값: tag
+	PermError SPFAction
+	// 검사 한 번의 DNS 조회 제한 시간 (0이면 20초, RFC 7208 4.6.4)
+	Timeout time.Duration
+}
+
+// Action은 result에 적용할 처리를 반환합니다
+func (p *SPFPolicy) Action(result SPFResult) SPFAction {
+	var action, fallback SPFAction
+	switch result {
+	case SPFFail:
+		action, fallback = p.Fail, SPFReject
+	case SPFSoftFail:
+		action, fallback = p.SoftFail, SPFTag
+	case SPFTempError:
+		action, fallback = p.TempError, SPFTag
+	case SPFPermError:
+		action, fallback = p.PermError, SPFTag
+	default:
+		return SPFAccept
+	}
+	if action == "" {
+		return fallback
+	}
+	return action
+}
+
+// Check는 SPF를 검사하고 결과와 처리를 반환합니다
+func (p *SPFPolicy) Check(ip net.IP, helo, sender string) (SPFOutcome, SPFAction) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 20 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	outcome := p.Checker.Check(ctx, ip, helo, sender)
+	return outcome, p.Action(outcome.Result)
+}
+
+// spfRejection은 거절할 때의 SMTP 응답입니다
+func spfRejection(outcome SPFOutcome) error {
+	switch outcome.Result {
+	case SPFTempError:
+		return ErrSPFTempError
+	case SPFPermError:
+		return ErrSPFPermError
+	}
+	if outcome.Explanation != "" {
+		return &smtp.SMTPError{
+			Code:         ErrSPFFail.Code,
+			EnhancedCode: ErrSPFFail.EnhancedCode,
+			Message:      ErrSPFFail.Message + ": " + outcome.Explanation,
+		}
+	}
+	return ErrSPFFail
+}
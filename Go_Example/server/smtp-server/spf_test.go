@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spfTestZone() *MemoryResolver {
+	zone := &MemoryResolver{
+		MX: map[string][]*net.MX{
+			"mx.example": {{Host: "mail.mx.example", Pref: 10}},
+		},
+		Fail: map[string]bool{"broken.example": true},
+	}
+	zone.SetTXT("pass.example", "v=spf1 ip4:192.0.2.0/24 -all")
+	zone.SetTXT("softfail.example", "v=spf1 ip4:198.51.100.1 ~all")
+	zone.SetTXT("neutral.example", "v=spf1 ?all")
+	zone.SetTXT("include.example", "v=spf1 include:softfail.example include:pass.example -all")
+	zone.SetTXT("redirect.example", "v=spf1 redirect=pass.example")
+	zone.SetTXT("redirect-none.example", "v=spf1 redirect=nospf.example")
+	zone.SetTXT("a.example", "v=spf1 a/24 -all")
+	zone.SetIP("a.example", "192.0.2.200")
+	zone.SetTXT("mx.example", "v=spf1 mx -all")
+	zone.SetIP("mail.mx.example", "192.0.2.10", "2001:db8::25")
+	zone.SetTXT("exists.example", "v=spf1 exists:%{ir}.allow.%{d} -all")
+	zone.SetIP("10.2.0.192.allow.exists.example", "127.0.0.2")
+	zone.SetTXT("ip6.example", "v=spf1 ip6:2001:db8::/32 -all")
+	zone.SetTXT("nospf.example", "google-site-verification=abc")
+	zone.SetTXT("multi.example", "v=spf1 -all", "v=spf1 +all")
+	zone.SetTXT("syntax.example", "v=spf1 foo:bar -all")
+	zone.SetTXT("loop.example", "v=spf1 include:loop.example -all")
+	zone.SetTXT("void.example", "v=spf1 a:none1.example a:none2.example a:none3.example -all")
+	zone.SetTXT("include-broken.example", "v=spf1 include:broken.example -all")
+	zone.SetTXT("exp.example", "v=spf1 -all exp=explain.%{d}")
+	zone.SetTXT("explain.exp.example", "%{i} is not one of %{d}'s designated mail servers")
+	return zone
+}
+
+func TestSPFCheck(t *testing.T) {
+	t.Parallel()
+
+	checker := &SPFChecker{Resolver: spfTestZone()}
+
+	tests := []struct {
+		sender string
+		ip     string
+		want   SPFResult
+	}{
+		{sender: "a@pass.example", ip: "192.0.2.10", want: SPFPass},
+		{sender: "a@pass.example", ip: "203.0.113.1", want: SPFFail},
+		{sender: "a@softfail.example", ip: "203.0.113.1", want: SPFSoftFail},
+		{sender: "a@neutral.example", ip: "203.0.113.1", want: SPFNeutral},
+		{sender: "a@include.example", ip: "192.0.2.10", want: SPFPass},
+		{sender: "a@include.example", ip: "203.0.113.1", want: SPFFail},
+		{sender: "a@redirect.example", ip: "192.0.2.10", want: SPFPass},
+		{sender: "a@redirect.example", ip: "203.0.113.1", want: SPFFail},
+		{sender: "a@redirect-none.example", ip: "192.0.2.10", want: SPFPermError},
+		{sender: "a@a.example", ip: "192.0.2.77", want: SPFPass},
+		{sender: "a@a.example", ip: "192.0.3.77", want: SPFFail},
+		{sender: "a@mx.example", ip: "192.0.2.10", want: SPFPass},
+		{sender: "a@mx.example", ip: "2001:db8::25", want: SPFPass},
+		{sender: "a@mx.example", ip: "192.0.2.11", want: SPFFail},
+		{sender: "a@exists.example", ip: "192.0.2.10", want: SPFPass},
+		{sender: "a@exists.example", ip: "192.0.2.11", want: SPFFail},
+		{sender: "a@ip6.example", ip: "2001:db8::1", want: SPFPass},
+		{sender: "a@ip6.example", ip: "192.0.2.10", want: SPFFail},
+		{sender: "a@nospf.example", ip: "192.0.2.10", want: SPFNone},
+		{sender: "a@missing.example", ip: "192.0.2.10", want: SPFNone},
+		{sender: "a@multi.example", ip: "192.0.2.10", want: SPFPermError},
+		{sender: "a@syntax.example", ip: "192.0.2.10", want: SPFPermError},
+		{sender: "a@loop.example", ip: "192.0.2.10", want: SPFPermError},
+		{sender: "a@void.example", ip: "192.0.2.10", want: SPFPermError},
+		{sender: "a@broken.example", ip: "192.0.2.10", want: SPFTempError},
+		{sender: "a@include-broken.example", ip: "192.0.2.10", want: SPFTempError},
+	}
+
+	for _, tt := range tests {
+		outcome := checker.Check(context.Background(), net.ParseIP(tt.ip), "mail.client.example", tt.sender)
+		assert.Equal(t, tt.want, outcome.Result, "%s from %s: %s", tt.sender, tt.ip, outcome.Explanation)
+	}
+}
+
+func TestSPFHeloIdentityAndExplanation(t *testing.T) {
+	t.Parallel()
+
+	checker := &SPFChecker{Resolver: spfTestZone()}
+
+	// 널 reverse-path는 HELO 이름으로 검사
+	outcome := checker.Check(context.Background(), net.ParseIP("192.0.2.10"), "pass.example", "")
+	assert.Equal(t, SPFPass, outcome.Result)
+	assert.Equal(t, "helo", outcome.Identity)
+	assert.Equal(t, "pass.example", outcome.Domain)
+
+	outcome = checker.Check(context.Background(), net.ParseIP("192.0.2.10"), "x", "a@exp.example")
+	assert.Equal(t, SPFFail, outcome.Result)
+	assert.Equal(t, "192.0.2.10 is not one of exp.example's designated mail servers", outcome.Explanation)
+}
+
+// RFC 7208 7.4의 예제
+func TestSPFMacroExpansion(t *testing.T) {
+	t.Parallel()
+
+	e := &spfEval{
+		checker:      &SPFChecker{},
+		ip:           net.ParseIP("192.0.2.3"),
+		sender:       "strong-bad@email.example.com",
+		senderDomain: "email.example.com",
+		helo:         "mx.example.org",
+	}
+	domain := "email.example.com"
+
+	tests := map[string]string{
+		"%{s}":                    "strong-bad@email.example.com",
+		"%{o}":                    "email.example.com",
+		"%{d}":                    "email.example.com",
+		"%{d4}":                   "email.example.com",
+		"%{d3}":                   "email.example.com",
+		"%{d2}":                   "example.com",
+		"%{d1}":                   "com",
+		"%{dr}":                   "com.example.email",
+		"%{d2r}":                  "example.email",
+		"%{l}":                    "strong-bad",
+		"%{l-}":                   "strong.bad",
+		"%{lr}":                   "strong-bad",
+		"%{lr-}":                  "bad.strong",
+		"%{l1r-}":                 "strong",
+		"%{ir}.%{v}._spf.%{d2}":   "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":    "bad.strong.lp._spf.example.com",
+		"%{d2}.trusted-domains.x": "example.com.trusted-domains.x",
+		"%{S}":                    "strong-bad%40email.example.com",
+		"a%%b%_c%-d":              "a%b c%20d",
+	}
+	for spec, want := range tests {
+		got, err := e.expand(spec, domain, false)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, got, spec)
+	}
+
+	e.ip = net.ParseIP("2001:db8::cb01")
+	got, err := e.expand("%{ir}.%{v}._spf.%{d2}", domain, false)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com", got)
+
+	for _, spec := range []string{"%{c}", "%{x}", "%{d0}", "%{d", "%", "%a"} {
+		_, err := e.expand(spec, domain, false)
+		assert.Error(t, err, spec)
+	}
+	_, err = e.expand("%{c} %{r} %{t}", domain, true)
+	assert.NoError(t, err)
+}
+
+func TestSPFAtMail(t *testing.T) {
+	t.Parallel()
+
+	zone := &MemoryResolver{}
+	zone.SetTXT("example.com", "v=spf1 ip4:127.0.0.1 -all")
+	zone.SetTXT("bad.example", "v=spf1 -all")
+	zone.SetTXT("soft.example", "v=spf1 ~all")
+
+	handler := &recordingHandler{}
+	addr := startServerWithBackend(t, &Backend{
+		Handler: handler,
+		SPF:     &SPFPolicy{Checker: &SPFChecker{Resolver: zone}},
+	})
+
+	err := sendMail(addr, "a@bad.example", []string{"b@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Equal(t, smtp.EnhancedCode{5, 7, 23}, smtpErr.EnhancedCode)
+
+	require.NoError(t, sendMail(addr, "a@soft.example", []string{"b@example.com"}, testMessage))
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, testMessage))
+
+	_, delivered := handler.snapshot()
+	require.Len(t, delivered, 2)
+
+	// softfail은 기본 정책이 tag
+	assert.Equal(t, SPFSoftFail, delivered[0].Envelope.SPF)
+	assert.True(t, strings.HasPrefix(string(delivered[0].Raw), "Received-SPF: softfail (localhost: domain of soft.example"), string(delivered[0].Raw))
+	assert.Equal(t, "hello", delivered[0].Parsed.Subject)
+
+	assert.Equal(t, SPFPass, delivered[1].Envelope.SPF)
+	assert.NotContains(t, string(delivered[1].Raw), "Received-SPF")
+	assert.True(t, strings.HasSuffix(string(delivered[1].Raw), testMessage))
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+)
+
+// 스풀 디렉토리 구조
+//
+//	tmp/             쓰는 중인 파일 (rename 전까지는 없는 것으로 취급)
+//	msg/<id>.eml     원본 메일 (변경하지 않음)
+//	queue/<id>.json  전달 대기 중인 항목의 상태
+//	inflight/<id>.json 워커가 처리 중인 항목 (재시작 시 queue로 되돌림)
+//	dead/<id>.json, dead/<id>.eml 재시도를 포기한 항목
+//	keys/<key>       EnqueueOnce로 넣은 메일의 키 (내용은 스풀 ID, KeyTTL이 지나면 지움)
+//
+// 상태 파일이 queue에 rename되는 순간이 커밋 시점입니다.
+const (
+	spoolTmp      = "tmp"
+	spoolMsg      = "msg"
+	spoolQueue    = "queue"
+	spoolInflight = "inflight"
+	spoolDead     = "dead"
+	spoolKeys     = "keys"
+)
+
+var ErrSpoolEntryNotFound = errors.New("spool: entry not found")
+
+// spoolEntry는 스풀 항목의 상태 파일 내용입니다.
+// Envelope.To는 아직 전달하지 못한 수신자만 남고, 수신자별 결과는 Recipients에 쌓입니다.
+type spoolEntry struct {
+	ID          string            `json:"id"`
+	Envelope    Envelope          `json:"envelope"`
+	ReceivedAt  time.Time         `json:"received_at"`
+	Attempts    int               `json:"attempts"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	LastError   string            `json:"last_error,omitempty"`
+	Recipients  []RecipientStatus `json:"recipients,omitempty"`
+}
+
+// RecipientState는 수신자 하나의 전달 상태입니다
+type RecipientState string
+
+const (
+	RecipientPending   RecipientState = "pending"
+	RecipientDelivered RecipientState = "delivered"
+	RecipientFailed    RecipientState = "failed"
+)
+
+// RecipientStatus는 스풀 항목의 수신자 하나의 전달 결과입니다
+type RecipientStatus struct {
+	Recipient string         `json:"recipient"`
+	State     RecipientState `json:"state"`
+	Attempts  int            `json:"attempts"`
+	// 마지막 실패의 SMTP 응답 코드 (SMTP 응답이 아닌 실패면 0)
+	Code         int       `json:"code,omitempty"`
+	EnhancedCode string    `json:"enhanced_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// 실패를 Bounce로 알렸으면 true
+	Notified bool `json:"notified,omitempty"`
+}
+
+// update는 이번 시도의 결과를 기록합니다
+func (status *RecipientStatus) update(state RecipientState, attempts int, err error) {
+	status.State = state
+	status.Attempts = attempts
+	status.UpdatedAt = time.Now().UTC()
+	status.Code, status.EnhancedCode, status.Error = 0, "", ""
+	if err == nil {
+		return
+	}
+
+	status.Error = err.Error()
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		status.Code = smtpErr.Code
+		status.Error = smtpErr.Message
+		if smtpErr.EnhancedCode != smtp.NoEnhancedCode && smtpErr.EnhancedCode != (smtp.EnhancedCode{}) {
+			status.EnhancedCode = fmt.Sprintf("%d.%d.%d", smtpErr.EnhancedCode[0], smtpErr.EnhancedCode[1], smtpErr.EnhancedCode[2])
+		}
+	}
+}
+
+// recipient는 recipient의 상태를 반환합니다 (없으면 추가)
+func (e *spoolEntry) recipient(recipient string) *RecipientStatus {
+	for i := range e.Recipients {
+		if e.Recipients[i].Recipient == recipient {
+			return &e.Recipients[i]
+		}
+	}
+	e.Recipients = append(e.Recipients, RecipientStatus{Recipient: recipient, State: RecipientPending})
+	return &e.Recipients[len(e.Recipients)-1]
+}
+
+// unnotified는 실패했지만 아직 Bounce로 알리지 않은 수신자입니다
+func (e *spoolEntry) unnotified() []string {
+	var recipients []string
+	for _, status := range e.Recipients {
+		if status.State == RecipientFailed && !status.Notified {
+			recipients = append(recipients, status.Recipient)
+		}
+	}
+	return recipients
+}
+
+// Spool은 수신한 메일을 디스크에 먼저 저장하고, 워커가 핸들러에 전달합니다 (at-least-once).
+// 핸들러가 실패하면 지수 백오프로 재시도하고, 영구 실패나 최대 재시도를 넘기면 dead로 옮깁니다.
+type Spool struct {
+	Dir     string
+	Handler MessageHandler
+
+	Workers      int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+	// EnqueueOnce의 키를 기억하는 기간 (0이면 지우지 않음)
+	KeyTTL time.Duration
+
+	// 설정하면 수신자가 영구 실패하거나 재시도를 다 썼을 때 호출합니다 (예: 반송 메일 생성).
+	// 성공하면 항목을 dead로 옮기지 않고 끝냅니다.
+	Bounce func(ctx context.Context, envelope Envelope, raw []byte, failed []RecipientStatus) error
+
+	wake chan struct{}
+	wg   sync.WaitGroup
+
+	mu sync.Mutex
+	// 전달 시각이 된 큐 파일 이름 (워커가 나눠 가짐, 비면 큐를 다시 훑음)
+	due []string
+	// 큐 파일 이름 → 전달 시각 (파일이 바뀌지 않았으면 다시 읽지 않음)
+	schedule map[string]spoolSchedule
+}
+
+// spoolSchedule은 큐 파일 하나의 전달 시각입니다
+type spoolSchedule struct {
+	info        os.FileInfo
+	nextAttempt time.Time
+	// 읽을 수 없는 파일 (바뀔 때까지 건너뜀)
+	invalid bool
+}
+
+// NewSpool은 dir에 스풀 디렉토리를 만들고 기본 설정의 Spool을 반환합니다
+func NewSpool(dir string, handler MessageHandler) (*Spool, error) {
+	for _, sub := range []string{spoolTmp, spoolMsg, spoolQueue, spoolInflight, spoolDead, spoolKeys} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Spool{
+		Dir:          dir,
+		Handler:      handler,
+		Workers:      4,
+		MaxAttempts:  10,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		PollInterval: time.Second,
+		KeyTTL:       24 * time.Hour,
+		wake:         make(chan struct{}, 1),
+	}, nil
+}
+
+// Enqueue는 메일을 스풀에 원자적으로 저장합니다. 반환한 뒤에는 프로세스가 죽어도 메일이 남습니다.
+func (s *Spool) Enqueue(envelope Envelope, raw []byte) (string, error) {
+	return s.EnqueueReader(envelope, bytes.NewReader(raw))
+}
+
+// EnqueueOnce는 key로 이미 넣은 메일이면 다시 넣지 않고 그때의 ID와 true를 반환합니다 (key가 ""이면 Enqueue와 같음).
+// 이미 전달을 끝낸 항목도 KeyTTL 동안은 기억합니다. 키를 쓰기 전에 프로세스가 죽으면 한 번 더 들어갈 수 있습니다 (at-least-once).
+func (s *Spool) EnqueueOnce(key string, envelope Envelope, raw []byte) (id string, duplicate bool, err error) {
+	if key == "" {
+		id, err = s.Enqueue(envelope, raw)
+		return id, false, err
+	}
+	if data, err := os.ReadFile(s.path(spoolKeys, key)); err == nil {
+		return string(data), true, nil
+	}
+
+	id, err = s.Enqueue(envelope, raw)
+	if err != nil {
+		return "", false, err
+	}
+	if err := s.writeAtomic(filepath.Join(spoolKeys, key), []byte(id)); err != nil {
+		slog.Warn("스풀 키 저장 실패", "spool_id", id, "error", err)
+	}
+	return id, false, nil
+}
+
+// EnqueueReader는 r을 메모리에 모으지 않고 스풀 파일로 바로 써서 Enqueue합니다.
+// r이 에러를 반환하면 (크기 초과 등) 쓰던 파일을 지우고 그 에러를 그대로 반환합니다.
+func (s *Spool) EnqueueReader(envelope Envelope, r io.Reader) (string, error) {
+	id, err := newSpoolID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.writeAtomicFrom(filepath.Join(spoolMsg, id+".eml"), r); err != nil {
+		return "", err
+	}
+
+	entry := spoolEntry{
+		ID:          id,
+		Envelope:    envelope,
+		ReceivedAt:  time.Now().UTC(),
+		NextAttempt: time.Now().UTC(),
+	}
+	for _, recipient := range envelope.To {
+		entry.recipient(recipient)
+	}
+	if err := s.writeEntry(spoolQueue, entry); err != nil {
+		os.Remove(s.path(spoolMsg, id+".eml"))
+		return "", err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+// Start는 처리 중이던 항목을 복구하고 워커를 시작합니다. ctx가 취소되면 워커가 멈춥니다.
+func (s *Spool) Start(ctx context.Context) error {
+	if err := s.recover(); err != nil {
+		return err
+	}
+
+	for i := 0; i < s.Workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.work(ctx)
+		}()
+	}
+	if s.KeyTTL > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.expireKeys(ctx)
+		}()
+	}
+	return nil
+}
+
+// expireKeys는 KeyTTL이 지난 EnqueueOnce 키를 주기적으로 지웁니다
+func (s *Spool) expireKeys(ctx context.Context) {
+	ticker := time.NewTicker(min(s.KeyTTL/2, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		files, err := os.ReadDir(s.path(spoolKeys))
+		if err != nil {
+			slog.Error("스풀 키 읽기 실패", "error", err)
+		}
+		for _, file := range files {
+			if info, err := file.Info(); err == nil && time.Since(info.ModTime()) > s.KeyTTL {
+				os.Remove(s.path(spoolKeys, file.Name()))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wait는 ctx 취소 후 워커가 처리 중인 항목을 끝낼 때까지 기다립니다
+func (s *Spool) Wait() {
+	s.wg.Wait()
+}
+
+// recover는 이전 프로세스가 처리하다 만 inflight 항목을 queue로 되돌리고,
+// 상태 파일 없이 남은 원본 메일(커밋 전에 죽은 경우)과 tmp 파일을 지웁니다.
+func (s *Spool) recover() error {
+	inflight, err := os.ReadDir(s.path(spoolInflight))
+	if err != nil {
+		return err
+	}
+	for _, file := range inflight {
+		slog.Info("스풀 복구", "file", file.Name())
+		if err := os.Rename(s.path(spoolInflight, file.Name()), s.path(spoolQueue, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.ReadDir(s.path(spoolTmp))
+	if err != nil {
+		return err
+	}
+	for _, file := range tmp {
+		os.Remove(s.path(spoolTmp, file.Name()))
+	}
+
+	messages, err := os.ReadDir(s.path(spoolMsg))
+	if err != nil {
+		return err
+	}
+	for _, file := range messages {
+		id := strings.TrimSuffix(file.Name(), ".eml")
+		if _, err := os.Stat(s.path(spoolQueue, id+".json")); errors.Is(err, os.ErrNotExist) {
+			os.Remove(s.path(spoolMsg, file.Name()))
+		}
+	}
+
+	return syncDir(s.path(spoolQueue))
+}
+
+func (s *Spool) work(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// 처리할 게 있는 동안은 쉬지 않고 처리
+		for ctx.Err() == nil && s.processNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext는 전달 시각이 된 항목 하나를 처리하고, 처리했으면 true를 반환합니다
+func (s *Spool) processNext(ctx context.Context) bool {
+	for {
+		name, ok := s.nextDue()
+		if !ok {
+			return false
+		}
+
+		// rename에 성공한 워커만 항목을 가져감
+		if err := os.Rename(s.path(spoolQueue, name), s.path(spoolInflight, name)); err != nil {
+			continue
+		}
+		// 훑은 뒤에 다른 워커가 처리하고 다시 써 넣었을 수 있으므로 가져온 파일로 다시 확인
+		entry, err := s.readEntry(spoolInflight, name)
+		if err != nil || entry.NextAttempt.After(time.Now()) {
+			if err != nil {
+				slog.Error("스풀 상태 읽기 실패", "file", name, "error", err)
+			}
+			if err := os.Rename(s.path(spoolInflight, name), s.path(spoolQueue, name)); err != nil {
+				slog.Error("스풀 항목 되돌리기 실패", "file", name, "error", err)
+			}
+			s.forget(name)
+			continue
+		}
+
+		s.deliver(ctx, entry)
+		return true
+	}
+}
+
+// nextDue는 전달 시각이 된 큐 파일 이름을 하나 꺼냅니다. 꺼낼 것이 없으면 큐를 다시 훑습니다.
+func (s *Spool) nextDue() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.due) == 0 {
+		s.scan()
+	}
+	if len(s.due) == 0 {
+		return "", false
+	}
+	name := s.due[0]
+	s.due = s.due[1:]
+	return name, true
+}
+
+// scan은 큐에서 전달 시각이 된 항목을 오래된 순서로 due에 담습니다.
+// 상태 파일은 항상 rename으로 바뀌므로 같은 파일(inode와 수정 시각)이면 다시 읽지 않습니다.
+// 파일 시각은 해상도가 낮으므로, 스풀이 직접 큐에 쓴 파일은 forget으로 기록을 지웁니다.
+func (s *Spool) scan() {
+	files, err := os.ReadDir(s.path(spoolQueue))
+	if err != nil {
+		slog.Error("스풀 읽기 실패", "error", err)
+		return
+	}
+
+	now := time.Now()
+	schedule := make(map[string]spoolSchedule, len(files))
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		scheduled, ok := s.schedule[file.Name()]
+		if !ok || !os.SameFile(scheduled.info, info) || !scheduled.info.ModTime().Equal(info.ModTime()) {
+			entry, err := s.readEntry(spoolQueue, file.Name())
+			scheduled = spoolSchedule{info: info, nextAttempt: entry.NextAttempt, invalid: err != nil}
+		}
+		schedule[file.Name()] = scheduled
+		if !scheduled.invalid && !scheduled.nextAttempt.After(now) {
+			s.due = append(s.due, file.Name())
+		}
+	}
+	s.schedule = schedule
+	sort.Slice(s.due, func(i, j int) bool {
+		return schedule[s.due[i]].nextAttempt.Before(schedule[s.due[j]].nextAttempt)
+	})
+}
+
+// forget은 큐 파일 name의 전달 시각 기록을 지워서 다음에 훑을 때 다시 읽게 합니다
+func (s *Spool) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.schedule, name)
+}
+
+func (s *Spool) deliver(ctx context.Context, entry spoolEntry) {
+	raw, err := os.ReadFile(s.path(spoolMsg, entry.ID+".eml"))
+	if err == nil {
+		err = s.handle(ctx, entry, raw)
+	}
+	entry.Attempts++
+	if err != nil {
+		entry.LastError = err.Error()
+	}
+	exhausted := entry.Attempts >= s.MaxAttempts
+
+	// 수신자별로 결과를 나눠서, 일시적으로 실패한 수신자만 다음 시도에 남김
+	var pending []string
+	var failed []RecipientStatus
+	for _, recipient := range entry.Envelope.To {
+		status := entry.recipient(recipient)
+		rcptErr := recipientError(err, recipient)
+		switch {
+		case rcptErr == nil:
+			status.update(RecipientDelivered, entry.Attempts, nil)
+		case isPermanent(rcptErr) || exhausted:
+			status.update(RecipientFailed, entry.Attempts, rcptErr)
+			failed = append(failed, *status)
+		default:
+			status.update(RecipientPending, entry.Attempts, rcptErr)
+			pending = append(pending, recipient)
+		}
+	}
+	if len(failed) > 0 && s.Bounce != nil {
+		s.bounce(ctx, &entry, raw, failed)
+	}
+
+	// 수신자가 없는 항목은 메일 전체의 결과로 판단
+	retry := len(pending) > 0
+	if len(entry.Envelope.To) == 0 {
+		retry = err != nil && !isPermanent(err) && !exhausted
+	}
+
+	switch {
+	case retry:
+		entry.Envelope.To = pending
+		entry.NextAttempt = time.Now().UTC().Add(s.backoff(entry.Attempts))
+		slog.Warn("스풀 전달 실패", "spool_id", entry.ID, "session", entry.Envelope.SessionID, "attempts", entry.Attempts, "next_attempt", entry.NextAttempt, "error", err)
+		if err := s.writeEntry(spoolQueue, entry); err != nil {
+			// inflight에 남겨두면 다음 시작 때 복구됨
+			slog.Error("스풀 상태 저장 실패", "spool_id", entry.ID, "error", err)
+			return
+		}
+		os.Remove(s.path(spoolInflight, entry.ID+".json"))
+
+	case len(entry.unnotified()) > 0 || (len(entry.Envelope.To) == 0 && err != nil):
+		// dead에서 수동으로 다시 보낼 때는 알리지 못한 수신자에게만
+		if unnotified := entry.unnotified(); len(unnotified) > 0 {
+			entry.Envelope.To = unnotified
+		}
+		slog.Error("스풀 전달 포기", "spool_id", entry.ID, "session", entry.Envelope.SessionID, "attempts", entry.Attempts, "error", err)
+		s.moveToDead(entry)
+
+	default:
+		if err != nil {
+			slog.Warn("스풀 전달 실패 (반송함)", "spool_id", entry.ID, "session", entry.Envelope.SessionID, "attempts", entry.Attempts, "error", err)
+		}
+		os.Remove(s.path(spoolMsg, entry.ID+".eml"))
+		os.Remove(s.path(spoolInflight, entry.ID+".json"))
+	}
+}
+
+// bounce는 실패한 수신자를 Bounce로 알리고, 성공하면 Notified로 표시합니다
+func (s *Spool) bounce(ctx context.Context, entry *spoolEntry, raw []byte, failed []RecipientStatus) {
+	if err := s.Bounce(ctx, entry.Envelope, raw, failed); err != nil {
+		slog.Error("반송 실패", "spool_id", entry.ID, "session", entry.Envelope.SessionID, "error", err)
+		return
+	}
+	for _, status := range failed {
+		entry.recipient(status.Recipient).Notified = true
+	}
+}
+
+func (s *Spool) handle(ctx context.Context, entry spoolEntry, raw []byte) error {
+	parsed, err := parsers.ParseEmail(string(raw))
+	if err != nil {
+		return PermanentError("Malformed message: " + err.Error())
+	}
+
+	return s.Handler.HandleMessage(ctx, &Message{
+		Envelope: entry.Envelope,
+		Raw:      raw,
+		Parsed:   parsed,
+	})
+}
+
+// Status는 스풀 항목의 수신자별 전달 상태를 반환합니다.
+// 모든 수신자의 처리가 끝나서 지워진 항목은 ErrSpoolEntryNotFound입니다 (dead로 옮긴 항목은 남음).
+func (s *Spool) Status(id string) ([]RecipientStatus, error) {
+	for _, dir := range []string{spoolQueue, spoolInflight, spoolDead} {
+		entry, err := s.readEntry(dir, id+".json")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return entry.Recipients, nil
+	}
+	return nil, ErrSpoolEntryNotFound
+}
+
+func (s *Spool) moveToDead(entry spoolEntry) {
+	if err := os.Rename(s.path(spoolMsg, entry.ID+".eml"), s.path(spoolDead, entry.ID+".eml")); err != nil {
+		slog.Error("dead로 이동 실패", "spool_id", entry.ID, "error", err)
+	}
+	if err := s.writeEntry(spoolDead, entry); err != nil {
+		slog.Error("dead로 이동 실패", "spool_id", entry.ID, "error", err)
+		return
+	}
+	os.Remove(s.path(spoolInflight, entry.ID+".json"))
+}
+
+// backoff는 attempts번 실패한 뒤의 재시도 간격입니다 (BaseDelay * 2^(attempts-1), 최대 MaxDelay)
+func (s *Spool) backoff(attempts int) time.Duration {
+	delay := s.BaseDelay
+	for i := 1; i < attempts && delay < s.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.MaxDelay {
+		delay = s.MaxDelay
+	}
+	return delay
+}
+
+func (s *Spool) readEntry(dir, name string) (spoolEntry, error) {
+	var entry spoolEntry
+	data, err := os.ReadFile(s.path(dir, name))
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+func (s *Spool) writeEntry(dir string, entry spoolEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := s.writeAtomic(filepath.Join(dir, entry.ID+".json"), data); err != nil {
+		return err
+	}
+	if dir == spoolQueue {
+		s.forget(entry.ID + ".json")
+	}
+	return nil
+}
+
+// writeAtomic은 tmp에 쓰고 fsync한 뒤 rename해서, name이 항상 완전한 내용이거나 없도록 합니다
+func (s *Spool) writeAtomic(name string, data []byte) error {
+	return s.writeAtomicFrom(name, bytes.NewReader(data))
+}
+
+// writeAtomicFrom은 writeAtomic과 같지만 r의 내용을 씁니다
+func (s *Spool) writeAtomicFrom(name string, r io.Reader) error {
+	file, err := os.CreateTemp(s.path(spoolTmp), "*")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	target := filepath.Join(s.Dir, name)
+	if err := os.Rename(tmpName, target); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return syncDir(filepath.Dir(target))
+}
+
+func (s *Spool) path(elem ...string) string {
+	return filepath.Join(append([]string{s.Dir}, elem...)...)
+}
+
+// syncDir은 rename이 디스크에 반영되도록 디렉토리를 fsync합니다
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// newSpoolID는 시간순으로 정렬되는 스풀 항목 ID를 만듭니다
+func newSpoolID() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(random)), nil
+}
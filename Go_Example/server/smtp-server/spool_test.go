@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler는 정해진 에러를 차례로 반환하고, 성공한 메일을 기록합니다
+type recordingHandler struct {
+	mu        sync.Mutex
+	errs      []error
+	calls     int
+	delivered []*Message
+}
+
+func (h *recordingHandler) HandleMessage(_ context.Context, msg *Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.calls++
+	if len(h.errs) > 0 {
+		err := h.errs[0]
+		h.errs = h.errs[1:]
+		return err
+	}
+	h.delivered = append(h.delivered, msg)
+	return nil
+}
+
+func (h *recordingHandler) snapshot() (calls int, delivered []*Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls, append([]*Message(nil), h.delivered...)
+}
+
+func newTestSpool(t *testing.T, dir string, handler MessageHandler) *Spool {
+	t.Helper()
+
+	spool, err := NewSpool(dir, handler)
+	require.NoError(t, err)
+	spool.BaseDelay = 10 * time.Millisecond
+	spool.MaxDelay = 50 * time.Millisecond
+	spool.PollInterval = 5 * time.Millisecond
+	spool.MaxAttempts = 3
+	return spool
+}
+
+func startSpool(t *testing.T, spool *Spool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, spool.Start(ctx))
+	t.Cleanup(func() {
+		cancel()
+		spool.Wait()
+	})
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	return len(files)
+}
+
+func TestSpoolRetriesThenDelivers(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{errs: []error{errors.New("db is down"), TemporaryError("busy")}}
+	spool := newTestSpool(t, t.TempDir(), handler)
+	startSpool(t, spool)
+
+	_, err := spool.Enqueue(Envelope{From: "a@example.com", To: []string{"b@example.com"}}, []byte(testMessage))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	calls, delivered := handler.snapshot()
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "hello", delivered[0].Parsed.Subject)
+	assert.Equal(t, []string{"b@example.com"}, delivered[0].Envelope.To)
+	assert.Zero(t, countFiles(t, filepath.Join(spool.Dir, spoolMsg)))
+	assert.Zero(t, countFiles(t, filepath.Join(spool.Dir, spoolQueue)))
+}
+
+func TestSpoolDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+	}{
+		{name: "permanent failure", errs: []error{PermanentError("no such mailbox")}, wantCalls: 1},
+		{name: "too many attempts", errs: []error{TemporaryError("1"), TemporaryError("2"), TemporaryError("3")}, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &recordingHandler{errs: tt.errs}
+			spool := newTestSpool(t, t.TempDir(), handler)
+			startSpool(t, spool)
+
+			id, err := spool.Enqueue(Envelope{From: "a@example.com"}, []byte(testMessage))
+			require.NoError(t, err)
+
+			dead := filepath.Join(spool.Dir, spoolDead, id+".json")
+			require.Eventually(t, func() bool {
+				_, err := os.Stat(dead)
+				return err == nil
+			}, 5*time.Second, 5*time.Millisecond)
+
+			calls, _ := handler.snapshot()
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.FileExists(t, filepath.Join(spool.Dir, spoolDead, id+".eml"))
+		})
+	}
+}
+
+func TestSpoolRecoversInflight(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// 워커 없이 저장만 하고, 처리 도중 죽은 것처럼 inflight로 옮김
+	crashed := newTestSpool(t, dir, &recordingHandler{})
+	id, err := crashed.Enqueue(Envelope{From: "a@example.com"}, []byte(testMessage))
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(filepath.Join(dir, spoolQueue, id+".json"), filepath.Join(dir, spoolInflight, id+".json")))
+	// 커밋 전에 죽어서 상태 파일 없이 남은 원본
+	require.NoError(t, os.WriteFile(filepath.Join(dir, spoolMsg, "orphan.eml"), []byte(testMessage), 0o600))
+
+	handler := &recordingHandler{}
+	startSpool(t, newTestSpool(t, dir, handler))
+
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.NoFileExists(t, filepath.Join(dir, spoolMsg, "orphan.eml"))
+}
+
+func TestSpoolSkipsEntryRescheduledAfterScan(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	handler := &recordingHandler{}
+	spool := newTestSpool(t, dir, handler)
+	id, err := spool.Enqueue(Envelope{From: "a@example.com", To: []string{"b@example.com", "c@example.com"}}, []byte(testMessage))
+	require.NoError(t, err)
+
+	// 이 워커가 큐를 훑은 뒤에 다른 워커가 처리하고 다음 시도로 다시 써 넣음
+	spool.mu.Lock()
+	spool.scan()
+	spool.mu.Unlock()
+	entry, err := spool.readEntry(spoolQueue, id+".json")
+	require.NoError(t, err)
+	entry.Envelope.To = []string{"c@example.com"}
+	entry.Attempts = 1
+	entry.NextAttempt = time.Now().Add(time.Hour)
+	require.NoError(t, spool.writeEntry(spoolQueue, entry))
+
+	// 훑을 때의 상태로 보내지 않고 큐에 되돌림
+	assert.False(t, spool.processNext(context.Background()))
+	calls, _ := handler.snapshot()
+	assert.Zero(t, calls)
+	requeued, err := spool.readEntry(spoolQueue, id+".json")
+	require.NoError(t, err)
+	assert.Equal(t, entry.Envelope.To, requeued.Envelope.To)
+	assert.Equal(t, 1, requeued.Attempts)
+	assert.Zero(t, countFiles(t, filepath.Join(dir, spoolInflight)))
+
+	// 전달 시각이 되면 남은 수신자에게만
+	entry.NextAttempt = time.Now()
+	require.NoError(t, spool.writeEntry(spoolQueue, entry))
+	assert.True(t, spool.processNext(context.Background()))
+	_, delivered := handler.snapshot()
+	require.Len(t, delivered, 1)
+	assert.Equal(t, []string{"c@example.com"}, delivered[0].Envelope.To)
+}
+
+func TestSpoolWorkersDeliverOnce(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{}
+	spool := newTestSpool(t, t.TempDir(), handler)
+	spool.Workers = 8
+	for i := 0; i < 50; i++ {
+		_, err := spool.Enqueue(Envelope{From: fmt.Sprintf("a%d@example.com", i), To: []string{"b@example.com"}}, []byte(testMessage))
+		require.NoError(t, err)
+	}
+	startSpool(t, spool)
+
+	require.Eventually(t, func() bool {
+		_, delivered := handler.snapshot()
+		return len(delivered) >= 50
+	}, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	calls, delivered := handler.snapshot()
+	assert.Equal(t, 50, calls)
+	senders := map[string]bool{}
+	for _, msg := range delivered {
+		senders[msg.Envelope.From] = true
+	}
+	assert.Len(t, senders, 50)
+}
+
+func TestSpoolPerRecipientStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, withBounce := range []bool{true, false} {
+		handler := &recordingHandler{errs: []error{RecipientErrors{
+			"b@example.com": TemporaryError("mailbox busy"),
+			"c@example.com": PermanentError("no such mailbox"),
+		}}}
+		spool := newTestSpool(t, t.TempDir(), handler)
+
+		var mu sync.Mutex
+		var bounced []RecipientStatus
+		if withBounce {
+			spool.Bounce = func(_ context.Context, _ Envelope, _ []byte, failed []RecipientStatus) error {
+				mu.Lock()
+				defer mu.Unlock()
+				bounced = append(bounced, failed...)
+				return nil
+			}
+		}
+
+		id, err := spool.Enqueue(Envelope{From: "a@example.com", To: []string{"a@example.com", "b@example.com", "c@example.com"}}, []byte(testMessage))
+		require.NoError(t, err)
+		statuses, err := spool.Status(id)
+		require.NoError(t, err)
+		require.Len(t, statuses, 3)
+		assert.Equal(t, RecipientPending, statuses[0].State)
+
+		startSpool(t, spool)
+		require.Eventually(t, func() bool {
+			_, delivered := handler.snapshot()
+			return len(delivered) == 1
+		}, 5*time.Second, 5*time.Millisecond)
+
+		// 일시적으로 실패한 수신자만 재시도
+		_, delivered := handler.snapshot()
+		assert.Equal(t, []string{"b@example.com"}, delivered[0].Envelope.To)
+
+		if withBounce {
+			require.Eventually(t, func() bool {
+				_, err := spool.Status(id)
+				return errors.Is(err, ErrSpoolEntryNotFound)
+			}, 5*time.Second, 5*time.Millisecond)
+			mu.Lock()
+			require.Len(t, bounced, 1)
+			assert.Equal(t, "c@example.com", bounced[0].Recipient)
+			assert.Equal(t, 550, bounced[0].Code)
+			assert.Equal(t, "5.7.1", bounced[0].EnhancedCode)
+			mu.Unlock()
+			continue
+		}
+
+		// Bounce가 없으면 알리지 못한 수신자만 남겨서 dead로
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(filepath.Join(spool.Dir, spoolDead, id+".json"))
+			return err == nil
+		}, 5*time.Second, 5*time.Millisecond)
+		entry, err := spool.readEntry(spoolDead, id+".json")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c@example.com"}, entry.Envelope.To)
+		statuses, err = spool.Status(id)
+		require.NoError(t, err)
+		want := map[string]RecipientState{"a@example.com": RecipientDelivered, "b@example.com": RecipientDelivered, "c@example.com": RecipientFailed}
+		for _, status := range statuses {
+			assert.Equal(t, want[status.Recipient], status.State, status.Recipient)
+		}
+	}
+}
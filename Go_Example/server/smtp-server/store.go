@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrMessageNotFound = errors.New("message store: message not found")
+
+// postgresMessageMigrations는 PostgresMessageStore.Migrate가 순서대로 적용하는 스키마 변경입니다.
+// 이미 배포한 항목은 고치지 말고 뒤에 새 항목을 추가합니다 (버전은 1부터 시작하는 인덱스).
+var postgresMessageMigrations = []string{
+	`CREATE TABLE smtp_messages (
+		id          bigserial PRIMARY KEY,
+		received_at timestamptz NOT NULL,
+		session_id  text NOT NULL,
+		mail_from   text NOT NULL,
+		remote_ip   text NOT NULL,
+		helo        text NOT NULL,
+		spf         text NOT NULL,
+		message_id  text NOT NULL,
+		header_from text NOT NULL,
+		subject     text NOT NULL,
+		headers     jsonb NOT NULL,
+		text_body   text NOT NULL,
+		html_body   text NOT NULL,
+		raw         bytea NOT NULL,
+		size        integer NOT NULL
+	);
+	CREATE INDEX smtp_messages_received_at ON smtp_messages (received_at);
+
+	CREATE TABLE smtp_message_recipients (
+		message_id bigint NOT NULL REFERENCES smtp_messages (id) ON DELETE CASCADE,
+		address    text NOT NULL,
+		PRIMARY KEY (message_id, address)
+	);
+	CREATE INDEX smtp_message_recipients_address ON smtp_message_recipients (address, message_id);
+
+	CREATE TABLE smtp_attachments (
+		sha256 text PRIMARY KEY,
+		size   integer NOT NULL,
+		data   bytea NOT NULL
+	);
+
+	CREATE TABLE smtp_message_attachments (
+		message_id   bigint NOT NULL REFERENCES smtp_messages (id) ON DELETE CASCADE,
+		position     integer NOT NULL,
+		sha256       text NOT NULL REFERENCES smtp_attachments (sha256),
+		filename     text NOT NULL,
+		content_type text NOT NULL,
+		PRIMARY KEY (message_id, position)
+	);
+	CREATE INDEX smtp_message_attachments_sha256 ON smtp_message_attachments (sha256);`,
+	// 재시도로 다시 받은 메일을 한 번만 저장 (Message.DedupKey, 이전에 저장한 메일은 NULL)
+	`ALTER TABLE smtp_messages ADD COLUMN dedup_key text;
+	CREATE UNIQUE INDEX smtp_messages_dedup_key ON smtp_messages (dedup_key);`,
+}
+
+// StoredMessage는 저장소에 있는 메일 한 통입니다.
+// List는 요약(봉투, 제목 등)만 채우고, Get은 헤더, 본문, 원본, 첨부파일 목록까지 채웁니다.
+type StoredMessage struct {
+	ID         int64     `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Envelope   Envelope  `json:"envelope"`
+	MessageID  string    `json:"message_id"`
+	From       string    `json:"from"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+
+	Headers     map[string][]string `json:"headers,omitempty"`
+	TextBody    string              `json:"text_body,omitempty"`
+	HTMLBody    string              `json:"html_body,omitempty"`
+	Raw         []byte              `json:"-"`
+	Attachments []StoredAttachment  `json:"attachments,omitempty"`
+}
+
+// StoredAttachment는 메일에 붙은 첨부파일 하나입니다 (내용은 SHA-256으로 Attachment에서 조회)
+type StoredAttachment struct {
+	SHA256      string `json:"sha256"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// MessageQuery는 List와 DeleteMatching의 조건입니다. 빈 필드는 조건에서 빠집니다.
+type MessageQuery struct {
+	// 봉투 수신자 (대소문자 구분 없음)
+	Recipient string
+	// 받은 시각 범위 [Since, Until)
+	Since time.Time
+	Until time.Time
+	// 이 ID보다 작은 메일만 (페이지네이션: 이전 페이지의 마지막 ID)
+	BeforeID int64
+	// 최대 개수 (List 전용, 기본값 50)
+	Limit int
+}
+
+// where는 q의 조건을 SQL WHERE 절로 만들고, 자리표시자 값을 args에 덧붙입니다
+func (q MessageQuery) where(args *[]any) string {
+	var conditions []string
+	arg := func(value any) string {
+		*args = append(*args, value)
+		return "$" + strconv.Itoa(len(*args))
+	}
+
+	if q.Recipient != "" {
+		conditions = append(conditions, "m.id IN (SELECT message_id FROM smtp_message_recipients WHERE address = "+arg(strings.ToLower(q.Recipient))+")")
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "m.received_at >= "+arg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "m.received_at < "+arg(q.Until))
+	}
+	if q.BeforeID > 0 {
+		conditions = append(conditions, "m.id < "+arg(q.BeforeID))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// PostgresMessageStore는 받은 메일을 Postgres에 저장하는 MessageHandler입니다.
+// 첨부파일은 SHA-256으로 중복을 제거해서 한 번만 저장합니다.
+type PostgresMessageStore struct {
+	DB *sql.DB
+}
+
+// Migrate는 아직 적용하지 않은 스키마 변경을 한 트랜잭션에서 적용합니다.
+// advisory lock으로 여러 인스턴스가 동시에 시작해도 한 번만 적용됩니다.
+func (s *PostgresMessageStore) Migrate(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('smtp_message_migrations'))`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS smtp_message_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM smtp_message_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(postgresMessageMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMessageMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO smtp_message_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresMessageStore) HandleMessage(ctx context.Context, msg *Message) error {
+	_, err := s.Save(ctx, msg, time.Now())
+	return err
+}
+
+// Save는 메일을 저장하고 ID를 반환합니다.
+// 같은 메일(Message.DedupKey)을 이미 저장했으면 새로 저장하지 않고 그 ID를 반환합니다.
+func (s *PostgresMessageStore) Save(ctx context.Context, msg *Message, receivedAt time.Time) (int64, error) {
+	// NUL 문자와 잘못된 UTF-8은 jsonb에 넣을 수 없으므로 헤더 값에서 정리
+	cleanHeaders := make(map[string][]string, len(msg.Parsed.Headers))
+	for key, values := range msg.Parsed.Headers {
+		for _, value := range values {
+			cleanHeaders[key] = append(cleanHeaders[key], pgText(value))
+		}
+	}
+	headers, err := json.Marshal(cleanHeaders)
+	if err != nil {
+		return 0, err
+	}
+	messageID := ""
+	if values := msg.Parsed.Headers["Message-Id"]; len(values) > 0 {
+		messageID = values[0]
+	}
+	remoteIP := ""
+	if msg.Envelope.RemoteIP != nil {
+		remoteIP = msg.Envelope.RemoteIP.String()
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	dedupKey := sql.NullString{String: msg.DedupKey()}
+	dedupKey.Valid = dedupKey.String != ""
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO smtp_messages (
+			received_at, session_id, mail_from, remote_ip, helo, spf,
+			message_id, header_from, subject, headers, text_body, html_body, raw, size, dedup_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (dedup_key) DO NOTHING
+		RETURNING id`,
+		receivedAt, msg.Envelope.SessionID, pgText(msg.Envelope.From), remoteIP, pgText(msg.Envelope.Helo), string(msg.Envelope.SPF),
+		pgText(messageID), pgText(msg.Parsed.From), pgText(msg.Parsed.Subject), string(headers),
+		pgText(msg.Parsed.TextBody), pgText(msg.Parsed.HTMLBody), msg.Raw, len(msg.Raw), dedupKey,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 이미 저장한 메일 (뒤의 핸들러가 실패해서 클라이언트가 다시 보낸 경우)
+		err = tx.QueryRowContext(ctx, `SELECT id FROM smtp_messages WHERE dedup_key = $1`, dedupKey).Scan(&id)
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, recipient := range msg.Envelope.To {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO smtp_message_recipients (message_id, address) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			id, pgText(strings.ToLower(recipient))); err != nil {
+			return 0, err
+		}
+	}
+
+	for i, attachment := range msg.Parsed.Attachments {
+		// DO UPDATE로 행을 잠가서, 커밋 전에 Delete가 고아 첨부파일로 보고 지우지 못하게 함
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO smtp_attachments (sha256, size, data) VALUES ($1, $2, $3)
+			ON CONFLICT (sha256) DO UPDATE SET sha256 = EXCLUDED.sha256`,
+			attachment.SHA256, attachment.Size, attachment.Data); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO smtp_message_attachments (message_id, position, sha256, filename, content_type)
+			VALUES ($1, $2, $3, $4, $5)`,
+			id, i, attachment.SHA256, pgText(attachment.Filename), pgText(attachment.ContentType)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// List는 조건에 맞는 메일 요약을 최신순으로 반환합니다
+func (s *PostgresMessageStore) List(ctx context.Context, q MessageQuery) ([]StoredMessage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var args []any
+	where := q.where(&args)
+	args = append(args, limit)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT m.id, m.received_at, m.session_id, m.mail_from, m.remote_ip, m.helo, m.spf,
+			m.message_id, m.header_from, m.subject, m.size,
+			ARRAY(SELECT address FROM smtp_message_recipients r WHERE r.message_id = m.id ORDER BY address)
+		FROM smtp_messages m
+		WHERE `+where+`
+		ORDER BY m.id DESC
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []StoredMessage
+	for rows.Next() {
+		var message StoredMessage
+		if err := scanMessage(rows, &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// Get은 메일 한 통을 헤더, 본문, 원본, 첨부파일 목록까지 반환합니다
+func (s *PostgresMessageStore) Get(ctx context.Context, id int64) (StoredMessage, error) {
+	var message StoredMessage
+	var headers []byte
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT m.id, m.received_at, m.session_id, m.mail_from, m.remote_ip, m.helo, m.spf,
+			m.message_id, m.header_from, m.subject, m.size,
+			ARRAY(SELECT address FROM smtp_message_recipients r WHERE r.message_id = m.id ORDER BY address),
+			m.headers, m.text_body, m.html_body, m.raw
+		FROM smtp_messages m
+		WHERE m.id = $1`, id)
+	err := scanMessage(row, &message, &headers, &message.TextBody, &message.HTMLBody, &message.Raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StoredMessage{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	if err := json.Unmarshal(headers, &message.Headers); err != nil {
+		return StoredMessage{}, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT ma.sha256, ma.filename, ma.content_type, a.size
+		FROM smtp_message_attachments ma
+		JOIN smtp_attachments a ON a.sha256 = ma.sha256
+		WHERE ma.message_id = $1
+		ORDER BY ma.position`, id)
+	if err != nil {
+		return StoredMessage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var attachment StoredAttachment
+		if err := rows.Scan(&attachment.SHA256, &attachment.Filename, &attachment.ContentType, &attachment.Size); err != nil {
+			return StoredMessage{}, err
+		}
+		message.Attachments = append(message.Attachments, attachment)
+	}
+	return message, rows.Err()
+}
+
+// Attachment는 SHA-256으로 첨부파일 내용을 반환합니다
+func (s *PostgresMessageStore) Attachment(ctx context.Context, sha256 string) ([]byte, error) {
+	var data []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT data FROM smtp_attachments WHERE sha256 = $1`, sha256).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	return data, err
+}
+
+// Delete는 메일 한 통을 지웁니다
+func (s *PostgresMessageStore) Delete(ctx context.Context, id int64) error {
+	deleted, err := s.delete(ctx, "m.id = $1", []any{id})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// DeleteMatching은 조건에 맞는 메일을 모두 지우고 지운 개수를 반환합니다 (Limit은 무시).
+// 조건이 하나도 없으면 전체가 지워지므로 호출하는 쪽에서 확인해야 합니다.
+func (s *PostgresMessageStore) DeleteMatching(ctx context.Context, q MessageQuery) (int64, error) {
+	var args []any
+	where := q.where(&args)
+	return s.delete(ctx, where, args)
+}
+
+// delete는 메일을 지우고, 더 이상 어떤 메일에도 붙어 있지 않은 첨부파일도 지웁니다
+func (s *PostgresMessageStore) delete(ctx context.Context, where string, args []any) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 본 쿼리는 DELETE 이전 스냅샷을 보므로, 지운 메일에 붙어 있던 첨부파일을 여기서 모을 수 있음
+	var deleted int64
+	var hashes []string
+	err = tx.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM smtp_messages m WHERE `+where+` RETURNING m.id
+		)
+		SELECT
+			(SELECT COUNT(*) FROM deleted),
+			ARRAY(SELECT DISTINCT ma.sha256 FROM smtp_message_attachments ma JOIN deleted d ON d.id = ma.message_id)`,
+		args...).Scan(&deleted, pq.Array(&hashes))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(hashes) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM smtp_attachments a
+			WHERE a.sha256 = ANY($1)
+			AND NOT EXISTS (SELECT 1 FROM smtp_message_attachments ma WHERE ma.sha256 = a.sha256)`,
+			pq.Array(hashes)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// scanMessage는 List/Get 공통 열(요약)과 extra 열을 읽습니다
+func scanMessage(row interface{ Scan(...any) error }, message *StoredMessage, extra ...any) error {
+	var remoteIP, spf string
+	dest := []any{
+		&message.ID, &message.ReceivedAt, &message.Envelope.SessionID, &message.Envelope.From,
+		&remoteIP, &message.Envelope.Helo, &spf,
+		&message.MessageID, &message.From, &message.Subject, &message.Size,
+		pq.Array(&message.Envelope.To),
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	message.Envelope.RemoteIP = net.ParseIP(remoteIP)
+	message.Envelope.SPF = SPFResult(spf)
+	return nil
+}
+
+// pgText는 Postgres text/jsonb에 넣을 수 없는 NUL 문자를 제거하고 잘못된 UTF-8을 U+FFFD로 바꿉니다
+// (charset을 모르는 본문, 디코딩하지 못한 8비트 헤더). 원본은 raw에 그대로 남습니다.
+func pgText(value string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(value, "\x00", ""), "\uFFFD")
+}
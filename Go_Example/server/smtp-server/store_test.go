@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMessageQueryWhere(t *testing.T) {
+	t.Parallel()
+
+	var args []any
+	assert.Equal(t, "TRUE", MessageQuery{Limit: 10}.where(&args))
+	assert.Empty(t, args)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	args = []any{"already"}
+	where := MessageQuery{Recipient: "Bob@Example.com", Since: since, BeforeID: 42}.where(&args)
+	assert.Equal(t, "m.id IN (SELECT message_id FROM smtp_message_recipients WHERE address = $2) AND m.received_at >= $3 AND m.id < $4", where)
+	assert.Equal(t, []any{"already", "bob@example.com", since, int64(42)}, args)
+}
+
+func TestPgText(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "테스트", pgText("테스트"))
+	assert.Equal(t, "ab", pgText("a\x00b"))
+	assert.Equal(t, "a\uFFFDb", pgText("a\xc5\xd7b"))
+}
+
+// openTestMessageStore는 SMTP_TEST_POSTGRES_DSN의 데이터베이스에 테스트 전용 스키마를 만들어 연결합니다.
+// 환경 변수가 없으면 테스트를 건너뜁니다.
+func openTestMessageStore(t *testing.T) *PostgresMessageStore {
+	t.Helper()
+
+	dsn := os.Getenv("SMTP_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("SMTP_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// search_path가 연결마다 설정되므로 연결을 하나만 씀
+	db.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("smtp_test_%d", time.Now().UnixNano())
+	_, err = db.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+	_, err = db.Exec(`SET search_path TO ` + schema)
+	require.NoError(t, err)
+
+	store := &PostgresMessageStore{DB: db}
+	require.NoError(t, store.Migrate(context.Background()))
+	// 두 번 실행해도 그대로
+	require.NoError(t, store.Migrate(context.Background()))
+	return store
+}
+
+func TestPostgresMessageStore(t *testing.T) {
+	store := openTestMessageStore(t)
+	ctx := context.Background()
+
+	parse := func(raw string) parsers.ParsedEmail {
+		parsed, err := parsers.ParseEmail(raw)
+		require.NoError(t, err)
+		return parsed
+	}
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := store.Save(ctx, &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"B@example.com", "c@other.org"}, RemoteIP: net.ParseIP("192.0.2.1"), Helo: "client", SPF: SPFPass, SessionID: "s1"},
+		Raw:      []byte(testAttachmentMessage),
+		Parsed:   parse(testAttachmentMessage),
+	}, day)
+	require.NoError(t, err)
+	second, err := store.Save(ctx, &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"b@example.com"}, SessionID: "s2"},
+		Raw:      []byte(testAttachmentMessage),
+		Parsed:   parse(testAttachmentMessage),
+	}, day.Add(24*time.Hour))
+	require.NoError(t, err)
+	_, err = store.Save(ctx, &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"c@other.org"}, SessionID: "s3"},
+		Raw:      []byte(testMessage),
+		Parsed:   parse(testMessage),
+	}, day.Add(48*time.Hour))
+	require.NoError(t, err)
+
+	// 같은 첨부파일은 한 번만 저장
+	var attachments int
+	require.NoError(t, store.DB.QueryRow(`SELECT COUNT(*) FROM smtp_attachments`).Scan(&attachments))
+	assert.Equal(t, 1, attachments)
+
+	listed, err := store.List(ctx, MessageQuery{Recipient: "b@EXAMPLE.com"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, second, listed[0].ID)
+	assert.Equal(t, first, listed[1].ID)
+	assert.Equal(t, []string{"b@example.com", "c@other.org"}, listed[1].Envelope.To)
+	assert.Equal(t, "report", listed[1].Subject)
+	assert.True(t, day.Equal(listed[1].ReceivedAt))
+
+	listed, err = store.List(ctx, MessageQuery{Since: day.Add(time.Hour), Until: day.Add(48 * time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, second, listed[0].ID)
+
+	listed, err = store.List(ctx, MessageQuery{Limit: 1, BeforeID: second})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, first, listed[0].ID)
+
+	message, err := store.Get(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, testAttachmentMessage, string(message.Raw))
+	assert.Equal(t, "192.0.2.1", message.Envelope.RemoteIP.String())
+	assert.Equal(t, SPFPass, message.Envelope.SPF)
+	assert.Equal(t, []string{"report"}, message.Headers["Subject"])
+	assert.Contains(t, message.TextBody, "see attached")
+	require.Len(t, message.Attachments, 1)
+	assert.Equal(t, "report.pdf", message.Attachments[0].Filename)
+
+	data, err := store.Attachment(ctx, message.Attachments[0].SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4", string(data))
+
+	// 다른 메일이 아직 참조하므로 첨부파일은 남음
+	require.NoError(t, store.Delete(ctx, first))
+	assert.ErrorIs(t, store.Delete(ctx, first), ErrMessageNotFound)
+	_, err = store.Get(ctx, first)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	_, err = store.Attachment(ctx, message.Attachments[0].SHA256)
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteMatching(ctx, MessageQuery{Recipient: "b@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = store.Attachment(ctx, message.Attachments[0].SHA256)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	listed, err = store.List(ctx, MessageQuery{})
+	require.NoError(t, err)
+	assert.Len(t, listed, 1)
+}
+
+func TestPostgresMessageStoreInvalidUTF8(t *testing.T) {
+	store := openTestMessageStore(t)
+	ctx := context.Background()
+
+	// charset 없는 EUC-KR 8비트 본문과 헤더 (파서가 디코딩하지 못하고 그대로 둠)
+	raw := "From: a@example.com\r\nTo: b@example.com\r\nSubject: \xc1\xa6\xb8\xf1\r\nContent-Type: text/plain\r\n\r\n\xc5\xd7\xbd\xba\xc6\xae\r\n"
+	parsed, err := parsers.ParseEmail(raw)
+	require.NoError(t, err)
+	id, err := store.Save(ctx, &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"b@example.com"}, SessionID: "s1"},
+		Raw:      []byte(raw),
+		Parsed:   parsed,
+	}, time.Now())
+	require.NoError(t, err)
+
+	message, err := store.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Contains(t, message.TextBody, "\uFFFD")
+	assert.Contains(t, message.Subject, "\uFFFD")
+	// 원본은 바이트 그대로
+	assert.Equal(t, raw, string(message.Raw))
+}
+
+func TestPostgresMessageStoreRetry(t *testing.T) {
+	store := openTestMessageStore(t)
+
+	upstream := NewTestServer(t, nil)
+	relay := newTestRelay(t, RelayRoute{Domains: []string{"*"}, Host: upstream.Addr, TLS: RelayPlaintext})
+	// 저장과 중계 뒤의 핸들러가 한 번 실패
+	last := &recordingHandler{errs: []error{TemporaryError("webhook is down")}}
+	srv := NewTestServer(t, &Backend{Handler: Chain(store, relay, last)})
+
+	message := "Message-ID: <retry-1@example.com>\r\n" + testMessage
+	assert.Error(t, sendMail(srv.Addr, "a@example.com", []string{"b@remote.example"}, message))
+	// 클라이언트가 새 세션으로 다시 보냄
+	require.NoError(t, sendMail(srv.Addr, "a@example.com", []string{"b@remote.example"}, message))
+
+	listed, err := store.List(context.Background(), MessageQuery{})
+	require.NoError(t, err)
+	assert.Len(t, listed, 1)
+	upstream.WaitMessages(t, 1, 5*time.Second)
+	require.Eventually(t, func() bool { return countFiles(t, filepath.Join(relay.Queue.Dir, spoolMsg)) == 0 }, 5*time.Second, 5*time.Millisecond)
+	assert.Len(t, upstream.Messages(), 1)
+}
+
+func TestPostgresConfigStores(t *testing.T) {
+	db := openTestMessageStore(t).DB
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE mailboxes (address text PRIMARY KEY);
+		INSERT INTO mailboxes VALUES ('sales@example.com');
+		CREATE TABLE smtp_users (
+			username        text PRIMARY KEY,
+			password_hash   text NOT NULL,
+			allowed_senders text[] NOT NULL DEFAULT '{}',
+			enabled         boolean NOT NULL DEFAULT true
+		)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO smtp_users (username, password_hash, allowed_senders) VALUES ('billing', $1, '{billing@example.com}')`, string(hash))
+	require.NoError(t, err)
+
+	config := DefaultConfig()
+	config.Greylisting = &GreylistConfig{Store: StorePostgres}
+	config.Recipients = &RecipientConfig{Domains: []string{"example.com"}, Lookup: StorePostgres}
+	config.CredentialsStore = StorePostgres
+
+	greylist := config.Greylist(db)
+	store, ok := greylist.Store.(*PostgresGreylistStore)
+	require.True(t, ok)
+	require.NoError(t, store.Migrate(ctx))
+	// 두 번 실행해도 그대로
+	require.NoError(t, store.Migrate(ctx))
+	ip := net.ParseIP("192.0.2.1")
+	assert.Equal(t, ErrGreylisted, greylist.Check(ctx, ip, "a@remote.example", "sales@example.com"))
+	// 다른 인스턴스가 받은 메일도 같은 테이블에 기록됨
+	config.Greylist(db).Pass(ctx, ip, "a@remote.example", "sales@example.com")
+	assert.NoError(t, greylist.Check(ctx, ip, "a@remote.example", "sales@example.com"))
+
+	recipients := config.RecipientPolicy(db)
+	assert.NoError(t, recipients.Check(ctx, "Sales@example.com"))
+	assert.Equal(t, ErrUnknownRecipient, recipients.Check(ctx, "nobody@example.com"))
+
+	credentials, err := config.CredentialStore(db)
+	require.NoError(t, err)
+	user, err := credentials.Authenticate(ctx, "billing", "s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"billing@example.com"}, user.AllowedSenders)
+	_, err = credentials.Authenticate(ctx, "billing", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = credentials.Authenticate(ctx, "nobody", "s3cret")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServer는 go test 안에서 띄우는 SMTP 서버입니다. 받은 메일을 기록하고, MAIL/RCPT/DATA에서 에러를 주입할 수 있습니다.
+// 이 패키지(main)의 테스트 전용입니다. main 패키지는 import할 수 없으므로 다른 패키지의 테스트에서는 쓸 수 없고,
+// 바이너리에 testing이 들어가지 않도록 _test.go 파일에 둡니다.
+//
+//	srv := NewTestServer(t, nil)
+//	srv.FailRcpt(func(from, to string) error { return PermanentError("no") })
+//	// ... srv.Addr로 메일 전송 ...
+//	msgs := srv.WaitMessages(t, 1, time.Second)
+type TestServer struct {
+	// 서버 주소 (127.0.0.1의 임의의 포트)
+	Addr    string
+	Backend *Backend
+
+	mu       sync.Mutex
+	messages []*Message
+	// 메일을 받을 때마다 닫고 새로 만듦 (WaitMessages가 기다림)
+	received chan struct{}
+	failMail func(from string) error
+	failRcpt func(from, to string) error
+	failData func(msg *Message) error
+}
+
+// NewTestServer는 backend로 서버를 띄우고 t.Cleanup에서 닫습니다 (backend가 nil이면 모든 메일을 받음).
+// backend.Handler가 있으면 주입한 DATA 에러가 없을 때 호출하고, 성공한 메일만 기록합니다.
+// backend.CheckMail/CheckRcpt는 장애 주입에 쓰므로 덮어씁니다.
+func NewTestServer(tb testing.TB, backend *Backend) *TestServer {
+	tb.Helper()
+
+	if backend == nil {
+		backend = &Backend{}
+	}
+	s := &TestServer{Backend: backend, received: make(chan struct{})}
+	handler := backend.Handler
+	backend.Handler = MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		if _, _, fail := s.hooks(); fail != nil {
+			if err := fail(msg); err != nil {
+				return err
+			}
+		}
+		if handler != nil {
+			if err := handler.HandleMessage(ctx, msg); err != nil {
+				return err
+			}
+		}
+		s.record(msg)
+		return nil
+	})
+	backend.CheckMail = func(from string) error {
+		if fail, _, _ := s.hooks(); fail != nil {
+			return fail(from)
+		}
+		return nil
+	}
+	backend.CheckRcpt = func(from, to string) error {
+		if _, fail, _ := s.hooks(); fail != nil {
+			return fail(from, to)
+		}
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("test server: %v", err)
+	}
+	server := smtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AllowInsecureAuth = true
+	server.EnableSMTPUTF8 = true
+	server.EnableBINARYMIME = true
+	go server.Serve(listener)
+	tb.Cleanup(func() { server.Close() })
+
+	s.Addr = listener.Addr().String()
+	return s
+}
+
+// hooks는 지금 주입된 장애 함수들입니다
+func (s *TestServer) hooks() (mail func(string) error, rcpt func(string, string) error, data func(*Message) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failMail, s.failRcpt, s.failData
+}
+
+// FailMail은 MAIL FROM마다 fail을 호출하고, 에러를 반환하면 그 에러로 거절합니다 (nil이면 해제).
+// *smtp.SMTPError가 아닌 에러는 451로 응답합니다.
+func (s *TestServer) FailMail(fail func(from string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failMail = fail
+}
+
+// FailRcpt는 RCPT TO마다 fail을 호출하고, 에러를 반환하면 그 수신자를 거절합니다 (nil이면 해제)
+func (s *TestServer) FailRcpt(fail func(from, to string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failRcpt = fail
+}
+
+// FailData는 DATA를 받을 때마다 fail을 호출하고, 에러를 반환하면 그 메일을 거절합니다 (nil이면 해제)
+func (s *TestServer) FailData(fail func(msg *Message) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failData = fail
+}
+
+func (s *TestServer) record(msg *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	close(s.received)
+	s.received = make(chan struct{})
+}
+
+// Messages는 지금까지 받은 메일입니다 (받은 순서)
+func (s *TestServer) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+// WaitMessages는 메일을 n통 이상 받을 때까지 기다렸다가 받은 메일을 반환합니다 (timeout이 지나면 tb.Fatal)
+func (s *TestServer) WaitMessages(tb testing.TB, n int, timeout time.Duration) []*Message {
+	tb.Helper()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		messages := append([]*Message(nil), s.messages...)
+		received := s.received
+		s.mu.Unlock()
+
+		if len(messages) >= n {
+			return messages
+		}
+		select {
+		case <-received:
+		case <-deadline.C:
+			tb.Fatalf("test server: received %d of %d messages in %s", len(messages), n, timeout)
+			return nil
+		}
+	}
+}
+
+// Reset은 받은 메일 기록과 주입한 장애를 지웁니다
+func (s *TestServer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.failMail, s.failRcpt, s.failData = nil, nil, nil
+}
+
+// Send는 STARTTLS 없이 평문으로 이 서버에 메일을 보냅니다
+func (s *TestServer) Send(from string, to []string, message string) error {
+	client, err := smtp.Dial(s.Addr)
+	if err != nil {
+		return fmt.Errorf("test server: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.SendMail(from, to, strings.NewReader(message)); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func TestTestServer(t *testing.T) {
+	t.Parallel()
+
+	srv := NewTestServer(t, nil)
+	require.NoError(t, srv.Send("a@example.com", []string{"b@example.com"}, testMessage))
+
+	messages := srv.WaitMessages(t, 1, 5*time.Second)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a@example.com", messages[0].Envelope.From)
+	assert.Equal(t, []string{"b@example.com"}, messages[0].Envelope.To)
+	assert.Equal(t, "hello", messages[0].Parsed.Subject)
+
+	// MAIL에서 거절
+	srv.FailMail(func(from string) error {
+		if from == "blocked@example.com" {
+			return PermanentError("sender blocked")
+		}
+		return nil
+	})
+	err := srv.Send("blocked@example.com", []string{"b@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Equal(t, "sender blocked", smtpErr.Message)
+
+	// RCPT에서 거절 (SMTPError가 아니면 451)
+	srv.FailRcpt(func(_, to string) error {
+		if strings.HasPrefix(to, "down@") {
+			return errors.New("mailbox backend down")
+		}
+		return nil
+	})
+	err = srv.Send("a@example.com", []string{"down@example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+
+	// DATA에서 거절하면 기록하지 않음
+	srv.FailData(func(msg *Message) error { return TemporaryError("try again") })
+	err = srv.Send("a@example.com", []string{"b@example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	assert.Len(t, srv.Messages(), 1)
+
+	srv.Reset()
+	assert.Empty(t, srv.Messages())
+	require.NoError(t, srv.Send("blocked@example.com", []string{"down@example.com"}, testMessage))
+	assert.Len(t, srv.WaitMessages(t, 1, 5*time.Second), 1)
+}
+
+func TestTestServerCallsHandler(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordingHandler{errs: []error{PermanentError("rejected")}}
+	srv := NewTestServer(t, &Backend{Handler: handler})
+
+	assert.Error(t, srv.Send("a@example.com", []string{"b@example.com"}, testMessage))
+	require.NoError(t, srv.Send("a@example.com", []string{"b@example.com"}, testMessage))
+
+	calls, delivered := handler.snapshot()
+	assert.Equal(t, 2, calls)
+	assert.Len(t, delivered, 1)
+	// handler가 거절한 메일은 기록하지 않음
+	assert.Len(t, srv.Messages(), 1)
+}
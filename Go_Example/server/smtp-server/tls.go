@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+var ErrTLSRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Must issue a STARTTLS command first",
+}
+
+// TLSPolicy는 평문 연결에서 허용할 명령을 정합니다
+type TLSPolicy struct {
+	// TLS 연결에서만 AUTH 허용 (평문 연결에서는 AUTH를 광고하지 않음)
+	RequireForAuth bool
+	// TLS 연결에서만 MAIL 허용
+	RequireForMail bool
+}
+
+// CertReloader는 인증서/키 파일이 바뀌면 재시작 없이 다시 읽습니다.
+// 핸드셰이크마다 파일 수정 시각을 확인하고, 새 파일을 읽지 못하면 기존 인증서를 계속 씁니다.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader는 인증서를 처음 한 번 읽어서 CertReloader를 만듭니다
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate는 tls.Config.GetCertificate로 씁니다
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		slog.Warn("인증서 파일 확인 실패, 기존 인증서 사용", "error", err)
+		return r.cert, nil
+	}
+	if !modTime.Equal(r.modTime) {
+		if err := r.load(modTime); err != nil {
+			slog.Warn("인증서 재로드 실패, 기존 인증서 사용", "error", err)
+		} else {
+			slog.Info("인증서 재로드", "cert_file", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// TLSConfig는 이 CertReloader를 쓰는 서버용 tls.Config를 반환합니다
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// lastModified는 인증서와 키 중 더 최근의 수정 시각을 반환합니다
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSignedCert는 127.0.0.1용 self-signed 인증서를 certFile/keyFile에 씁니다
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	// 같은 초 안에 다시 써도 수정 시각이 바뀌도록
+	modTime := time.Now().Add(time.Duration(serial) * time.Second)
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func startTLSServer(t *testing.T, backend *Backend) (plainAddr, tlsAddr, certFile, keyFile string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, 1)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	server := smtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AllowInsecureAuth = true
+	server.TLSConfig = reloader.TLSConfig()
+	t.Cleanup(func() { server.Close() })
+
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	implicit, err := tls.Listen("tcp", "127.0.0.1:0", server.TLSConfig)
+	require.NoError(t, err)
+	go server.Serve(plain)
+	go server.Serve(implicit)
+
+	return plain.Addr().String(), implicit.Addr().String(), certFile, keyFile
+}
+
+func TestTLSPolicy(t *testing.T) {
+	t.Parallel()
+
+	store, err := LoadFileCredentialStore(credentialFile(t))
+	require.NoError(t, err)
+	plainAddr, tlsAddr, _, _ := startTLSServer(t, &Backend{
+		Credentials: store,
+		TLSPolicy:   TLSPolicy{RequireForAuth: true, RequireForMail: true},
+	})
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+
+	// 평문: AUTH 광고 안 함, MAIL 거절
+	client, err := smtp.Dial(plainAddr)
+	require.NoError(t, err)
+	require.NoError(t, client.Hello("localhost"))
+	ok, _ := client.Extension("STARTTLS")
+	assert.True(t, ok)
+	assert.False(t, client.SupportsAuth(sasl.Plain))
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, client.Mail("billing@example.com", nil), &smtpErr)
+	assert.Equal(t, 530, smtpErr.Code)
+	client.Close()
+
+	// STARTTLS 후에는 AUTH와 MAIL 허용
+	client, err = smtp.DialStartTLS(plainAddr, clientTLS)
+	require.NoError(t, err)
+	assert.True(t, client.SupportsAuth(sasl.Plain))
+	require.NoError(t, client.Auth(sasl.NewPlainClient("", "billing", "s3cret")))
+	require.NoError(t, client.SendMail("billing@example.com", []string{"b@example.com"}, strings.NewReader(testMessage)))
+	client.Close()
+
+	// implicit TLS
+	client, err = smtp.DialTLS(tlsAddr, clientTLS)
+	require.NoError(t, err)
+	require.NoError(t, client.SendMail("billing@example.com", []string{"b@example.com"}, strings.NewReader(testMessage)))
+	client.Close()
+}
+
+func TestCertReload(t *testing.T) {
+	t.Parallel()
+
+	_, tlsAddr, certFile, keyFile := startTLSServer(t, &Backend{})
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", tlsAddr, &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	assert.Equal(t, int64(1), serial())
+	writeSelfSignedCert(t, certFile, keyFile, 2)
+	assert.Equal(t, int64(2), serial())
+
+	// 깨진 파일로 바뀌면 기존 인증서를 계속 사용
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Equal(t, int64(2), serial())
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/looko-corp/acloset-api/pkg/parsers"
+)
+
+// 웹훅 요청 헤더
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookFromHeader      = "X-Webhook-From"
+	WebhookToHeader        = "X-Webhook-To"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	ErrWebhookSignature = errors.New("webhook: invalid signature")
+	ErrWebhookExpired   = errors.New("webhook: timestamp outside tolerance")
+)
+
+// WebhookFormat은 웹훅 본문 형식입니다
+type WebhookFormat string
+
+const (
+	// WebhookJSON은 봉투와 파싱한 메일을 JSON(WebhookPayload)으로 보냅니다
+	WebhookJSON WebhookFormat = "json"
+	// WebhookRaw는 원본 MIME을 message/rfc822로 보냅니다 (봉투는 X-Webhook-From/To 헤더로만 전달)
+	WebhookRaw WebhookFormat = "raw"
+)
+
+// WebhookTarget은 웹훅을 받을 URL 하나입니다
+type WebhookTarget struct {
+	URL string
+	// HMAC-SHA256 서명 키 (비어 있으면 서명하지 않음)
+	Secret string
+	// 비어 있으면 WebhookJSON
+	Format WebhookFormat
+	// true면 첨부파일 내용을 base64로 넣고, false면 메타데이터(SHA-256 참조)만 넣음
+	InlineAttachments bool
+}
+
+// WebhookPayload는 WebhookJSON 형식의 본문입니다
+type WebhookPayload struct {
+	// 같은 메일을 재전송해도 같은 값 (Message.DedupKey로 정함, 수신 측 중복 제거용)
+	ID       string              `json:"id"`
+	Envelope WebhookEnvelope     `json:"envelope"`
+	Email    parsers.ParsedEmail `json:"email"`
+	// InlineAttachments일 때 첨부파일 SHA-256 → base64 내용
+	AttachmentContents map[string]string `json:"attachment_contents,omitempty"`
+}
+
+// WebhookEnvelope은 웹훅에 넣는 봉투 정보입니다 (수신자는 그 URL로 라우팅된 것만)
+type WebhookEnvelope struct {
+	From      string   `json:"from"`
+	To        []string `json:"to"`
+	RemoteIP  string   `json:"remote_ip"`
+	Helo      string   `json:"helo"`
+	SPF       string   `json:"spf,omitempty"`
+	SessionID string   `json:"session_id"`
+}
+
+// WebhookHandler는 받은 메일을 수신 도메인별로 설정한 URL에 POST합니다.
+// 실패하면 지수 백오프로 몇 번 재시도한 뒤, 일시적 실패는 451로 돌려서 클라이언트(또는 스풀)가 다시 보내게 합니다.
+// 다시 받은 메일은 이미 성공한 URL에는 보내지 않지만 이 기록은 프로세스 안에만 있으므로,
+// 재시작하거나 다른 인스턴스가 받으면 다시 갈 수 있습니다. 대상은 ID(X-Webhook-Id)로 중복을 걸러야 합니다.
+type WebhookHandler struct {
+	// 수신 도메인 → 대상 ("*"는 다른 어디에도 해당하지 않는 도메인)
+	Routes map[string][]WebhookTarget
+	Client *http.Client
+
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// 메일 한 통의 모든 전달과 재시도에 쓰는 최대 시간 (DATA 응답이 클라이언트 타임아웃보다 늦지 않도록)
+	MaxElapsed time.Duration
+	// 성공한 전달을 기억하는 시간 (기본값 24시간)
+	DeliveredTTL time.Duration
+
+	mu sync.Mutex
+	// DedupKey + URL → 성공한 시각
+	delivered map[string]time.Time
+	pruned    time.Time
+}
+
+// NewWebhookHandler는 기본 재시도 설정의 WebhookHandler를 만듭니다
+func NewWebhookHandler(routes map[string][]WebhookTarget) *WebhookHandler {
+	return &WebhookHandler{
+		Routes:      routes,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    10 * time.Second,
+		MaxElapsed:  time.Minute,
+	}
+}
+
+func (h *WebhookHandler) HandleMessage(ctx context.Context, msg *Message) error {
+	id := webhookID(msg)
+	key := msg.DedupKey()
+	if h.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.MaxElapsed)
+		defer cancel()
+	}
+
+	// URL별로 해당하는 수신자를 모음
+	type delivery struct {
+		target WebhookTarget
+		to     []string
+	}
+	var deliveries []*delivery
+	byURL := map[string]*delivery{}
+	for _, recipient := range msg.Envelope.To {
+		for _, target := range h.targets(recipient) {
+			d, ok := byURL[target.URL]
+			if !ok {
+				d = &delivery{target: target}
+				byURL[target.URL] = d
+				deliveries = append(deliveries, d)
+			}
+			d.to = append(d.to, recipient)
+		}
+	}
+
+	for _, d := range deliveries {
+		if h.wasDelivered(key, d.target.URL) {
+			slog.Info("웹훅 이미 전달함", "session", msg.Envelope.SessionID, "url", d.target.URL, "id", id)
+			continue
+		}
+		body, contentType, err := webhookBody(msg, id, d.to, d.target)
+		if err != nil {
+			return err
+		}
+		if err := h.deliver(ctx, d.target, id, d.to, msg, body, contentType); err != nil {
+			slog.Error("웹훅 전달 실패", "session", msg.Envelope.SessionID, "url", d.target.URL, "error", err)
+			return err
+		}
+		h.markDelivered(key, d.target.URL)
+		slog.Info("웹훅 전달", "session", msg.Envelope.SessionID, "url", d.target.URL, "id", id)
+	}
+	return nil
+}
+
+// wasDelivered는 key의 메일을 url에 이미 전달했는지 확인합니다 (key가 비어 있으면 false)
+func (h *WebhookHandler) wasDelivered(key, url string) bool {
+	if key == "" {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	at, ok := h.delivered[key+"\n"+url]
+	return ok && time.Since(at) < h.deliveredTTL()
+}
+
+// markDelivered는 key의 메일을 url에 전달했다고 기록하고, 가끔 만료된 기록을 지웁니다
+func (h *WebhookHandler) markDelivered(key, url string) {
+	if key == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if h.delivered == nil {
+		h.delivered = map[string]time.Time{}
+	}
+	h.delivered[key+"\n"+url] = now
+	if now.Sub(h.pruned) > time.Minute {
+		h.pruned = now
+		for k, at := range h.delivered {
+			if now.Sub(at) >= h.deliveredTTL() {
+				delete(h.delivered, k)
+			}
+		}
+	}
+}
+
+func (h *WebhookHandler) deliveredTTL() time.Duration {
+	return orDefaultDuration(h.DeliveredTTL, 24*time.Hour)
+}
+
+func (h *WebhookHandler) targets(recipient string) []WebhookTarget {
+	domain := recipient
+	if idx := strings.LastIndex(recipient, "@"); idx != -1 {
+		domain = recipient[idx+1:]
+	}
+	for routeDomain, targets := range h.Routes {
+		if routeDomain != "*" && strings.EqualFold(routeDomain, domain) {
+			return targets
+		}
+	}
+	return h.Routes["*"]
+}
+
+// deliver는 한 URL에 재시도하며 POST합니다
+func (h *WebhookHandler) deliver(ctx context.Context, target WebhookTarget, id string, to []string, msg *Message, body []byte, contentType string) error {
+	delay := h.BaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = h.post(ctx, target, id, to, msg, body, contentType)
+		if err == nil || !retry {
+			return err
+		}
+		if attempt >= h.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return TemporaryError("Webhook delivery interrupted")
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > h.MaxDelay {
+			delay = h.MaxDelay
+		}
+	}
+	return fmt.Errorf("%w: %v", TemporaryError("Webhook delivery failed, try again later"), err)
+}
+
+// post는 요청을 한 번 보냅니다. 재시도할 만한 실패면 retry가 true입니다.
+func (h *WebhookHandler) post(ctx context.Context, target WebhookTarget, id string, to []string, msg *Message, body []byte, contentType string) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(WebhookIDHeader, id)
+	req.Header.Set(WebhookFromHeader, msg.Envelope.From)
+	req.Header.Set(WebhookToHeader, strings.Join(to, ","))
+	if target.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(target.Secret, timestamp, req.Header, body))
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook %s: %s", target.URL, resp.Status)
+	default:
+		// 그 밖의 4xx는 다시 보내도 같은 결과이므로 영구 실패
+		return false, PermanentError(fmt.Sprintf("Webhook rejected message: %s", resp.Status))
+	}
+}
+
+// webhookBody는 target 형식에 맞는 요청 본문을 만듭니다
+func webhookBody(msg *Message, id string, to []string, target WebhookTarget) ([]byte, string, error) {
+	if target.Format == WebhookRaw {
+		return msg.Raw, "message/rfc822", nil
+	}
+
+	payload := WebhookPayload{
+		ID: id,
+		Envelope: WebhookEnvelope{
+			From:      msg.Envelope.From,
+			To:        to,
+			RemoteIP:  msg.Envelope.RemoteIP.String(),
+			Helo:      msg.Envelope.Helo,
+			SPF:       string(msg.Envelope.SPF),
+			SessionID: msg.Envelope.SessionID,
+		},
+		Email: msg.Parsed,
+	}
+	if target.InlineAttachments && len(msg.Parsed.Attachments) > 0 {
+		payload.AttachmentContents = map[string]string{}
+		for _, attachment := range msg.Parsed.Attachments {
+			payload.AttachmentContents[attachment.SHA256] = base64.StdEncoding.EncodeToString(attachment.Data)
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, "application/json", nil
+}
+
+// webhookID는 메일로 정해지는 전달 ID입니다. Message.DedupKey로 정하고, 비어 있으면 원본의 해시를 씁니다
+// (원본에는 세션마다 다른 Received 헤더가 붙으므로 재전송하면 달라짐).
+func webhookID(msg *Message) string {
+	if key := msg.DedupKey(); key != "" {
+		return key[:32]
+	}
+	sum := sha256.Sum256(msg.Raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// SignWebhook은 "sha256=" + hex(HMAC-SHA256(secret, timestamp, ID, From, To 헤더와 body를 "\n"으로 이은 것))을 반환합니다.
+// 헤더 값에는 줄바꿈이 들어갈 수 없으므로 구분자로 씁니다 (주소에 들어가는 "."로는 From과 To의 경계를 옮길 수 있음).
+func SignWebhook(secret, timestamp string, header http.Header, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, value := range []string{timestamp, header.Get(WebhookIDHeader), header.Get(WebhookFromHeader), header.Get(WebhookToHeader)} {
+		mac.Write([]byte(value))
+		mac.Write([]byte("\n"))
+	}
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook은 수신 측에서 서명과 타임스탬프를 확인합니다. ID, From, To 헤더도 서명에 들어가므로 바꾸면 실패합니다.
+// 타임스탬프가 now에서 tolerance보다 멀면 재전송 공격으로 보고 거절합니다.
+func VerifyWebhook(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp := header.Get(WebhookTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrWebhookExpired
+	}
+
+	expected := SignWebhook(secret, timestamp, header, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(WebhookSignatureHeader))) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
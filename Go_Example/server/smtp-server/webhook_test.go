@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAttachmentMessage = "From: a@example.com\r\n" +
+	"To: b@example.com, c@other.org\r\n" +
+	"Subject: report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=XYZ\r\n" +
+	"\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"see attached\r\n" +
+	"--XYZ\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=report.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQ=\r\n" +
+	"--XYZ--\r\n"
+
+// webhookRecorder는 받은 요청을 기록하고 statuses를 차례로 응답하는 httptest 서버입니다
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *webhookRecorder) snapshot() ([]*http.Request, [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*http.Request(nil), r.requests...), append([][]byte(nil), r.bodies...)
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) (*webhookRecorder, string) {
+	t.Helper()
+	recorder := &webhookRecorder{statuses: statuses}
+	server := httptest.NewServer(recorder)
+	t.Cleanup(server.Close)
+	return recorder, server.URL
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+
+	jsonHook, jsonURL := newWebhookServer(t)
+	rawHook, rawURL := newWebhookServer(t)
+	handler := NewWebhookHandler(map[string][]WebhookTarget{
+		"example.com": {{URL: jsonURL, Secret: "s3cret", InlineAttachments: true}},
+		"*":           {{URL: rawURL, Format: WebhookRaw}},
+	})
+	addr := startServer(t, handler)
+
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com", "c@other.org"}, testAttachmentMessage))
+
+	// 수신 도메인별로 해당 수신자만 담아 전달
+	requests, bodies := jsonHook.snapshot()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+	require.NoError(t, VerifyWebhook("s3cret", requests[0].Header, bodies[0], 5*time.Minute, time.Now()))
+	assert.ErrorIs(t, VerifyWebhook("wrong", requests[0].Header, bodies[0], 5*time.Minute, time.Now()), ErrWebhookSignature)
+	assert.ErrorIs(t, VerifyWebhook("s3cret", requests[0].Header, bodies[0], 5*time.Minute, time.Now().Add(time.Hour)), ErrWebhookExpired)
+	// 봉투 헤더를 바꾸면 서명이 맞지 않음
+	assert.Equal(t, "a@example.com", requests[0].Header.Get(WebhookFromHeader))
+	assert.Equal(t, "b@example.com", requests[0].Header.Get(WebhookToHeader))
+	for name, value := range map[string]string{
+		WebhookIDHeader:   "0123456789abcdef",
+		WebhookFromHeader: "ceo@example.com",
+		WebhookToHeader:   "b@example.com,attacker@evil.example",
+	} {
+		tampered := requests[0].Header.Clone()
+		tampered.Set(name, value)
+		assert.ErrorIs(t, VerifyWebhook("s3cret", tampered, bodies[0], 5*time.Minute, time.Now()), ErrWebhookSignature, name)
+	}
+	// From과 To 사이의 경계를 옮겨도 실패
+	tampered := requests[0].Header.Clone()
+	tampered.Set(WebhookFromHeader, "a@example")
+	tampered.Set(WebhookToHeader, "com.b@example.com")
+	assert.ErrorIs(t, VerifyWebhook("s3cret", tampered, bodies[0], 5*time.Minute, time.Now()), ErrWebhookSignature)
+
+	var payload WebhookPayload
+	require.NoError(t, json.Unmarshal(bodies[0], &payload))
+	assert.Equal(t, requests[0].Header.Get(WebhookIDHeader), payload.ID)
+	assert.Equal(t, []string{"b@example.com"}, payload.Envelope.To)
+	assert.NotEmpty(t, payload.Envelope.SessionID)
+	assert.Equal(t, "report", payload.Email.Subject)
+	require.Len(t, payload.Email.Attachments, 1)
+	content, err := base64.StdEncoding.DecodeString(payload.AttachmentContents[payload.Email.Attachments[0].SHA256])
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4", string(content))
+
+	requests, bodies = rawHook.snapshot()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "message/rfc822", requests[0].Header.Get("Content-Type"))
+	assert.Equal(t, "c@other.org", requests[0].Header.Get("X-Webhook-To"))
+	assert.Empty(t, requests[0].Header.Get(WebhookSignatureHeader))
+	assert.Contains(t, string(bodies[0]), testAttachmentMessage)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(url string) *WebhookHandler {
+		handler := NewWebhookHandler(map[string][]WebhookTarget{"*": {{URL: url}}})
+		handler.BaseDelay = time.Millisecond
+		return handler
+	}
+
+	// 일시적 실패 후 성공
+	flaky, flakyURL := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	require.NoError(t, sendMail(startServer(t, newHandler(flakyURL)), "a@example.com", []string{"b@example.com"}, testMessage))
+	requests, _ := flaky.snapshot()
+	assert.Len(t, requests, 3)
+
+	// 재시도를 다 써도 실패하면 451
+	down, downURL := newWebhookServer(t, 500, 500, 500)
+	err := sendMail(startServer(t, newHandler(downURL)), "a@example.com", []string{"b@example.com"}, testMessage)
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	requests, _ = down.snapshot()
+	assert.Len(t, requests, 3)
+
+	// 4xx는 재시도하지 않고 550
+	rejecting, rejectingURL := newWebhookServer(t, http.StatusUnprocessableEntity)
+	err = sendMail(startServer(t, newHandler(rejectingURL)), "a@example.com", []string{"b@example.com"}, testMessage)
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 550, smtpErr.Code)
+	requests, _ = rejecting.snapshot()
+	assert.Len(t, requests, 1)
+}
+
+func TestWebhookRetriedMessage(t *testing.T) {
+	t.Parallel()
+
+	// 첫 번째 대상은 성공하고 두 번째 대상이 한 번 실패
+	first, firstURL := newWebhookServer(t)
+	second, secondURL := newWebhookServer(t, http.StatusServiceUnavailable)
+	handler := NewWebhookHandler(map[string][]WebhookTarget{"*": {{URL: firstURL}, {URL: secondURL, Format: WebhookRaw}}})
+	handler.MaxAttempts = 1
+	addr := startServer(t, handler)
+
+	message := "Message-ID: <webhook-1@example.com>\r\n" + testMessage
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, message), &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	// 클라이언트가 새 세션으로 다시 보냄 (Received 헤더가 달라짐)
+	require.NoError(t, sendMail(addr, "a@example.com", []string{"b@example.com"}, message))
+
+	// 이미 받은 대상에는 다시 보내지 않음
+	requests, _ := first.snapshot()
+	require.Len(t, requests, 1)
+	retried, _ := second.snapshot()
+	require.Len(t, retried, 2)
+	// 세션이 달라도 같은 ID
+	id := requests[0].Header.Get(WebhookIDHeader)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, id, retried[0].Header.Get(WebhookIDHeader))
+	assert.Equal(t, id, retried[1].Header.Get(WebhookIDHeader))
+}
+
+func TestWebhookMaxElapsed(t *testing.T) {
+	t.Parallel()
+
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(hang) })
+
+	handler := NewWebhookHandler(map[string][]WebhookTarget{"*": {{URL: server.URL}}})
+	handler.BaseDelay = time.Millisecond
+	handler.MaxElapsed = 200 * time.Millisecond
+
+	// 응답하지 않는 대상이어도 MaxElapsed 안에 451
+	start := time.Now()
+	err := handler.HandleMessage(context.Background(), &Message{
+		Envelope: Envelope{From: "a@example.com", To: []string{"b@example.com"}},
+		Raw:      []byte(testMessage),
+	})
+	var smtpErr *smtp.SMTPError
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, 451, smtpErr.Code)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}